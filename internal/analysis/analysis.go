@@ -0,0 +1,188 @@
+// Package analysis compares experiment variants to a control: lift, confidence
+// intervals, p-values and the Bayesian probability to beat the control.
+package analysis
+
+import (
+	"math"
+)
+
+type Metric string
+
+const (
+	MetricConversionRate  Metric = "conversion_rate"   // conversions per unique user
+	MetricErrorRate       Metric = "error_rate"        // errors per request
+	MetricRequestsPerUser Metric = "requests_per_user" // requests per unique user
+)
+
+func (m Metric) IsValid() bool {
+	switch m {
+	case MetricConversionRate, MetricErrorRate, MetricRequestsPerUser:
+		return true
+	}
+	return false
+}
+
+// LowerIsBetter reports whether a variant beats the control by a lower value
+func (m Metric) LowerIsBetter() bool {
+	return m == MetricErrorRate
+}
+
+// isProportion reports whether the metric is a share of trials (binomial), as
+// opposed to a count per trial (Poisson)
+func (m Metric) isProportion() bool {
+	return m != MetricRequestsPerUser
+}
+
+// Sample holds the observations of one variant: Events out of Trials, e.g.
+// conversions out of users or requests out of users
+type Sample struct {
+	TargetID string  `json:"target_id"`
+	Trials   int64   `json:"trials"`
+	Events   int64   `json:"events"`
+	Value    float64 `json:"value"` // Events / Trials
+}
+
+func NewSample(targetID string, trials, events int64) Sample {
+	s := Sample{TargetID: targetID, Trials: trials, Events: events}
+	if trials > 0 {
+		s.Value = float64(events) / float64(trials)
+	}
+	return s
+}
+
+// Interval is a confidence interval
+type Interval struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
+// Comparison is a variant compared to the control. Statistics are only
+// computed when both have trials, Lift only when the control value is not zero.
+type Comparison struct {
+	Sample
+	SufficientData bool      `json:"sufficient_data"`
+	Difference     float64   `json:"difference"` // variant value - control value
+	DifferenceCI   Interval  `json:"difference_ci"`
+	Lift           *float64  `json:"lift,omitempty"`    // difference relative to the control value
+	LiftCI         *Interval `json:"lift_ci,omitempty"` // difference CI relative to the control value
+	ZScore         float64   `json:"z_score"`
+	PValue         float64   `json:"p_value"` // two-sided
+	// Chi-squared test of the 2x2 contingency table, for proportions only
+	ChiSquared       *float64 `json:"chi_squared,omitempty"`
+	ChiSquaredPValue *float64 `json:"chi_squared_p_value,omitempty"`
+	ProbBeatControl  float64  `json:"prob_beat_control"`
+	Significant      bool     `json:"significant"` // p-value below 1 - confidence
+}
+
+type Result struct {
+	Metric        Metric       `json:"metric"`
+	Confidence    float64      `json:"confidence"`
+	LowerIsBetter bool         `json:"lower_is_better"`
+	Control       Sample       `json:"control"`
+	Variants      []Comparison `json:"variants"`
+}
+
+// Compare compares every variant to the control at the given confidence level
+// (e.g. 0.95).
+//
+// Proportions (conversion and error rates) use the unpooled Wald interval for
+// the difference, the pooled two-proportion z-test, the Pearson chi-squared test
+// and Beta(1+events, 1+trials-events) posteriors. Requests per user is treated
+// as a Poisson rate per user with Gamma(1+events, trials) posteriors.
+// The lift interval is the difference interval divided by the control value.
+func Compare(metric Metric, control Sample, variants []Sample, confidence float64) *Result {
+	result := &Result{
+		Metric:        metric,
+		Confidence:    confidence,
+		LowerIsBetter: metric.LowerIsBetter(),
+		Control:       control,
+		Variants:      make([]Comparison, 0, len(variants)),
+	}
+
+	// Critical value of the two-sided interval
+	z := math.Sqrt2 * math.Erfinv(confidence)
+
+	for _, variant := range variants {
+		result.Variants = append(result.Variants, compare(metric, control, variant, z, 1-confidence))
+	}
+	return result
+}
+
+func compare(metric Metric, control, variant Sample, z, alpha float64) Comparison {
+	c := Comparison{Sample: variant, PValue: 1}
+	if control.Trials == 0 || variant.Trials == 0 {
+		return c
+	}
+	c.SufficientData = true
+
+	if metric.isProportion() {
+		// More events than trials (e.g. several conversions per request) are capped
+		control, variant = capEvents(control), capEvents(variant)
+	}
+
+	pc, pv := control.Value, variant.Value
+	nc, nv := float64(control.Trials), float64(variant.Trials)
+	xc, xv := float64(control.Events), float64(variant.Events)
+
+	c.Difference = pv - pc
+
+	var se, pooledSE float64
+	if metric.isProportion() {
+		se = math.Sqrt(pc*(1-pc)/nc + pv*(1-pv)/nv)
+		pooled := (xc + xv) / (nc + nv)
+		pooledSE = math.Sqrt(pooled * (1 - pooled) * (1/nc + 1/nv))
+
+		chi := chiSquared2x2(xc, nc-xc, xv, nv-xv)
+		chiP := math.Erfc(math.Sqrt(chi / 2)) // one degree of freedom
+		c.ChiSquared, c.ChiSquaredPValue = &chi, &chiP
+	} else {
+		se = math.Sqrt(pc/nc + pv/nv)
+		pooled := (xc + xv) / (nc + nv)
+		pooledSE = math.Sqrt(pooled * (1/nc + 1/nv))
+	}
+
+	c.DifferenceCI = Interval{Lower: c.Difference - z*se, Upper: c.Difference + z*se}
+	if pc != 0 {
+		lift := c.Difference / pc
+		c.Lift = &lift
+		c.LiftCI = &Interval{Lower: c.DifferenceCI.Lower / pc, Upper: c.DifferenceCI.Upper / pc}
+	}
+
+	if pooledSE > 0 {
+		c.ZScore = c.Difference / pooledSE
+		c.PValue = math.Erfc(math.Abs(c.ZScore) / math.Sqrt2)
+	}
+	c.Significant = c.PValue < alpha
+
+	c.ProbBeatControl = probBeatControl(metric, control, variant)
+	return c
+}
+
+func capEvents(s Sample) Sample {
+	if s.Events > s.Trials {
+		return NewSample(s.TargetID, s.Trials, s.Trials)
+	}
+	return s
+}
+
+// chiSquared2x2 returns the Pearson chi-squared statistic of the table
+// [[a, b], [c, d]] (control successes, failures; variant successes, failures)
+func chiSquared2x2(a, b, c, d float64) float64 {
+	n := a + b + c + d
+	rows := [2]float64{a + b, c + d}
+	cols := [2]float64{a + c, b + d}
+	observed := [2][2]float64{{a, b}, {c, d}}
+
+	var chi float64
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			expected := rows[i] * cols[j] / n
+			if expected == 0 {
+				continue
+			}
+			diff := observed[i][j] - expected
+			chi += diff * diff / expected
+		}
+	}
+	return chi
+}
@@ -0,0 +1,171 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   Metric
+		control  Sample
+		variant  Sample
+		want     Comparison
+		wantChi  float64 // ignored for rates per user
+		wantLift float64
+		// Bounds of the probability to beat the control
+		beatMin, beatMax float64
+	}{
+		{
+			// prop.test(c(100, 130), c(1000, 1000), correct = FALSE) in R
+			name:    "conversion rate higher",
+			metric:  MetricConversionRate,
+			control: NewSample("control", 1000, 100),
+			variant: NewSample("variant", 1000, 130),
+			want: Comparison{
+				SufficientData: true,
+				Difference:     0.03,
+				DifferenceCI:   Interval{Lower: 0.0020679, Upper: 0.0579321},
+				ZScore:         2.1027406,
+				PValue:         0.0354885,
+				Significant:    true,
+			},
+			wantChi:  4.4215181,
+			wantLift: 0.3,
+			beatMin:  0.97,
+			beatMax:  1,
+		},
+		{
+			name:    "error rate lower",
+			metric:  MetricErrorRate,
+			control: NewSample("control", 2000, 40),
+			variant: NewSample("variant", 2000, 24),
+			want: Comparison{
+				SufficientData: true,
+				Difference:     -0.008,
+				DifferenceCI:   Interval{Lower: -0.0157729, Upper: -0.0002271},
+				ZScore:         -2.0161946,
+				PValue:         0.0437796,
+				Significant:    true,
+			},
+			wantChi:  4.0650407,
+			wantLift: -0.4,
+			beatMin:  0.97,
+			beatMax:  1,
+		},
+		{
+			name:    "same rate",
+			metric:  MetricConversionRate,
+			control: NewSample("control", 500, 50),
+			variant: NewSample("variant", 500, 50),
+			want: Comparison{
+				SufficientData: true,
+				DifferenceCI:   Interval{Lower: -0.0371880, Upper: 0.0371880},
+				PValue:         1,
+			},
+			beatMin: 0.45,
+			beatMax: 0.55,
+		},
+		{
+			name:    "requests per user",
+			metric:  MetricRequestsPerUser,
+			control: NewSample("control", 1000, 3000),
+			variant: NewSample("variant", 1000, 3200),
+			want: Comparison{
+				SufficientData: true,
+				Difference:     0.2,
+				DifferenceCI:   Interval{Lower: 0.0456723, Upper: 0.3543277},
+				ZScore:         2.5400025,
+				PValue:         0.0110852,
+				Significant:    true,
+			},
+			wantLift: 0.2 / 3,
+			beatMin:  0.99,
+			beatMax:  1,
+		},
+		{
+			name:    "no control trials",
+			metric:  MetricConversionRate,
+			control: NewSample("control", 0, 0),
+			variant: NewSample("variant", 100, 10),
+			want:    Comparison{PValue: 1},
+		},
+	}
+
+	const tolerance = 1e-6
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Compare(tt.metric, tt.control, []Sample{tt.variant}, 0.95)
+			if len(result.Variants) != 1 {
+				t.Fatalf("got %d comparisons, want 1", len(result.Variants))
+			}
+			if result.LowerIsBetter != (tt.metric == MetricErrorRate) {
+				t.Errorf("LowerIsBetter = %v", result.LowerIsBetter)
+			}
+			got := result.Variants[0]
+
+			if got.SufficientData != tt.want.SufficientData {
+				t.Errorf("SufficientData = %v, want %v", got.SufficientData, tt.want.SufficientData)
+			}
+			if got.Significant != tt.want.Significant {
+				t.Errorf("Significant = %v, want %v", got.Significant, tt.want.Significant)
+			}
+			for _, v := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"Difference", got.Difference, tt.want.Difference},
+				{"DifferenceCI.Lower", got.DifferenceCI.Lower, tt.want.DifferenceCI.Lower},
+				{"DifferenceCI.Upper", got.DifferenceCI.Upper, tt.want.DifferenceCI.Upper},
+				{"ZScore", got.ZScore, tt.want.ZScore},
+				{"PValue", got.PValue, tt.want.PValue},
+			} {
+				if math.Abs(v.got-v.want) > tolerance {
+					t.Errorf("%s = %.7f, want %.7f", v.name, v.got, v.want)
+				}
+			}
+
+			if !got.SufficientData {
+				return
+			}
+
+			if tt.metric.isProportion() {
+				if got.ChiSquared == nil || got.ChiSquaredPValue == nil {
+					t.Fatal("chi-squared test missing")
+				}
+				if math.Abs(*got.ChiSquared-tt.wantChi) > tolerance {
+					t.Errorf("ChiSquared = %.7f, want %.7f", *got.ChiSquared, tt.wantChi)
+				}
+				// One degree of freedom: the chi-squared test is the squared z-test
+				if math.Abs(*got.ChiSquaredPValue-got.PValue) > tolerance {
+					t.Errorf("ChiSquaredPValue = %.7f, want the z-test p-value %.7f", *got.ChiSquaredPValue, got.PValue)
+				}
+			} else if got.ChiSquared != nil {
+				t.Error("chi-squared test computed for a rate per user")
+			}
+
+			if got.Lift == nil || got.LiftCI == nil {
+				t.Fatal("lift missing")
+			}
+			if math.Abs(*got.Lift-tt.wantLift) > tolerance {
+				t.Errorf("Lift = %.7f, want %.7f", *got.Lift, tt.wantLift)
+			}
+			if got.ProbBeatControl < tt.beatMin || got.ProbBeatControl > tt.beatMax {
+				t.Errorf("ProbBeatControl = %.4f, want between %.2f and %.2f", got.ProbBeatControl, tt.beatMin, tt.beatMax)
+			}
+		})
+	}
+}
+
+func TestCompareCapsEvents(t *testing.T) {
+	// More conversions than users, e.g. a short dedup window, count as a rate of 1
+	result := Compare(MetricConversionRate, NewSample("control", 100, 150), []Sample{NewSample("variant", 100, 50)}, 0.95)
+	got := result.Variants[0]
+	if math.Abs(got.Difference+0.5) > 1e-9 {
+		t.Errorf("Difference = %f, want -0.5", got.Difference)
+	}
+	if p := got.ProbBeatControl; p < 0 || p > 0.01 {
+		t.Errorf("ProbBeatControl = %f, want about 0", p)
+	}
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"math"
+	"math/rand"
+)
+
+// posteriorDraws is the number of Monte Carlo draws per comparison. The
+// generator is seeded with a constant, the same data always gives the same result.
+const (
+	posteriorDraws = 20000
+	posteriorSeed  = 1
+)
+
+// probBeatControl estimates the posterior probability that the variant's true
+// value is better than the control's, under uniform (proportions) or
+// exponential (rates) priors
+func probBeatControl(metric Metric, control, variant Sample) float64 {
+	rng := rand.New(rand.NewSource(posteriorSeed))
+
+	draw := func(s Sample) float64 {
+		events, trials := float64(s.Events), float64(s.Trials)
+		if metric.isProportion() {
+			return sampleBeta(rng, 1+events, 1+trials-events)
+		}
+		return sampleGamma(rng, 1+events) / trials
+	}
+
+	var wins int
+	for i := 0; i < posteriorDraws; i++ {
+		c, v := draw(control), draw(variant)
+		if (metric.LowerIsBetter() && v < c) || (!metric.LowerIsBetter() && v > c) {
+			wins++
+		}
+	}
+	return float64(wins) / posteriorDraws
+}
+
+func sampleBeta(rng *rand.Rand, alpha, beta float64) float64 {
+	x := sampleGamma(rng, alpha)
+	y := sampleGamma(rng, beta)
+	return x / (x + y)
+}
+
+// sampleGamma draws from Gamma(shape, 1) using the Marsaglia and Tsang method
+func sampleGamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		// Boost the shape and scale the draw back down
+		return sampleGamma(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if u < 1-0.0331*x*x*x*x || math.Log(u) < 0.5*x*x+d*(1-v+math.Log(v)) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Package certs holds the TLS certificates of the proxy listeners, loaded from
+// files and from the certificates uploaded through the API, and selects them
+// by SNI
+package certs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ab-testing-service/internal/config"
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+const defaultReloadInterval = time.Minute
+
+const (
+	SourceFile = "file"
+	SourceAPI  = "api"
+)
+
+var ErrNoEncryptionKey = errors.New("no TLS encryption key configured")
+
+// Loader returns the certificates uploaded through the API
+type Loader func(ctx context.Context) ([]models.Certificate, error)
+
+// Info describes a certificate in use for a host
+type Info struct {
+	Host     string    `json:"host"`
+	Source   string    `json:"source"` // SourceFile or SourceAPI
+	Names    []string  `json:"names"`  // DNS names of the certificate
+	NotAfter time.Time `json:"not_after"`
+}
+
+type entry struct {
+	cert *tls.Certificate
+	info Info
+}
+
+// Store selects the certificate of a TLS handshake by the most specific host
+// matching its server name, see proxy.HostPatterns. Certificates uploaded
+// through the API win over files for the same host. Reloads swap the
+// certificates without interrupting the listeners.
+type Store struct {
+	files    []config.CertificateFile
+	interval time.Duration
+	load     Loader
+	aead     cipher.AEAD // nil without encryption key
+
+	mutex   sync.Mutex          // serializes reloads
+	sources map[string][]*entry // last certificates loaded by file or by API host
+	hosts   atomic.Pointer[map[string]*entry]
+}
+
+// New returns a store of the certificate files and of the certificates
+// returned by load, which are loaded by Reload. The encryption key may be
+// empty, the certificates uploaded through the API are then not loaded.
+func New(files []config.CertificateFile, encryptionKey string, reloadInterval time.Duration, load Loader) *Store {
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+	s := &Store{
+		files:    files,
+		interval: reloadInterval,
+		load:     load,
+		sources:  make(map[string][]*entry),
+	}
+	if encryptionKey != "" {
+		// AES-256-GCM with a key derived from the configured secret
+		key := sha256.Sum256([]byte(encryptionKey))
+		block, _ := aes.NewCipher(key[:])
+		s.aead, _ = cipher.NewGCM(block)
+	}
+	hosts := make(map[string]*entry)
+	s.hosts.Store(&hosts)
+	return s
+}
+
+// GetCertificate returns the certificate of a TLS handshake, for tls.Config
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	hosts := *s.hosts.Load()
+	patterns := []string{proxy.CatchAllHost}
+	if hello.ServerName != "" {
+		patterns = proxy.HostPatterns(hello.ServerName)
+	}
+	for _, pattern := range patterns {
+		if e, ok := hosts[pattern]; ok {
+			return e.cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no certificate for %q", hello.ServerName)
+}
+
+// List returns the certificates in use by host
+func (s *Store) List() []Info {
+	hosts := *s.hosts.Load()
+	items := make([]Info, 0, len(hosts))
+	for _, e := range hosts {
+		items = append(items, e.info)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Host < items[j].Host
+	})
+	return items
+}
+
+// Watch reloads the certificates until the context is canceled
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Printf("Failed to reload TLS certificates: %v", err)
+			}
+		}
+	}
+}
+
+// Reload loads the certificate files and the certificates uploaded through the
+// API. A certificate that fails to load keeps its previous version in use.
+func (s *Store) Reload(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, file := range s.files {
+		key := SourceFile + ":" + file.CertFile
+		cert, err := loadFile(file)
+		if err != nil {
+			log.Printf("Failed to load TLS certificate %s: %v", file.CertFile, err)
+			continue
+		}
+		s.sources[key] = cert
+	}
+
+	var loadErr error
+	if s.aead != nil && s.load != nil {
+		loadErr = s.reloadUploaded(ctx)
+	}
+
+	// Files first, so that uploaded certificates replace them
+	keys := make([]string, 0, len(s.sources))
+	for key := range s.sources {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		fi, fj := strings.HasPrefix(keys[i], SourceFile+":"), strings.HasPrefix(keys[j], SourceFile+":")
+		if fi != fj {
+			return fi
+		}
+		return keys[i] < keys[j]
+	})
+
+	hosts := make(map[string]*entry)
+	for _, key := range keys {
+		for _, e := range s.sources[key] {
+			hosts[e.info.Host] = e
+		}
+	}
+	s.hosts.Store(&hosts)
+	return loadErr
+}
+
+// reloadUploaded replaces the certificates uploaded through the API, must be
+// called with s.mutex held
+func (s *Store) reloadUploaded(ctx context.Context) error {
+	uploaded, err := s.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load uploaded certificates: %w", err)
+	}
+
+	current := make(map[string]bool, len(uploaded))
+	for _, c := range uploaded {
+		key := SourceAPI + ":" + c.Host
+		current[key] = true
+
+		keyPEM, err := s.Decrypt(c.EncryptedKey)
+		if err != nil {
+			log.Printf("Failed to decrypt TLS certificate key of %s: %v", c.Host, err)
+			continue
+		}
+		cert, err := Parse([]byte(c.CertPEM), keyPEM)
+		if err != nil {
+			log.Printf("Failed to load TLS certificate of %s: %v", c.Host, err)
+			continue
+		}
+		s.sources[key] = []*entry{newEntry(c.Host, SourceAPI, cert)}
+	}
+
+	// Deleted certificates
+	for key := range s.sources {
+		if strings.HasPrefix(key, SourceAPI+":") && !current[key] {
+			delete(s.sources, key)
+		}
+	}
+	return nil
+}
+
+func loadFile(file config.CertificateFile) ([]*entry, error) {
+	cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := file.Hosts
+	if len(hosts) == 0 {
+		hosts = cert.Leaf.DNSNames
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("certificate has no DNS names, set its hosts")
+	}
+	entries := make([]*entry, 0, len(hosts))
+	for _, host := range hosts {
+		entries = append(entries, newEntry(strings.ToLower(host), SourceFile, &cert))
+	}
+	return entries, nil
+}
+
+func newEntry(host, source string, cert *tls.Certificate) *entry {
+	return &entry{
+		cert: cert,
+		info: Info{
+			Host:     host,
+			Source:   source,
+			Names:    cert.Leaf.DNSNames,
+			NotAfter: cert.Leaf.NotAfter,
+		},
+	}
+}
+
+// Parse parses a PEM certificate chain and its private key
+func Parse(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// Covers reports whether a certificate is valid for a listen host, every
+// certificate covers the catch-all host
+func Covers(cert *tls.Certificate, host string) bool {
+	switch {
+	case host == proxy.CatchAllHost:
+		return true
+	case strings.HasPrefix(host, "*."):
+		// A name of the wildcard, valid for wildcard certificates only
+		host = "wildcard-check" + strings.TrimPrefix(host, "*")
+	}
+	return cert.Leaf.VerifyHostname(host) == nil
+}
+
+// Encrypt encrypts a private key to store it
+func (s *Store) Encrypt(plaintext []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts a private key encrypted by Encrypt
+func (s *Store) Decrypt(ciphertext []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	size := s.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, errors.New("ciphertext too short")
+	}
+	return s.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
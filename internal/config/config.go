@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/ab-testing-service/internal/models"
 )
 
 type Config struct {
@@ -39,6 +42,84 @@ type Config struct {
 	JWT struct {
 		Secret string `yaml:"secret"`
 	} `yaml:"jwt"`
+
+	Proxy struct {
+		// CookieSecret signs sticky variant cookies, JWT secret is used when empty
+		CookieSecret string `yaml:"cookie_secret"`
+		// ReconcileInterval is the number of seconds between comparisons of the running proxy configs with the
+		// database, 30 by default
+		ReconcileInterval int `yaml:"reconcile_interval"`
+	} `yaml:"proxy"`
+
+	Stats struct {
+		// SpoolDir keeps the statistics on disk until Kafka accepts them, "spool/stats" by default
+		SpoolDir string `yaml:"spool_dir"`
+		// SpoolMaxBytes caps the size of the spool, new statistics are dropped when it is full, 100 MB by default
+		SpoolMaxBytes int64 `yaml:"spool_max_bytes"`
+	} `yaml:"stats"`
+
+	Instances struct {
+		// HeartbeatInterval is the number of seconds between the registrations of an instance, 10 by default
+		HeartbeatInterval int `yaml:"heartbeat_interval"`
+		// TTL is the number of seconds an instance stays registered without a heartbeat, 3 intervals by default
+		TTL int `yaml:"ttl"`
+	} `yaml:"instances"`
+
+	Notifications struct {
+		// WebhookURL receives a JSON POST for every automatic rollback, notifications are only logged when empty
+		WebhookURL string `yaml:"webhook_url"`
+	} `yaml:"notifications"`
+
+	GeoIP struct {
+		// Database is the path of a MaxMind-format (mmdb) file, geo conditions match nothing when empty
+		Database string `yaml:"database"`
+		// ReloadInterval is the number of seconds between checks for a new file, 60 by default
+		ReloadInterval int `yaml:"reload_interval"`
+	} `yaml:"geoip"`
+
+	// Exclusion rules apply to every proxy, on top of the proxy rules
+	Exclusion models.ExclusionRules `yaml:"exclusion"`
+
+	// Listeners serve the proxies, a single HTTP listener on :80 when empty
+	Listeners []Listener `yaml:"listeners"`
+
+	TLS struct {
+		// Certificates are loaded from files and reloaded when the files change
+		Certificates []CertificateFile `yaml:"certificates"`
+		// EncryptionKey encrypts the private keys of the certificates uploaded through the API, uploads are
+		// rejected when empty
+		EncryptionKey string `yaml:"encryption_key"`
+		// ReloadInterval is the number of seconds between reloads of the certificates, 60 by default
+		ReloadInterval int `yaml:"reload_interval"`
+	} `yaml:"tls"`
+}
+
+// Listener is an address the proxies are served on
+type Listener struct {
+	Address string `yaml:"address"` // e.g. ":443"
+	// TLS serves https with the certificate of the requested host, see the TLS section
+	TLS bool `yaml:"tls"`
+	// RedirectHTTPS answers every request with a redirect to https instead of serving the proxies
+	RedirectHTTPS bool `yaml:"redirect_https"`
+	// RedirectPort is the port of the https URLs redirected to, 443 by default
+	RedirectPort int `yaml:"redirect_port"`
+
+	// Timeouts in seconds, none when zero
+	ReadTimeout       int `yaml:"read_timeout"`
+	ReadHeaderTimeout int `yaml:"read_header_timeout"`
+	WriteTimeout      int `yaml:"write_timeout"`
+	IdleTimeout       int `yaml:"idle_timeout"`
+	// MaxHeaderBytes limits the size of request headers, 1 MB when zero
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+}
+
+// CertificateFile is a certificate and its private key in PEM files
+type CertificateFile struct {
+	// Hosts served with the certificate, e.g. "*.example.com" or "*" for requests without a known host. The
+	// names of the certificate when empty.
+	Hosts    []string `yaml:"hosts"`
+	CertFile string   `yaml:"cert_file"`
+	KeyFile  string   `yaml:"key_file"`
 }
 
 func Load(path string) (*Config, error) {
@@ -51,6 +132,20 @@ func Load(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.Exclusion.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid exclusion rules: %w", err)
+	}
+	if cfg.Instances.TTL > 0 && cfg.Instances.TTL <= cfg.Instances.HeartbeatInterval {
+		return nil, fmt.Errorf("instances: ttl must be longer than heartbeat_interval")
+	}
+	for i, l := range cfg.Listeners {
+		if l.Address == "" {
+			return nil, fmt.Errorf("listeners[%d]: address is required", i)
+		}
+		if l.TLS && l.RedirectHTTPS {
+			return nil, fmt.Errorf("listeners[%d]: a TLS listener can't redirect to https", i)
+		}
+	}
 
 	return &cfg, nil
 }
@@ -0,0 +1,140 @@
+// Package geoip resolves client IPs to locations with a local MaxMind-format
+// (mmdb) database, e.g. GeoLite2-City or GeoIP2-City
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const defaultReloadInterval = time.Minute
+
+// Location is where an IP address is, fields are empty when unknown
+type Location struct {
+	Country string `json:"country"` // ISO 3166-1 alpha-2 code, e.g. "US"
+	Region  string `json:"region"`  // ISO 3166-2 code of the first subdivision, e.g. "US-CA"
+	City    string `json:"city"`    // English name, e.g. "Los Angeles"
+}
+
+// record is the part of a City or Country database record read by Lookup
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// DB is a database reloaded when its file changes. The file is read in memory,
+// so that it can be replaced while lookups are running.
+type DB struct {
+	path     string
+	interval time.Duration
+	reader   atomic.Pointer[maxminddb.Reader]
+	modTime  time.Time
+	size     int64
+}
+
+// Open loads the database file. The returned DB is usable when the error is not
+// nil: lookups find nothing until a valid file is loaded by Watch.
+func Open(path string, reloadInterval time.Duration) (*DB, error) {
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+	db := &DB{path: path, interval: reloadInterval}
+	_, err := db.reload()
+	return db, err
+}
+
+// Lookup returns the location of an IP address. A nil DB finds nothing.
+func (db *DB) Lookup(ip net.IP) Location {
+	if db == nil || ip == nil {
+		return Location{}
+	}
+	reader := db.reader.Load()
+	if reader == nil {
+		return Location{}
+	}
+
+	var rec record
+	if err := reader.Lookup(ip, &rec); err != nil {
+		return Location{}
+	}
+
+	location := Location{
+		Country: strings.ToUpper(rec.Country.ISOCode),
+		City:    rec.City.Names["en"],
+	}
+	if len(rec.Subdivisions) > 0 && rec.Subdivisions[0].ISOCode != "" && location.Country != "" {
+		location.Region = location.Country + "-" + strings.ToUpper(rec.Subdivisions[0].ISOCode)
+	}
+	return location
+}
+
+// LookupString is Lookup for a textual IP address
+func (db *DB) LookupString(ip string) Location {
+	return db.Lookup(net.ParseIP(ip))
+}
+
+// Watch reloads the database when its file changes until the context is
+// canceled. A file that fails to load leaves the previous database in use.
+func (db *DB) Watch(ctx context.Context) {
+	if db == nil {
+		return
+	}
+
+	ticker := time.NewTicker(db.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := db.reload()
+			if err != nil {
+				log.Printf("Failed to reload GeoIP database %s: %v", db.path, err)
+			} else if reloaded {
+				log.Printf("Reloaded GeoIP database %s", db.path)
+			}
+		}
+	}
+}
+
+// reload loads the file when its modification time or size changed since the
+// last load
+func (db *DB) reload() (bool, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, err
+	}
+
+	// Remember the file even when it is invalid, not to parse it on every tick
+	db.modTime, db.size = info.ModTime(), info.Size()
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return false, fmt.Errorf("invalid database: %w", err)
+	}
+	db.reader.Store(reader)
+	return true, nil
+}
@@ -0,0 +1,221 @@
+// Package hll counts distinct values approximately with HyperLogLog sketches
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Precision is the number of hash bits selecting a register: 2^14 registers
+// estimate with a standard error of about 0.8%
+const Precision = 14
+
+const (
+	registerCount = 1 << Precision
+	maxRank       = 64 - Precision + 1 // rank of a hash with only the guard bit set
+
+	formatDense  = 1 // every register
+	formatSparse = 2 // index and value of the registers set
+)
+
+// Sketch estimates the number of distinct values added to it in at most 16 KB,
+// whatever the number of values. Merging sketches gives the sketch of all
+// their values, so that values are counted once across sketches.
+type Sketch struct {
+	registers []uint8 // nil until a value is added
+}
+
+func New() *Sketch {
+	return &Sketch{}
+}
+
+// Add adds a value to the sketch
+func (s *Sketch) Add(value string) {
+	h := hash(value)
+	index := h >> (64 - Precision)
+	// Rank of the first set bit of the remaining bits, the guard bit bounds it
+	rank := uint8(bits.LeadingZeros64(h<<Precision|1<<(Precision-1))) + 1
+
+	if s.registers == nil {
+		s.registers = make([]uint8, registerCount)
+	}
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// hash returns a 64-bit hash of value with well mixed bits: FNV-1a followed by
+// the finalizer of MurmurHash3
+func hash(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Count returns the estimated number of distinct values, with the improved
+// estimator of Otmar Ertl ("New cardinality estimation algorithms for
+// HyperLogLog sketches", 2017), unbiased for small and large counts alike
+func (s *Sketch) Count() int64 {
+	if s == nil || s.registers == nil {
+		return 0
+	}
+
+	// Number of registers by value
+	const q = maxRank - 1
+	var counts [maxRank + 1]int
+	for _, r := range s.registers {
+		counts[r]++
+	}
+
+	m := float64(registerCount)
+	z := m * tau(1-float64(counts[q+1])/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + float64(counts[k]))
+	}
+	z += m * sigma(float64(counts[0])/m)
+	return int64(m*m/(2*math.Ln2*z) + 0.5)
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		next := z + x*y
+		if next == z {
+			return z
+		}
+		y += y
+		z = next
+	}
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		y *= 0.5
+		next := z - (1-x)*(1-x)*y
+		if next == z {
+			return z / 3
+		}
+		z = next
+	}
+}
+
+// Merge adds the values of another sketch
+func (s *Sketch) Merge(other *Sketch) {
+	if other == nil || other.registers == nil {
+		return
+	}
+	if s.registers == nil {
+		s.registers = make([]uint8, registerCount)
+	}
+	for i, r := range other.registers {
+		if r > s.registers[i] {
+			s.registers[i] = r
+		}
+	}
+}
+
+// Clone returns a copy of the sketch
+func (s *Sketch) Clone() *Sketch {
+	clone := &Sketch{}
+	if s.registers != nil {
+		clone.registers = make([]uint8, registerCount)
+		copy(clone.registers, s.registers)
+	}
+	return clone
+}
+
+// MarshalBinary encodes the sketch, nil when no value was added. Sketches of
+// few values only encode the registers they set.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	if s.registers == nil {
+		return nil, nil
+	}
+
+	set := 0
+	for _, r := range s.registers {
+		if r != 0 {
+			set++
+		}
+	}
+
+	if 3*set >= registerCount {
+		data := make([]byte, 0, 2+registerCount)
+		data = append(data, formatDense, Precision)
+		return append(data, s.registers...), nil
+	}
+
+	data := make([]byte, 0, 2+3*set)
+	data = append(data, formatSparse, Precision)
+	for i, r := range s.registers {
+		if r != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, r)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary, empty data is an
+// empty sketch
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	s.registers = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data) < 2 {
+		return errors.New("invalid sketch: too short")
+	}
+	if data[1] != Precision {
+		return fmt.Errorf("invalid sketch: precision %d instead of %d", data[1], Precision)
+	}
+
+	registers := make([]uint8, registerCount)
+	body := data[2:]
+	switch data[0] {
+	case formatDense:
+		if len(body) != registerCount {
+			return fmt.Errorf("invalid sketch: %d registers instead of %d", len(body), registerCount)
+		}
+		copy(registers, body)
+		for _, r := range registers {
+			if r > maxRank {
+				return fmt.Errorf("invalid sketch: register value %d out of range", r)
+			}
+		}
+	case formatSparse:
+		if len(body)%3 != 0 {
+			return errors.New("invalid sketch: truncated register")
+		}
+		for i := 0; i < len(body); i += 3 {
+			index := binary.BigEndian.Uint16(body[i:])
+			if int(index) >= registerCount || body[i+2] > maxRank {
+				return fmt.Errorf("invalid sketch: register %d out of range", index)
+			}
+			registers[index] = body[i+2]
+		}
+	default:
+		return fmt.Errorf("invalid sketch: unknown format %d", data[0])
+	}
+
+	s.registers = registers
+	return nil
+}
@@ -0,0 +1,167 @@
+package hll
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// standardError is the relative standard error of the estimates, 1.04 / sqrt(m)
+var standardError = 1.04 / math.Sqrt(registerCount)
+
+func sketchOf(from, to int) *Sketch {
+	s := New()
+	for i := from; i < to; i++ {
+		s.Add("user-" + strconv.Itoa(i))
+	}
+	return s
+}
+
+// checkCount fails when the estimate is further than 3 standard errors from
+// the count, or than 1 for small counts
+func checkCount(t *testing.T, s *Sketch, count int) {
+	t.Helper()
+	tolerance := math.Max(1, 3*standardError*float64(count))
+	if got := s.Count(); math.Abs(float64(got-int64(count))) > tolerance {
+		t.Errorf("Count() = %d, want %d ± %.0f", got, count, tolerance)
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, count := range []int{0, 1, 10, 100, 1000, 10000, 100000, 1000000} {
+		t.Run(strconv.Itoa(count), func(t *testing.T) {
+			checkCount(t, sketchOf(0, count), count)
+		})
+	}
+}
+
+func TestCountDuplicates(t *testing.T) {
+	s := New()
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 1000; i++ {
+			s.Add("user-" + strconv.Itoa(i))
+		}
+	}
+	checkCount(t, s, 1000)
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           [2]int // ranges of the values added
+		mergedFrom, to int
+	}{
+		{name: "disjoint", a: [2]int{0, 5000}, b: [2]int{5000, 10000}, mergedFrom: 0, to: 10000},
+		{name: "overlapping", a: [2]int{0, 60000}, b: [2]int{40000, 100000}, mergedFrom: 0, to: 100000},
+		{name: "included", a: [2]int{0, 1000}, b: [2]int{200, 300}, mergedFrom: 0, to: 1000},
+		{name: "empty", a: [2]int{0, 1000}, b: [2]int{0, 0}, mergedFrom: 0, to: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := sketchOf(tt.a[0], tt.a[1])
+			merged.Merge(sketchOf(tt.b[0], tt.b[1]))
+
+			// Merging gives the sketch of all the values
+			want, _ := sketchOf(tt.mergedFrom, tt.to).MarshalBinary()
+			got, _ := merged.MarshalBinary()
+			if !bytes.Equal(got, want) {
+				t.Error("merged sketch differs from the sketch of the union")
+			}
+			checkCount(t, merged, tt.to-tt.mergedFrom)
+		})
+	}
+}
+
+func TestMergeIntoEmpty(t *testing.T) {
+	s := New()
+	s.Merge(nil)
+	s.Merge(New())
+	if data, _ := s.MarshalBinary(); data != nil {
+		t.Errorf("MarshalBinary() of an empty merge = %v, want nil", data)
+	}
+
+	s.Merge(sketchOf(0, 100))
+	checkCount(t, s, 100)
+}
+
+func TestClone(t *testing.T) {
+	s := sketchOf(0, 100)
+	clone := s.Clone()
+	s.Add("another user")
+	s.Merge(sketchOf(100, 1000))
+	checkCount(t, clone, 100)
+}
+
+func TestMarshalBinary(t *testing.T) {
+	tests := []struct {
+		count  int
+		format byte
+	}{
+		{count: 0},
+		{count: 1, format: formatSparse},
+		{count: 1000, format: formatSparse},
+		{count: 10000, format: formatDense},
+		{count: 100000, format: formatDense},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.count), func(t *testing.T) {
+			s := sketchOf(0, tt.count)
+			data, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.count == 0 {
+				if data != nil {
+					t.Fatalf("MarshalBinary() of an empty sketch = %v, want nil", data)
+				}
+			} else if data[0] != tt.format {
+				t.Errorf("format = %d, want %d", data[0], tt.format)
+			}
+			if len(data) > 2+registerCount {
+				t.Errorf("encoded in %d bytes, want at most %d", len(data), 2+registerCount)
+			}
+
+			decoded := New()
+			if err := decoded.UnmarshalBinary(data); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded.registers, s.registers) {
+				t.Error("decoded registers differ")
+			}
+			if decoded.Count() != s.Count() {
+				t.Errorf("decoded Count() = %d, want %d", decoded.Count(), s.Count())
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	dense := make([]byte, 2+registerCount)
+	dense[0], dense[1] = formatDense, Precision
+	dense[2] = maxRank + 1
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "too short", data: []byte{formatSparse}},
+		{name: "other precision", data: []byte{formatSparse, Precision + 1}},
+		{name: "unknown format", data: []byte{3, Precision}},
+		{name: "truncated sparse register", data: []byte{formatSparse, Precision, 0, 1}},
+		{name: "sparse index out of range", data: []byte{formatSparse, Precision, 0xff, 0xff, 1}},
+		{name: "sparse rank out of range", data: []byte{formatSparse, Precision, 0, 1, maxRank + 1}},
+		{name: "dense too short", data: []byte{formatDense, Precision, 1, 2, 3}},
+		{name: "dense rank out of range", data: dense},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := New().UnmarshalBinary(tt.data); err == nil {
+				t.Error("UnmarshalBinary() succeeded, want an error")
+			}
+		})
+	}
+}
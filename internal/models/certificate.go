@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Certificate is a TLS certificate uploaded through the API for a listen host,
+// its private key is stored encrypted
+type Certificate struct {
+	Host         string    `json:"host"` // e.g. "promo.example.com", "*.example.com" or "*"
+	CertPEM      string    `json:"cert_pem"`
+	EncryptedKey []byte    `json:"-"`
+	NotAfter     time.Time `json:"not_after"`
+	CreatedBy    *string   `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
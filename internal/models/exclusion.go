@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ExclusionRules describe traffic kept out of experiments: bots, monitors and
+// internal visitors. They are set globally in the service config and per proxy.
+type ExclusionRules struct {
+	// UserAgents extends the built-in bot list, entries match case-insensitive substrings
+	UserAgents []string `json:"user_agents,omitempty" yaml:"user_agents"`
+	// DisableBuiltinBots stops matching the user agent list shipped with the service
+	DisableBuiltinBots bool `json:"disable_builtin_bots,omitempty" yaml:"disable_builtin_bots"`
+	// AllowCIDRs are never excluded, whatever the other rules say
+	AllowCIDRs []string `json:"allow_cidrs,omitempty" yaml:"allow_cidrs"`
+	// DenyCIDRs are excluded, e.g. office networks. Single IPs are accepted.
+	DenyCIDRs []string     `json:"deny_cidrs,omitempty" yaml:"deny_cidrs"`
+	Headers   []HeaderRule `json:"headers,omitempty" yaml:"headers"`
+}
+
+// HeaderRule excludes requests carrying a header, with the given value when
+// Value is set (case-insensitive)
+type HeaderRule struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value,omitempty" yaml:"value"`
+}
+
+// ExclusionSettings are the exclusion rules of a proxy
+type ExclusionSettings struct {
+	ExclusionRules
+	// IgnoreGlobal skips the rules of the service config for this proxy
+	IgnoreGlobal bool `json:"ignore_global,omitempty"`
+	// TargetID receives the excluded traffic, the control target when empty
+	TargetID string `json:"target_id,omitempty"`
+}
+
+// Validate checks the CIDRs and header rules
+func (r *ExclusionRules) Validate() error {
+	for _, cidrs := range [][]string{r.AllowCIDRs, r.DenyCIDRs} {
+		for _, cidr := range cidrs {
+			if _, err := ParseCIDR(cidr); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ua := range r.UserAgents {
+		if strings.TrimSpace(ua) == "" {
+			return fmt.Errorf("empty user agent pattern")
+		}
+	}
+	for _, h := range r.Headers {
+		if strings.TrimSpace(h.Name) == "" {
+			return fmt.Errorf("header rule name is required")
+		}
+	}
+	return nil
+}
+
+// ParseCIDR parses a CIDR or a single IP address
+func ParseCIDR(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", value)
+	}
+	return network, nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import "time"
+
+type GuardrailMetric string
+
+const (
+	// GuardrailMetricErrorRate trips when the error rate of a target exceeds
+	// Threshold percent over the window
+	GuardrailMetricErrorRate GuardrailMetric = "error_rate"
+	// GuardrailMetricTraffic trips when a target gets fewer than Threshold
+	// requests over the window
+	GuardrailMetricTraffic GuardrailMetric = "traffic"
+)
+
+func (m GuardrailMetric) IsValid() bool {
+	switch m {
+	case GuardrailMetricErrorRate, GuardrailMetricTraffic:
+		return true
+	}
+	return false
+}
+
+type GuardrailAction string
+
+const (
+	// GuardrailActionDeactivate deactivates the target that tripped the guardrail
+	GuardrailActionDeactivate GuardrailAction = "deactivate"
+	// GuardrailActionShiftToControl sends all traffic to the control target and
+	// deactivates the other targets
+	GuardrailActionShiftToControl GuardrailAction = "shift_to_control"
+)
+
+func (a GuardrailAction) IsValid() bool {
+	switch a {
+	case GuardrailActionDeactivate, GuardrailActionShiftToControl:
+		return true
+	}
+	return false
+}
+
+// Guardrail watches the targets of a proxy, except the control target, and
+// rolls back a target that trips it. Only the data collected since ArmedAt is
+// considered, so that a guardrail does not trip again on the data that tripped
+// it before a revert.
+type Guardrail struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Metric      GuardrailMetric `json:"metric"`
+	Threshold   float64         `json:"threshold"`
+	Window      int             `json:"window"`       // minutes
+	MinRequests int64           `json:"min_requests"` // requests needed to evaluate the error rate
+	Action      GuardrailAction `json:"action"`
+	ArmedAt     time.Time       `json:"armed_at"`
+}
+
+// Rollback returns the targets after the action of the guardrail tripped by a target
+func (a GuardrailAction) Rollback(targets []Target, targetID, controlID string) []Target {
+	updated := make([]Target, len(targets))
+	for i, t := range targets {
+		switch a {
+		case GuardrailActionDeactivate:
+			if t.ID == targetID {
+				t.IsActive = false
+			}
+		case GuardrailActionShiftToControl:
+			if t.ID == controlID {
+				t.Weight, t.IsActive = 1, true
+			} else {
+				t.Weight, t.IsActive = 0, false
+			}
+		}
+		updated[i] = t
+	}
+	return updated
+}
+
+// GuardrailTrip records a guardrail tripped by a target and the rollback it caused
+type GuardrailTrip struct {
+	ID              string          `json:"id"`
+	GuardrailID     string          `json:"guardrail_id"`
+	TargetID        string          `json:"target_id"`
+	Action          GuardrailAction `json:"action"`
+	Reason          string          `json:"reason"`
+	Value           float64         `json:"value"` // observed error rate percent or request count
+	TrippedAt       time.Time       `json:"tripped_at"`
+	PreviousTargets []Target        `json:"previous_targets"` // restored by a revert
+	RevertedAt      *time.Time      `json:"reverted_at,omitempty"`
+	RevertedBy      *string         `json:"reverted_by,omitempty"`
+}
+
+// ControlTarget returns the ID of the designated control target, or of the first target
+func (s *ProxySettings) ControlTarget(targets []Target) string {
+	if s.ControlTargetID != "" {
+		return s.ControlTargetID
+	}
+	if len(targets) > 0 {
+		return targets[0].ID
+	}
+	return ""
+}
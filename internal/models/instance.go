@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Instance is a running service instance, registered in Redis while it sends
+// heartbeats
+type Instance struct {
+	ID        string    `json:"id"` // unique per process, the sender ID of its pub/sub messages
+	Hostname  string    `json:"hostname"`
+	Version   string    `json:"version"` // build version
+	StartedAt time.Time `json:"started_at"`
+	Heartbeat time.Time `json:"heartbeat"`
+	ExpiresAt time.Time `json:"expires_at"` // the instance is removed unless it sends a heartbeat before
+	// ConfigVersions are the config versions of the proxies the instance runs, by proxy ID
+	ConfigVersions map[string]int64 `json:"config_versions"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProxyState is the lifecycle state of a proxy, stored in proxies.state
+type ProxyState string
+
+const (
+	// ProxyStateDraft proxies are configured but serve no traffic
+	ProxyStateDraft ProxyState = "draft"
+	// ProxyStateRunning proxies split traffic between their targets and count stats
+	ProxyStateRunning ProxyState = "running"
+	// ProxyStatePaused proxies send everyone to the fallback target and count no stats
+	ProxyStatePaused ProxyState = "paused"
+	// ProxyStateCompleted proxies send everyone to the winner and count no stats
+	ProxyStateCompleted ProxyState = "completed"
+	// ProxyStateArchived proxies serve no traffic, their history and results are read-only
+	ProxyStateArchived ProxyState = "archived"
+)
+
+func (ps ProxyState) IsValid() bool {
+	switch ps {
+	case ProxyStateDraft, ProxyStateRunning, ProxyStatePaused, ProxyStateCompleted, ProxyStateArchived:
+		return true
+	}
+	return false
+}
+
+type LifecycleAction string
+
+const (
+	LifecycleActionStart    LifecycleAction = "start"
+	LifecycleActionPause    LifecycleAction = "pause"
+	LifecycleActionResume   LifecycleAction = "resume"
+	LifecycleActionComplete LifecycleAction = "complete"
+	LifecycleActionArchive  LifecycleAction = "archive"
+)
+
+// lifecycleTransitions lists the states every action may be taken from and
+// the state it leads to
+var lifecycleTransitions = map[LifecycleAction]struct {
+	from []ProxyState
+	to   ProxyState
+}{
+	LifecycleActionStart:    {from: []ProxyState{ProxyStateDraft}, to: ProxyStateRunning},
+	LifecycleActionPause:    {from: []ProxyState{ProxyStateRunning}, to: ProxyStatePaused},
+	LifecycleActionResume:   {from: []ProxyState{ProxyStatePaused}, to: ProxyStateRunning},
+	LifecycleActionComplete: {from: []ProxyState{ProxyStateRunning, ProxyStatePaused}, to: ProxyStateCompleted},
+	LifecycleActionArchive:  {from: []ProxyState{ProxyStateDraft, ProxyStatePaused, ProxyStateCompleted}, to: ProxyStateArchived},
+}
+
+func (a LifecycleAction) IsValid() bool {
+	_, ok := lifecycleTransitions[a]
+	return ok
+}
+
+// Transition returns the state the action leads to from the given state, or
+// an error when the action can't be taken from it
+func (a LifecycleAction) Transition(from ProxyState) (ProxyState, error) {
+	transition, ok := lifecycleTransitions[a]
+	if !ok {
+		return "", fmt.Errorf("unknown lifecycle action %q", a)
+	}
+	for _, state := range transition.from {
+		if state == from {
+			return transition.to, nil
+		}
+	}
+	return "", fmt.Errorf("can't %s a %s proxy", a, from)
+}
+
+// Lifecycle holds the targets of the paused and completed states, it is
+// stored in the proxy settings and maintained by the lifecycle transitions
+type Lifecycle struct {
+	FallbackTargetID string    `json:"fallback_target_id,omitempty"` // target of paused proxies
+	WinnerTargetID   string    `json:"winner_target_id,omitempty"`   // target of completed proxies
+	StartedAt        time.Time `json:"started_at,omitempty"`
+	CompletedAt      time.Time `json:"completed_at,omitempty"`
+}
+
+// FixedTarget returns the ID of the target all traffic goes to in the given
+// state, empty when traffic is split between the targets
+func (l *Lifecycle) FixedTarget(state ProxyState) string {
+	if l == nil {
+		return ""
+	}
+	switch state {
+	case ProxyStatePaused:
+		return l.FallbackTargetID
+	case ProxyStateCompleted:
+		return l.WinnerTargetID
+	}
+	return ""
+}
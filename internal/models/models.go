@@ -13,24 +13,29 @@ const (
 	ConditionTypeUserAgent ConditionType = "user_agent"
 	ConditionTypeLanguage  ConditionType = "language"
 	ConditionTypeExpr      ConditionType = "expr"
+	ConditionTypeGeo       ConditionType = "geo"
 )
 
 func (ct ConditionType) IsValid() bool {
 	switch ct {
 	case ConditionTypeHeader, ConditionTypeQuery, ConditionTypeCookie,
-		ConditionTypeUserAgent, ConditionTypeLanguage, ConditionTypeExpr:
+		ConditionTypeUserAgent, ConditionTypeLanguage, ConditionTypeExpr, ConditionTypeGeo:
 		return true
 	}
 	return false
 }
 
-// RouteCondition represents a condition for routing traffic
+// RouteCondition represents the routing conditions of a proxy: ordered targeting
+// rules. The single condition fields are read from conditions saved before
+// rules and converted by TargetingRules.
 type RouteCondition struct {
-	Type      ConditionType     `json:"type" db:"type"`           // Type of condition: "header", "query", "cookie", "user_agent", "language", "expr"
-	ParamName string            `json:"param_name" db:"param"`    // Name of the parameter to check (for header, query, cookie) or expression for expr type
-	Values    map[string]string `json:"values" db:"values"`       // List of values to match targets by id or expressions for expr type
-	Default   string            `json:"default" db:"default"`     // Default target ID if no match is found
-	Expr      string            `json:"expr,omitempty" db:"expr"` // Expression for expr type condition
+	Rules []TargetingRule `json:"rules,omitempty" db:"rules"`
+
+	Type      ConditionType     `json:"type,omitempty" db:"type"`        // Type of condition: "header", "query", "cookie", "user_agent", "language", "expr", "geo"
+	ParamName string            `json:"param_name,omitempty" db:"param"` // Name of the parameter to check (for header, query, cookie) or expression for expr type
+	Values    map[string]string `json:"values,omitempty" db:"values"`    // Values to match keyed by target ID, or expressions for expr type
+	Default   string            `json:"default,omitempty" db:"default"`  // Default target ID if no match is found
+	Expr      string            `json:"expr,omitempty" db:"expr"`        // Expression for expr type condition
 }
 
 type Target struct {
@@ -50,5 +55,13 @@ type Visit struct {
 	RID       string    `json:"rid" db:"rid"`
 	RRID      string    `json:"rrid" db:"rrid"`
 	RUID      string    `json:"ruid" db:"ruid"`
+	Rule      string    `json:"rule" db:"rule"` // what selected the target
+	Method    string    `json:"method" db:"method"`
+	Host      string    `json:"host" db:"host"`
+	Path      string    `json:"path" db:"path"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	Referer   string    `json:"referer" db:"referer"`
+	Language  string    `json:"language" db:"language"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
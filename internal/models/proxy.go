@@ -9,8 +9,17 @@ type ProxyMode string
 const (
 	ProxyModeRedirect ProxyMode = "redirect"
 	ProxyModePath     ProxyMode = "path"
+	ProxyModeProxy    ProxyMode = "proxy"
 )
 
+func (pm ProxyMode) IsValid() bool {
+	switch pm {
+	case ProxyModeRedirect, ProxyModePath, ProxyModeProxy:
+		return true
+	}
+	return false
+}
+
 type ListenURL struct {
 	ID        string    `json:"id" db:"id"`
 	ProxyID   string    `json:"proxy_id" db:"proxy_id"`
@@ -28,10 +37,11 @@ type Proxy struct {
 	Targets              []Target        `json:"targets" db:"targets"`
 	Condition            *RouteCondition `json:"condition,omitempty" db:"condition"`
 	Tags                 []string        `json:"tags" db:"tags"`
-	IsActive             bool            `json:"is_active" db:"is_active"`
+	State                ProxyState      `json:"state" db:"state"`
 	SavingCookiesFlg     bool            `json:"saving_cookies_flg" db:"saving_cookies_flg"`
 	QueryForwardingFlg   bool            `json:"query_forwarding_flg" db:"query_forwarding_flg"`
 	CookiesForwardingFlg bool            `json:"cookies_forwarding_flg" db:"cookies_forwarding_flg"`
+	Settings             ProxySettings   `json:"settings" db:"settings"`
 	CreatedAt            time.Time       `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time       `json:"updated_at" db:"updated_at"`
 }
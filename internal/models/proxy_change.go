@@ -13,6 +13,20 @@ const (
 	ChangeTypeURLUpdate             ChangeType = "url_update"
 	ChangeTypeCookiesUpdate         ChangeType = "cookies_update"
 	ChangeTypeQueryForwardingUpdate ChangeType = "query_forwarding_update"
+	ChangeTypeBucketingUpdate       ChangeType = "bucketing_update"
+	ChangeTypeGoalsUpdate           ChangeType = "goals_update"
+	ChangeTypeControlUpdate         ChangeType = "control_update"
+	ChangeTypeHealthCheckUpdate     ChangeType = "health_check_update"
+	ChangeTypeTargetHealth          ChangeType = "target_health"
+	ChangeTypeScheduleUpdate        ChangeType = "schedule_update"
+	ChangeTypeGuardrailsUpdate      ChangeType = "guardrails_update"
+	ChangeTypeGuardrailTrip         ChangeType = "guardrail_trip"
+	ChangeTypeGuardrailRevert       ChangeType = "guardrail_revert"
+	ChangeTypeRedirectUpdate        ChangeType = "redirect_update"
+	ChangeTypeStateUpdate           ChangeType = "state_update"
+	ChangeTypeExclusionUpdate       ChangeType = "exclusion_update"
+	ChangeTypeAllocationUpdate      ChangeType = "allocation_update"
+	ChangeTypeProxyDelete           ChangeType = "proxy_delete"
 )
 
 type ProxyChange struct {
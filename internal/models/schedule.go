@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule changes the target weights of a proxy at given times: the start
+// weights at StartAt, every ramp step at its time and the end action at EndAt.
+// Weights are relative, targets missing from a step get a zero weight.
+type Schedule struct {
+	StartAt      *time.Time         `json:"start_at,omitempty"`
+	StartWeights map[string]float64 `json:"start_weights,omitempty"` // target ID -> weight
+	Ramp         []RampStep         `json:"ramp,omitempty"`
+	EndAt        *time.Time         `json:"end_at,omitempty"`
+	EndAction    *EndAction         `json:"end_action,omitempty"`
+	// Applied is the number of steps applied so far, maintained by the scheduler
+	Applied int `json:"applied"`
+}
+
+type RampStep struct {
+	At      time.Time          `json:"at"`
+	Weights map[string]float64 `json:"weights"` // target ID -> weight
+}
+
+type EndActionType string
+
+const (
+	// EndActionSendAll sends all traffic to the action target and deactivates the other targets
+	EndActionSendAll EndActionType = "send_all"
+)
+
+func (t EndActionType) IsValid() bool {
+	return t == EndActionSendAll
+}
+
+type EndAction struct {
+	Type     EndActionType `json:"type"`
+	TargetID string        `json:"target_id"`
+}
+
+// ScheduleStep is a change of the target weights at a given time
+type ScheduleStep struct {
+	Name    string             `json:"name"` // "start", "ramp_<n>" or "end"
+	At      time.Time          `json:"at"`
+	Weights map[string]float64 `json:"weights"`
+	// Exclusive steps deactivate the targets without weight
+	Exclusive bool `json:"exclusive,omitempty"`
+}
+
+// Steps returns the steps of the schedule in order
+func (s *Schedule) Steps() []ScheduleStep {
+	var steps []ScheduleStep
+	if s.StartAt != nil {
+		steps = append(steps, ScheduleStep{Name: "start", At: *s.StartAt, Weights: s.StartWeights})
+	}
+	for i, step := range s.Ramp {
+		steps = append(steps, ScheduleStep{Name: fmt.Sprintf("ramp_%d", i+1), At: step.At, Weights: step.Weights})
+	}
+	if s.EndAt != nil && s.EndAction != nil {
+		steps = append(steps, ScheduleStep{
+			Name:      "end",
+			At:        *s.EndAt,
+			Weights:   map[string]float64{s.EndAction.TargetID: 1},
+			Exclusive: true,
+		})
+	}
+	return steps
+}
+
+// DueStep returns the index of the step to apply at the given time: the last
+// step whose time has come, when it has not been applied yet. Steps overtaken
+// by a later one, e.g. when the service was down, are skipped.
+func (s *Schedule) DueStep(now time.Time) (int, bool) {
+	steps := s.Steps()
+	due := -1
+	for i, step := range steps {
+		if !step.At.After(now) {
+			due = i
+		}
+	}
+	if due < s.Applied {
+		return 0, false
+	}
+	return due, true
+}
+
+// StartsAt reports whether a proxy in the given state is started by the
+// schedule at the given time: drafts are started once StartAt has come
+func (s *Schedule) StartsAt(state ProxyState, now time.Time) bool {
+	return state == ProxyStateDraft && s.StartAt != nil && !s.StartAt.After(now)
+}
+
+// Apply returns the targets with the weights of the step
+func (step ScheduleStep) Apply(targets []Target) []Target {
+	updated := make([]Target, len(targets))
+	for i, t := range targets {
+		t.Weight = step.Weights[t.ID]
+		if step.Exclusive {
+			t.IsActive = t.Weight > 0
+		}
+		updated[i] = t
+	}
+	return updated
+}
@@ -0,0 +1,106 @@
+package models
+
+// ProxySettings holds optional per-proxy features. It is stored in the
+// proxies.settings JSONB column, every feature lives under its own key.
+type ProxySettings struct {
+	Bucketing    *BucketingSettings    `json:"bucketing,omitempty"`
+	StickyCookie *StickyCookieSettings `json:"sticky_cookie,omitempty"`
+	Goals        []Goal                `json:"goals,omitempty"`
+	// ControlTargetID designates the target variants are compared to
+	ControlTargetID string               `json:"control_target_id,omitempty"`
+	HealthCheck     *HealthCheckSettings `json:"health_check,omitempty"`
+	Schedule        *Schedule            `json:"schedule,omitempty"`
+	Guardrails      []Guardrail          `json:"guardrails,omitempty"`
+	GuardrailTrips  []GuardrailTrip      `json:"guardrail_trips,omitempty"`
+	Redirect        *RedirectSettings    `json:"redirect,omitempty"`
+	Lifecycle       *Lifecycle           `json:"lifecycle,omitempty"`
+	Exclusion       *ExclusionSettings   `json:"exclusion,omitempty"`
+	Allocation      *AllocationSettings  `json:"allocation,omitempty"`
+}
+
+type UnitType string
+
+const (
+	UnitTypeRUID   UnitType = "ruid"   // X-User-ID header if present, ruid otherwise
+	UnitTypeHeader UnitType = "header" // request header named by UnitKey
+	UnitTypeCookie UnitType = "cookie" // cookie named by UnitKey
+	UnitTypeQuery  UnitType = "query"  // query parameter named by UnitKey
+)
+
+func (ut UnitType) IsValid() bool {
+	switch ut {
+	case UnitTypeRUID, UnitTypeHeader, UnitTypeCookie, UnitTypeQuery:
+		return true
+	}
+	return false
+}
+
+// BucketingSettings describes how visitors are assigned to weighted targets.
+// The same (proxy ID, salt, unit ID) always lands on the same target, rotating
+// the salt reshuffles all visitors.
+type BucketingSettings struct {
+	UnitType UnitType `json:"unit_type"`
+	UnitKey  string   `json:"unit_key,omitempty"`
+	Salt     string   `json:"salt"`
+}
+
+// StickyCookieSettings configures the cookie keeping returning visitors on
+// their variant. Domain, SameSite and Secure also apply to rid/rrid/ruid cookies.
+type StickyCookieSettings struct {
+	Name     string `json:"name,omitempty"`      // proxy_<proxy id> when empty
+	MaxAge   int    `json:"max_age,omitempty"`   // Lifetime in seconds, 30 days when zero
+	Domain   string `json:"domain,omitempty"`    // Host-only cookie when empty
+	SameSite string `json:"same_site,omitempty"` // "lax" (default), "strict" or "none"
+	Secure   bool   `json:"secure"`
+}
+
+type GoalType string
+
+const (
+	GoalTypeCount   GoalType = "count"   // every conversion counts as one
+	GoalTypeRevenue GoalType = "revenue" // conversions carry a monetary value
+)
+
+func (gt GoalType) IsValid() bool {
+	switch gt {
+	case GoalTypeCount, GoalTypeRevenue:
+		return true
+	}
+	return false
+}
+
+// Goal is a conversion tracked for a proxy. Conversions of the same visitor
+// within DedupWindow seconds are counted once, zero disables deduplication.
+type Goal struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Type        GoalType `json:"type"`
+	DedupWindow int      `json:"dedup_window"`
+}
+
+// HealthCheckSettings configures active health checks of the proxy targets.
+// Zero values take the defaults noted below.
+type HealthCheckSettings struct {
+	Enabled            bool   `json:"enabled"`
+	Method             string `json:"method,omitempty"`              // GET
+	Path               string `json:"path,omitempty"`                // "/", requested on the target host
+	Interval           int    `json:"interval,omitempty"`            // seconds between checks, 10
+	Timeout            int    `json:"timeout,omitempty"`             // seconds, 2
+	ExpectedStatus     int    `json:"expected_status,omitempty"`     // any 2xx or 3xx when zero
+	HealthyThreshold   int    `json:"healthy_threshold,omitempty"`   // consecutive successes to recover, 2
+	UnhealthyThreshold int    `json:"unhealthy_threshold,omitempty"` // consecutive failures to fail, 3
+}
+
+// RedirectSettings configures the redirects sent to visitors in redirect mode
+type RedirectSettings struct {
+	Status       int    `json:"status,omitempty"`        // 302 (default), 303, 307 or 308
+	CacheControl string `json:"cache_control,omitempty"` // "no-store" when empty
+	MaxHops      int    `json:"max_hops,omitempty"`      // proxies a visitor may be redirected through, 5 when zero
+}
+
+// AllocationSettings enroll a share of the visitors into the experiment. The
+// others are sent to TargetID and kept out of the analysis.
+type AllocationSettings struct {
+	Percent  float64 `json:"percent"`             // share of the visitors enrolled, from 0 to 100
+	TargetID string  `json:"target_id,omitempty"` // receives the visitors not enrolled, the control target when empty
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MatchMode tells how the predicates of a targeting rule are combined
+type MatchMode string
+
+const (
+	MatchAll MatchMode = "all" // every predicate matches (AND), the default
+	MatchAny MatchMode = "any" // at least one predicate matches (OR)
+)
+
+// TargetingRule sends the requests matching its predicates to a fixed target or
+// to a weighted split of its own. Rules are evaluated in order and the first
+// matching rule wins, requests matching no rule get weighted selection.
+type TargetingRule struct {
+	Name  string    `json:"name,omitempty"`
+	Match MatchMode `json:"match,omitempty"`
+	// Predicates of the rule, a rule without predicates matches every request
+	Predicates []Predicate `json:"predicates,omitempty"`
+
+	// Exactly one of TargetID, Split and TargetExpr is set
+	TargetID string           `json:"target_id,omitempty"`
+	Split    []WeightedTarget `json:"split,omitempty"`
+	// TargetExpr is an expression returning the target ID, kept for expr
+	// conditions saved before targeting rules
+	TargetExpr string `json:"target_expr,omitempty"`
+}
+
+// Predicate tests a request attribute. Expr predicates match when Expr is true,
+// the others when the attribute named by Type and ParamName equals one of Values.
+type Predicate struct {
+	Type      ConditionType `json:"type"`
+	ParamName string        `json:"param_name,omitempty"`
+	Values    []string      `json:"values,omitempty"`
+	Expr      string        `json:"expr,omitempty"`
+}
+
+// WeightedTarget is a target of a rule split
+type WeightedTarget struct {
+	TargetID string  `json:"target_id"`
+	Weight   float64 `json:"weight"`
+}
+
+// IsValid reports whether the mode is known, empty means MatchAll
+func (m MatchMode) IsValid() bool {
+	return m == "" || m == MatchAll || m == MatchAny
+}
+
+// Validate checks the structure of the rule and that it only refers to the
+// given targets. Expressions are compiled by the proxy package.
+func (r *TargetingRule) Validate(targetIDs map[string]bool) error {
+	if !r.Match.IsValid() {
+		return fmt.Errorf("invalid match mode %q", r.Match)
+	}
+	for i := range r.Predicates {
+		if err := r.Predicates[i].Validate(); err != nil {
+			return fmt.Errorf("predicates[%d]: %w", i, err)
+		}
+	}
+
+	destinations := 0
+	for _, set := range []bool{r.TargetID != "", len(r.Split) > 0, r.TargetExpr != ""} {
+		if set {
+			destinations++
+		}
+	}
+	if destinations != 1 {
+		return errors.New("exactly one of target_id, split and target_expr is required")
+	}
+
+	if r.TargetID != "" && !targetIDs[r.TargetID] {
+		return fmt.Errorf("target %s not found", r.TargetID)
+	}
+	total := 0.0
+	for _, wt := range r.Split {
+		if !targetIDs[wt.TargetID] {
+			return fmt.Errorf("split target %s not found", wt.TargetID)
+		}
+		if wt.Weight < 0 {
+			return errors.New("split weights must not be negative")
+		}
+		total += wt.Weight
+	}
+	if len(r.Split) > 0 && total <= 0 {
+		return errors.New("split weights must not all be zero")
+	}
+	return nil
+}
+
+// Validate checks that the predicate has what its type needs
+func (p *Predicate) Validate() error {
+	if !p.Type.IsValid() {
+		return fmt.Errorf("invalid condition type %q", p.Type)
+	}
+	if p.Type == ConditionTypeExpr {
+		if p.Expr == "" {
+			return errors.New("expr is required for expr predicates")
+		}
+		return nil
+	}
+	if p.ParamName == "" && p.Type != ConditionTypeLanguage {
+		return fmt.Errorf("param_name is required for %s predicates", p.Type)
+	}
+	if len(p.Values) == 0 {
+		return fmt.Errorf("values are required for %s predicates", p.Type)
+	}
+	return nil
+}
+
+// IsLegacy reports whether the condition was saved before targeting rules
+func (rc *RouteCondition) IsLegacy() bool {
+	return rc != nil && len(rc.Rules) == 0 && rc.Type != ""
+}
+
+// TargetingRules returns the rules of the condition. Legacy conditions are
+// converted: every value leads to its target, in the order of targetIDs, and
+// the default target catches the remaining requests.
+//
+// The API saves legacy Values keyed by target ID, entries keyed by value are
+// accepted too when the value is a target ID.
+func (rc *RouteCondition) TargetingRules(targetIDs []string) []TargetingRule {
+	if rc == nil {
+		return nil
+	}
+	if !rc.IsLegacy() {
+		return rc.Rules
+	}
+
+	var rules []TargetingRule
+	if rc.Type == ConditionTypeExpr && rc.Expr != "" {
+		rules = append(rules, TargetingRule{TargetExpr: rc.Expr})
+	} else {
+		known := make(map[string]bool, len(targetIDs))
+		for _, id := range targetIDs {
+			known[id] = true
+		}
+		predicate := func(value string) []Predicate {
+			if rc.Type == ConditionTypeExpr {
+				return []Predicate{{Type: ConditionTypeExpr, Expr: value}}
+			}
+			return []Predicate{{Type: rc.Type, ParamName: rc.ParamName, Values: []string{value}}}
+		}
+
+		for _, id := range targetIDs {
+			if value, ok := rc.Values[id]; ok {
+				rules = append(rules, TargetingRule{Predicates: predicate(value), TargetID: id})
+			}
+		}
+		var inverted []string
+		for value, id := range rc.Values {
+			if !known[value] && known[id] && rc.Type != ConditionTypeExpr {
+				inverted = append(inverted, value)
+			}
+		}
+		sort.Strings(inverted)
+		for _, value := range inverted {
+			rules = append(rules, TargetingRule{Predicates: predicate(value), TargetID: rc.Values[value]})
+		}
+	}
+
+	if rc.Default != "" {
+		rules = append(rules, TargetingRule{Name: "default", TargetID: rc.Default})
+	}
+	return rules
+}
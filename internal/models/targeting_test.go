@@ -0,0 +1,91 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func headerRule(value, targetID string) TargetingRule {
+	return TargetingRule{
+		Predicates: []Predicate{{Type: ConditionTypeHeader, ParamName: "X-Group", Values: []string{value}}},
+		TargetID:   targetID,
+	}
+}
+
+func TestTargetingRules(t *testing.T) {
+	targetIDs := []string{"a", "b", "c"}
+	defaultRule := TargetingRule{Name: "default", TargetID: "c"}
+	rules := []TargetingRule{{Match: MatchAny, TargetID: "b"}}
+
+	tests := []struct {
+		name      string
+		condition *RouteCondition
+		want      []TargetingRule
+	}{
+		{name: "none"},
+		{name: "rules", condition: &RouteCondition{Rules: rules}, want: rules},
+		{
+			// Rules win over the legacy fields left next to them
+			name:      "rules and legacy fields",
+			condition: &RouteCondition{Rules: rules, Type: ConditionTypeHeader, Default: "c"},
+			want:      rules,
+		},
+		{
+			name: "keyed by target ID, in the order of the targets",
+			condition: &RouteCondition{Type: ConditionTypeHeader, ParamName: "X-Group",
+				Values: map[string]string{"b": "beta", "a": "alpha"}, Default: "c"},
+			want: []TargetingRule{headerRule("alpha", "a"), headerRule("beta", "b"), defaultRule},
+		},
+		{
+			name: "keyed by value, in the order of the values",
+			condition: &RouteCondition{Type: ConditionTypeHeader, ParamName: "X-Group",
+				Values: map[string]string{"beta": "a", "alpha": "b"}},
+			want: []TargetingRule{headerRule("alpha", "b"), headerRule("beta", "a")},
+		},
+		{
+			name: "both forms",
+			condition: &RouteCondition{Type: ConditionTypeHeader, ParamName: "X-Group",
+				Values: map[string]string{"beta": "b", "a": "alpha"}},
+			want: []TargetingRule{headerRule("alpha", "a"), headerRule("beta", "b")},
+		},
+		{
+			name: "unknown targets",
+			condition: &RouteCondition{Type: ConditionTypeHeader, ParamName: "X-Group",
+				Values: map[string]string{"gone": "beta", "other": "removed"}, Default: "c"},
+			want: []TargetingRule{defaultRule},
+		},
+		{
+			name: "expr values",
+			condition: &RouteCondition{Type: ConditionTypeExpr,
+				Values: map[string]string{"b": `Header["X-Group"] == "beta"`, `Query["v"] == "a"`: "a"}},
+			want: []TargetingRule{
+				{Predicates: []Predicate{{Type: ConditionTypeExpr, Expr: `Header["X-Group"] == "beta"`}}, TargetID: "b"},
+			},
+		},
+		{
+			name: "expr returning the target",
+			condition: &RouteCondition{Type: ConditionTypeExpr, Expr: `Query["v"]`,
+				Values: map[string]string{"b": "true"}, Default: "c"},
+			want: []TargetingRule{{TargetExpr: `Query["v"]`}, defaultRule},
+		},
+		{
+			name:      "default only",
+			condition: &RouteCondition{Type: ConditionTypeQuery, ParamName: "v", Default: "c"},
+			want:      []TargetingRule{defaultRule},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.condition.TargetingRules(targetIDs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TargetingRules() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+			for i := range got {
+				if err := got[i].Validate(map[string]bool{"a": true, "b": true, "c": true}); err != nil {
+					t.Errorf("rules[%d]: %v", i, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package notify sends operational events, e.g. automatic rollbacks, to a webhook.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Event is posted as JSON to the webhook. Text makes it readable by chat
+// incoming webhooks such as Slack's.
+type Event struct {
+	Type      string      `json:"type"`
+	ProxyID   string      `json:"proxy_id"`
+	Text      string      `json:"text"`
+	Details   interface{} `json:"details,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Notifier posts events to a webhook, or only logs them when no webhook is configured
+type Notifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func New(webhookURL string) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// Notify sends the event, failures are logged
+func (n *Notifier) Notify(ctx context.Context, event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	log.Printf("Notification %s for proxy %s: %s", event.Type, event.ProxyID, event.Text)
+
+	if n == nil || n.webhookURL == "" {
+		return
+	}
+	if err := n.post(ctx, event); err != nil {
+		log.Printf("Failed to send %s notification: %v", event.Type, err)
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
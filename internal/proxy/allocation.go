@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// RuleNotEnrolled is reported for visitors left out of the experiment by the
+	// traffic allocation
+	RuleNotEnrolled = "not_enrolled"
+	// NotEnrolledStatsID is the target ID the requests of visitors not enrolled
+	// are counted under, apart from the targets of the experiment
+	NotEnrolledStatsID = "not_enrolled"
+)
+
+var notEnrolledRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ab_test_not_enrolled_requests_total",
+		Help: "Total number of requests of visitors left out of the experiment by the traffic allocation",
+	},
+	[]string{"proxy_id"},
+)
+
+// isEnrolled reports whether a unit takes part in the experiment. Units are
+// enrolled when their allocation hash is below the percentage, so raising it
+// only enrolls more units and keeps the enrolled ones.
+func (p *Proxy) isEnrolled(unitID string) bool {
+	allocation := p.Config.Settings.Allocation
+	if allocation == nil || allocation.Percent >= 100 {
+		return true
+	}
+	return BucketHash(p.ID, p.salt(), unitID, "allocation")*100 < allocation.Percent
+}
+
+// getNotEnrolledTarget returns the target of visitors left out of the
+// experiment, nil when the visitor is enrolled. Must be called with p.mutex
+// held.
+func (p *Proxy) getNotEnrolledTarget(r *http.Request, info *RedirectInfo) *Target {
+	if p.isEnrolled(p.unitID(r, info)) {
+		return nil
+	}
+	return p.targetOrControl(p.Config.Settings.Allocation.TargetID)
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	assignmentKeyPrefix = "ab:assignment:"
+	conversionKeyPrefix = "ab:conversion:"
+
+	ruidAssignmentTTL = 30 * 24 * time.Hour // visitors converting within 30 days are attributed
+	rridAssignmentTTL = 24 * time.Hour      // matches the rrid cookie lifetime
+
+	assignmentQueueSize = 4096
+	assignmentBatchSize = 256
+)
+
+type assignment struct {
+	proxyID  string
+	ruid     string
+	rrid     string
+	targetID string
+}
+
+// AssignmentStore remembers which target visitors were sent to, keyed by ruid
+// and rrid, so that conversions reported later can be attributed to a variant.
+//
+// Assignments are written to Redis in batches by Run, serving a request never
+// waits for Redis. When the queue is full assignments are dropped.
+type AssignmentStore struct {
+	client *redis.Client
+	queue  chan assignment
+}
+
+func NewAssignmentStore(client *redis.Client) *AssignmentStore {
+	return &AssignmentStore{
+		client: client,
+		queue:  make(chan assignment, assignmentQueueSize),
+	}
+}
+
+func ruidAssignmentKey(proxyID, ruid string) string {
+	return assignmentKeyPrefix + proxyID + ":ruid:" + ruid
+}
+
+func rridAssignmentKey(proxyID, rrid string) string {
+	return assignmentKeyPrefix + proxyID + ":rrid:" + rrid
+}
+
+// record queues an assignment without blocking
+func (as *AssignmentStore) record(a assignment) {
+	if as == nil {
+		return
+	}
+
+	select {
+	case as.queue <- a:
+	default:
+		log.Printf("Assignment queue is full, dropping assignment for proxy %s", a.proxyID)
+	}
+}
+
+// Run writes queued assignments to Redis until the context is canceled
+func (as *AssignmentStore) Run(ctx context.Context) {
+	batch := make([]assignment, 0, assignmentBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-as.queue:
+			batch = append(batch[:0], a)
+			// Take whatever else is already queued
+		drain:
+			for len(batch) < assignmentBatchSize {
+				select {
+				case a := <-as.queue:
+					batch = append(batch, a)
+				default:
+					break drain
+				}
+			}
+			as.flush(ctx, batch)
+		}
+	}
+}
+
+func (as *AssignmentStore) flush(ctx context.Context, batch []assignment) {
+	pipe := as.client.Pipeline()
+	for _, a := range batch {
+		if a.ruid != "" {
+			pipe.Set(ctx, ruidAssignmentKey(a.proxyID, a.ruid), a.targetID, ruidAssignmentTTL)
+		}
+		if a.rrid != "" {
+			pipe.Set(ctx, rridAssignmentKey(a.proxyID, a.rrid), a.targetID, rridAssignmentTTL)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to save %d assignments: %v", len(batch), err)
+	}
+}
+
+// lookup returns the target the visitor was last sent to. The rrid identifies
+// a single click and is checked first, then the ruid. An empty target ID is
+// returned when neither is known.
+func (as *AssignmentStore) lookup(ctx context.Context, proxyID, ruid, rrid string) (string, error) {
+	if as == nil {
+		return "", nil
+	}
+
+	var keys []string
+	if rrid != "" {
+		keys = append(keys, rridAssignmentKey(proxyID, rrid))
+	}
+	if ruid != "" {
+		keys = append(keys, ruidAssignmentKey(proxyID, ruid))
+	}
+
+	for _, key := range keys {
+		targetID, err := as.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return targetID, nil
+	}
+	return "", nil
+}
+
+// claimConversion returns true if the visitor did not convert on the goal
+// within the window, and marks the conversion for the window
+func (as *AssignmentStore) claimConversion(ctx context.Context, goalID, visitorID string, window time.Duration) (bool, error) {
+	if as == nil {
+		return true, nil
+	}
+	return as.client.SetNX(ctx, conversionKeyPrefix+goalID+":"+visitorID, 1, window).Result()
+}
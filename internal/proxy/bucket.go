@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"net/http"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// unitID returns the identifier the visitor is bucketed by, according to the
+// proxy bucketing settings. When the configured source is empty the visitor
+// falls back to X-User-ID and then to the ruid.
+func (p *Proxy) unitID(r *http.Request, info *RedirectInfo) string {
+	if b := p.Config.Settings.Bucketing; b != nil && b.UnitKey != "" {
+		var value string
+		switch b.UnitType {
+		case models.UnitTypeHeader:
+			value = r.Header.Get(b.UnitKey)
+		case models.UnitTypeCookie:
+			if cookie, err := r.Cookie(b.UnitKey); err == nil {
+				value = cookie.Value
+			}
+		case models.UnitTypeQuery:
+			value = r.URL.Query().Get(b.UnitKey)
+		}
+		if value != "" {
+			return value
+		}
+	}
+
+	if userID := r.Header.Get("X-User-ID"); userID != "" {
+		return userID
+	}
+	return info.RUID
+}
+
+func (p *Proxy) salt() string {
+	if b := p.Config.Settings.Bucketing; b != nil {
+		return b.Salt
+	}
+	return ""
+}
+
+// BucketHash returns the uniform value in (0, 1) assigned to a unit for a target.
+//
+// It is computed from the first 8 bytes of
+//
+//	sha256(proxyID + "\x00" + salt + "\x00" + unitID + "\x00" + targetID)
+//
+// read as a big-endian uint64, keeping the top 53 bits: (h>>11 + 0.5) / 2^53.
+func BucketHash(proxyID, salt, unitID, targetID string) float64 {
+	h := sha256.New()
+	h.Write([]byte(proxyID))
+	h.Write([]byte{0})
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(unitID))
+	h.Write([]byte{0})
+	h.Write([]byte(targetID))
+	sum := h.Sum(nil)
+
+	return (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+}
+
+// pickWeighted selects a target using weighted rendezvous hashing: every target
+// gets the score -weight / ln(BucketHash(...)) and the highest score wins.
+//
+// The outcome only depends on the proxy ID, salt, unit ID and the target IDs and
+// weights, so it can be recomputed offline. Changing the weight of a target
+// only moves the visitors that have to move: when it grows the target only
+// gains visitors, when it shrinks it only loses them. Changing several weights
+// at once may also move visitors between the other targets.
+func pickWeighted(proxyID, salt, unitID string, targets []Target) *Target {
+	var best *Target
+	bestScore := math.Inf(-1)
+
+	for i := range targets {
+		target := &targets[i]
+		if target.Weight <= 0 {
+			continue
+		}
+		score := -target.Weight / math.Log(BucketHash(proxyID, salt, unitID, target.ID))
+		if score > bestScore {
+			best, bestScore = target, score
+		}
+	}
+
+	return best
+}
+
+// Assign returns the target a unit gets from weighted selection, without
+// taking cookies or conditions into account.
+func (p *Proxy) Assign(unitID string) *Target {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.assign(unitID)
+}
+
+// assign picks among the active targets that pass their health checks. When
+// every active target fails them, it picks among all active targets rather
+// than failing the request.
+func (p *Proxy) assign(unitID string) *Target {
+	var activeTargets, availableTargets []Target
+	for _, target := range p.Targets {
+		if target.IsActive {
+			activeTargets = append(activeTargets, target)
+			if p.isAvailable(target) {
+				availableTargets = append(availableTargets, target)
+			}
+		}
+	}
+	if len(activeTargets) == 0 {
+		return nil
+	}
+	if len(availableTargets) > 0 {
+		activeTargets = availableTargets
+	}
+
+	if target := pickWeighted(p.ID, p.salt(), unitID, activeTargets); target != nil {
+		return target
+	}
+	// All weights are zero
+	return &activeTargets[0]
+}
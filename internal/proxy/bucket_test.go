@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+const bucketUnits = 20000
+
+// bucketTolerance is 5 standard errors of a share of bucketUnits, at most
+var bucketTolerance = 5 * math.Sqrt(0.25/bucketUnits)
+
+func unitIDs() []string {
+	ids := make([]string, bucketUnits)
+	for i := range ids {
+		ids[i] = "user-" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+// assignAll returns the target ID of every unit
+func assignAll(salt string, units []string, targets []Target) []string {
+	assigned := make([]string, len(units))
+	for i, unit := range units {
+		assigned[i] = pickWeighted("proxy", salt, unit, targets).ID
+	}
+	return assigned
+}
+
+func checkShare(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > bucketTolerance {
+		t.Errorf("%s = %.4f, want %.4f ± %.4f", name, got, want, bucketTolerance)
+	}
+}
+
+func TestBucketHash(t *testing.T) {
+	if BucketHash("proxy", "salt", "user", "a") != BucketHash("proxy", "salt", "user", "a") {
+		t.Fatal("BucketHash is not deterministic")
+	}
+
+	var sum float64
+	for _, unit := range unitIDs() {
+		h := BucketHash("proxy", "", unit, "a")
+		if h <= 0 || h >= 1 {
+			t.Fatalf("BucketHash() = %v, want in (0, 1)", h)
+		}
+		sum += h
+	}
+	// Uniform values have a mean of 1/2 and a variance of 1/12
+	checkShare(t, "mean", sum/bucketUnits, 0.5)
+}
+
+func TestPickWeightedSplit(t *testing.T) {
+	targets := []Target{{ID: "a", Weight: 0.5}, {ID: "b", Weight: 0.3}, {ID: "c", Weight: 0.2}, {ID: "off"}}
+
+	counts := make(map[string]int)
+	for _, id := range assignAll("", unitIDs(), targets) {
+		counts[id]++
+	}
+	for _, target := range targets {
+		checkShare(t, "share of "+target.ID, float64(counts[target.ID])/bucketUnits, target.Weight)
+	}
+
+	if got := pickWeighted("proxy", "", "user", []Target{{ID: "a"}, {ID: "b"}}); got != nil {
+		t.Errorf("pickWeighted() without weights = %s, want nil", got.ID)
+	}
+}
+
+func TestPickWeightedChange(t *testing.T) {
+	units := unitIDs()
+	before := assignAll("", units, []Target{{ID: "a", Weight: 0.5}, {ID: "b", Weight: 0.3}, {ID: "c", Weight: 0.2}})
+
+	// Weights are relative, the share of b goes from 0.3 to weight / (0.7 + weight)
+	for _, weight := range []float64{0.5, 0.1} {
+		t.Run(strconv.FormatFloat(weight, 'f', -1, 64), func(t *testing.T) {
+			after := assignAll("", units, []Target{{ID: "a", Weight: 0.5}, {ID: "b", Weight: weight}, {ID: "c", Weight: 0.2}})
+			grows := weight > 0.3
+
+			moved := 0
+			for i := range units {
+				if before[i] == after[i] {
+					continue
+				}
+				moved++
+				// Only b gains visitors when it grows, and only loses them when it shrinks
+				if (grows && after[i] != "b") || (!grows && before[i] != "b") {
+					t.Fatalf("unit %s moved from %s to %s", units[i], before[i], after[i])
+				}
+			}
+			checkShare(t, "moved", float64(moved)/bucketUnits, math.Abs(weight/(0.7+weight)-0.3))
+		})
+	}
+}
+
+func TestPickWeightedReshuffle(t *testing.T) {
+	units := unitIDs()
+	targets := []Target{{ID: "a", Weight: 0.5}, {ID: "b", Weight: 0.3}, {ID: "c", Weight: 0.2}}
+	before := assignAll("", units, targets)
+	after := assignAll("reshuffled", units, targets)
+
+	moved := 0
+	counts := make(map[string]int)
+	for i := range units {
+		if before[i] != after[i] {
+			moved++
+		}
+		counts[after[i]]++
+	}
+
+	// Independent assignments only agree with probability 0.5² + 0.3² + 0.2²
+	checkShare(t, "moved", float64(moved)/bucketUnits, 1-0.38)
+	for _, target := range targets {
+		checkShare(t, "share of "+target.ID, float64(counts[target.ID])/bucketUnits, target.Weight)
+	}
+}
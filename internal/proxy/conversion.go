@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+var (
+	ErrUnknownGoal = errors.New("unknown goal")
+	ErrNotAssigned = errors.New("visitor has no assigned target")
+)
+
+// Conversion is a goal completion reported by a client. The visitor is
+// identified by the ruid and/or rrid issued when it was sent to a target.
+type Conversion struct {
+	Goal  string  // goal ID or name
+	RUID  string  // redirect user ID
+	RRID  string  // redirect request ID
+	Value float64 // revenue, ignored for count goals
+}
+
+type ConversionResult struct {
+	GoalID   string `json:"goal_id"`
+	TargetID string `json:"target_id"`
+	Counted  bool   `json:"counted"` // false when deduplicated
+}
+
+// Goal returns the goal with the given ID or name
+func (p *Proxy) Goal(key string) *models.Goal {
+	for i, goal := range p.Config.Settings.Goals {
+		if goal.ID == key || goal.Name == key {
+			return &p.Config.Settings.Goals[i]
+		}
+	}
+	return nil
+}
+
+// RecordConversion attributes a conversion to the target the visitor was
+// assigned and counts it in the proxy statistics, unless the visitor already
+// converted on the goal within its deduplication window.
+func (p *Proxy) RecordConversion(ctx context.Context, conv Conversion) (*ConversionResult, error) {
+	if !p.countsStats() {
+		return nil, ErrNotRunning
+	}
+
+	goal := p.Goal(conv.Goal)
+	if goal == nil {
+		return nil, ErrUnknownGoal
+	}
+
+	targetID, err := p.runtime.Assignments.lookup(ctx, p.ID, conv.RUID, conv.RRID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up assignment: %w", err)
+	}
+	if targetID == "" {
+		return nil, ErrNotAssigned
+	}
+
+	result := &ConversionResult{GoalID: goal.ID, TargetID: targetID, Counted: true}
+
+	visitorID := conv.RUID
+	if visitorID == "" {
+		visitorID = conv.RRID
+	}
+	if goal.DedupWindow > 0 {
+		window := time.Duration(goal.DedupWindow) * time.Second
+		result.Counted, err = p.runtime.Assignments.claimConversion(ctx, goal.ID, visitorID, window)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deduplicate conversion: %w", err)
+		}
+		if !result.Counted {
+			return result, nil
+		}
+	}
+
+	var value float64
+	if goal.Type == models.GoalTypeRevenue {
+		value = conv.Value
+	}
+	p.stats.IncrementConversions(targetID, goal.ID, value)
+	p.metrics.ConversionsTotal.WithLabelValues(p.targetLabel(targetID), goal.Name).Inc()
+
+	return result, nil
+}
+
+// targetLabel returns the target URL used as metrics label, or the ID of a
+// target removed since the visitor was assigned
+func (p *Proxy) targetLabel(targetID string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, target := range p.Targets {
+		if target.ID == targetID {
+			return target.URL
+		}
+	}
+	return targetID
+}
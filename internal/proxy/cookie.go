@@ -1,65 +1,106 @@
 package proxy
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/ab-testing-service/internal/models"
 )
 
+const defaultStickyCookieMaxAge = 3600 * 24 * 30 // 30 days
+
+// cookieSettings returns the sticky cookie settings with defaults applied
+func (p *Proxy) cookieSettings() models.StickyCookieSettings {
+	var settings models.StickyCookieSettings
+	if p.Config.Settings.StickyCookie != nil {
+		settings = *p.Config.Settings.StickyCookie
+	}
+	if settings.Name == "" {
+		settings.Name = fmt.Sprintf("proxy_%s", p.ID)
+	}
+	if settings.MaxAge == 0 {
+		settings.MaxAge = defaultStickyCookieMaxAge
+	}
+	return settings
+}
+
+func sameSiteMode(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// stickyValue encodes the target ID with a signature binding it to the proxy
+// and its bucketing salt, so that rotating the salt invalidates the cookies
+func (p *Proxy) stickyValue(targetID string) string {
+	return targetID + "." + p.runtime.sign("sticky", p.ID, p.salt(), targetID)
+}
+
+// getTargetFromCookie returns the target stored in a valid sticky cookie.
+// Cookies with a bad signature, signed with a previous salt or pointing to an
+// inactive or removed target are ignored, the visitor is then assigned again
+// and the cookie overwritten.
+// Must be called with p.mutex held.
 func (p *Proxy) getTargetFromCookie(r *http.Request) *Target {
 	cookie, err := r.Cookie(p.cookieName)
 	if err != nil {
 		return nil
 	}
 
-	p.mutex.RLock()
-	defer p.mutex.RUnlock()
-
-	for _, target := range p.Targets {
-		if target.IsActive && target.URL == cookie.Value {
-			return &target
-		}
+	targetID, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !p.runtime.verify(signature, "sticky", p.ID, p.salt(), targetID) {
+		return nil
 	}
-	return nil
+
+	return p.getTargetById(targetID)
 }
 
-func (p *Proxy) setCookies(w http.ResponseWriter, info *RedirectInfo) {
+func (p *Proxy) setCookies(w http.ResponseWriter, info *RedirectInfo, target *Target) {
 	// Only set cookies if saving_cookies_flg is true
 	if !p.SavingCookiesFlg {
 		return
 	}
 
-	// Set ab_target cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "ab_target_",
-		Value:    p.Targets[0].URL,
-		Path:     "/",
-		MaxAge:   3600 * 24, // 24 hours
-		HttpOnly: true,
-	})
+	// Set sticky target cookie
+	http.SetCookie(w, p.newCookie(p.cookieName, p.stickyValue(target.ID), p.cookieSettings().MaxAge))
+
+	p.setIDCookies(w, info)
+}
+
+// setIDCookies sets the rid, rrid and ruid cookies, so that returning visitors
+// keep their identifiers even when they keep no target
+func (p *Proxy) setIDCookies(w http.ResponseWriter, info *RedirectInfo) {
+	// Only set cookies if saving_cookies_flg is true
+	if !p.SavingCookiesFlg {
+		return
+	}
 
 	// Set RID cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "rid",
-		Value:    info.RID,
-		Path:     "/",
-		MaxAge:   3600 * 24 * 30, // 30 days
-		HttpOnly: true,
-	})
+	http.SetCookie(w, p.newCookie("rid", info.RID, 3600*24*30)) // 30 days
 
 	// Set RRID cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "rrid",
-		Value:    info.RRID,
-		Path:     "/",
-		MaxAge:   3600 * 24, // 24 hours
-		HttpOnly: true,
-	})
+	http.SetCookie(w, p.newCookie("rrid", info.RRID, 3600*24)) // 24 hours
 
 	// Set RUID cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "ruid",
-		Value:    info.RUID,
+	http.SetCookie(w, p.newCookie("ruid", info.RUID, 3600*24*365)) // 1 year
+}
+
+func (p *Proxy) newCookie(name, value string, maxAge int) *http.Cookie {
+	settings := p.cookieSettings()
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
 		Path:     "/",
-		MaxAge:   3600 * 24 * 365, // 1 year
+		Domain:   settings.Domain,
+		MaxAge:   maxAge,
+		Secure:   settings.Secure,
 		HttpOnly: true,
-	})
+		SameSite: sameSiteMode(settings.SameSite),
+	}
 }
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+func stickyProxy(id, salt string) *Proxy {
+	p := testProxy(id)
+	p.Config.Settings.Bucketing = &models.BucketingSettings{Salt: salt}
+	p.cookieName = p.cookieSettings().Name
+	p.Targets = []Target{
+		{ID: "t1", Weight: 0.5, IsActive: true},
+		{ID: "t2", Weight: 0.5, IsActive: true},
+		{ID: "off", IsActive: false},
+	}
+	return p
+}
+
+func TestGetTargetFromCookie(t *testing.T) {
+	p := stickyProxy("p", "salt")
+	reshuffled := stickyProxy("p", "new salt")
+	otherProxy := stickyProxy("q", "salt")
+	valid := p.stickyValue("t1")
+
+	tests := []struct {
+		name   string
+		value  string // empty for no cookie
+		target string // empty when the cookie is ignored
+	}{
+		{name: "no cookie"},
+		{name: "valid", value: valid, target: "t1"},
+		{name: "tampered target", value: "t2" + valid[len("t1"):]},
+		{name: "old salt", value: reshuffled.stickyValue("t1")},
+		{name: "other proxy", value: otherProxy.stickyValue("t1")},
+		{name: "no signature", value: "t1"},
+		{name: "inactive target", value: p.stickyValue("off")},
+		{name: "removed target", value: p.stickyValue("gone")},
+		{name: "empty signature", value: "t1."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.value != "" {
+				r.AddCookie(&http.Cookie{Name: p.cookieName, Value: tt.value})
+			}
+
+			var got string
+			if target := p.getTargetFromCookie(r); target != nil {
+				got = target.ID
+			}
+			if got != tt.target {
+				t.Errorf("getTargetFromCookie() = %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestStickyCookieReshuffle(t *testing.T) {
+	// A cookie set before a reshuffle is rejected once the salt changes
+	p := stickyProxy("p", "salt")
+	w := httptest.NewRecorder()
+	p.SavingCookiesFlg = true
+	p.setCookies(w, &RedirectInfo{}, &p.Targets[0])
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	if target := p.getTargetFromCookie(r); target == nil || target.ID != "t1" {
+		t.Fatalf("getTargetFromCookie() before the reshuffle = %v, want t1", target)
+	}
+
+	p.Config.Settings.Bucketing.Salt = "new salt"
+	if target := p.getTargetFromCookie(r); target != nil {
+		t.Errorf("getTargetFromCookie() after the reshuffle = %s, want none", target.ID)
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// Reasons reported for excluded requests
+const (
+	ExclusionReasonBot       = "bot"        // built-in bot list
+	ExclusionReasonUserAgent = "user_agent" // configured user agent patterns
+	ExclusionReasonIP        = "ip"         // denied CIDR
+	ExclusionReasonHeader    = "header"     // header rule
+
+	// RuleExcluded is reported for requests sent to the exclusion target
+	RuleExcluded = "excluded"
+)
+
+// builtinBots are lowercase user agent substrings of crawlers, link preview
+// fetchers, uptime monitors and HTTP libraries
+var builtinBots = []string{
+	// Search engines and crawlers
+	"googlebot", "google-inspectiontool", "adsbot-google", "mediapartners-google", "bingbot", "bingpreview",
+	"yandexbot", "baiduspider", "duckduckbot", "applebot", "yahoo! slurp", "petalbot", "bytespider",
+	"ahrefsbot", "semrushbot", "mj12bot", "dotbot", "gptbot", "ccbot", "claudebot", "amazonbot",
+	"crawler", "spider", "+http",
+	// Link previews
+	"facebookexternalhit", "facebot", "twitterbot", "slackbot", "discordbot", "telegrambot",
+	"linkedinbot", "whatsapp", "skypeuripreview", "embedly", "pinterest", "vkshare",
+	// Monitors and headless browsers
+	"pingdom", "uptimerobot", "statuscake", "site24x7", "newrelicpinger", "datadog", "checkly",
+	"headlesschrome", "phantomjs", "lighthouse", "ab-testing-service-health-check",
+	// HTTP libraries
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "okhttp",
+	"apache-httpclient", "java/", "libwww-perl", "node-fetch", "axios/",
+}
+
+var excludedRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ab_test_excluded_requests_total",
+		Help: "Total number of requests kept out of the experiment, by reason",
+	},
+	[]string{"proxy_id", "reason"},
+)
+
+// ExclusionRules are compiled exclusion rules
+type ExclusionRules struct {
+	userAgents  []string
+	builtinBots bool
+	allow       []*net.IPNet
+	deny        []*net.IPNet
+	headers     []models.HeaderRule
+}
+
+// NewExclusionRules compiles exclusion rules, it returns nil when there are none
+func NewExclusionRules(rules models.ExclusionRules) (*ExclusionRules, error) {
+	er := &ExclusionRules{builtinBots: !rules.DisableBuiltinBots, headers: rules.Headers}
+	for _, ua := range rules.UserAgents {
+		er.userAgents = append(er.userAgents, strings.ToLower(strings.TrimSpace(ua)))
+	}
+	for _, cidr := range rules.AllowCIDRs {
+		network, err := models.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		er.allow = append(er.allow, network)
+	}
+	for _, cidr := range rules.DenyCIDRs {
+		network, err := models.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		er.deny = append(er.deny, network)
+	}
+	return er, nil
+}
+
+func (er *ExclusionRules) allows(ip net.IP) bool {
+	return ip != nil && containsIP(er.allow, ip)
+}
+
+// reason returns why the rules exclude the request, empty when they don't
+func (er *ExclusionRules) reason(r *http.Request, ip net.IP) string {
+	if ip != nil && containsIP(er.deny, ip) {
+		return ExclusionReasonIP
+	}
+	for _, h := range er.headers {
+		for _, value := range r.Header.Values(h.Name) {
+			if h.Value == "" || strings.EqualFold(value, h.Value) {
+				return ExclusionReasonHeader
+			}
+		}
+	}
+
+	userAgent := strings.ToLower(r.UserAgent())
+	if userAgent == "" {
+		return ""
+	}
+	for _, pattern := range er.userAgents {
+		if strings.Contains(userAgent, pattern) {
+			return ExclusionReasonUserAgent
+		}
+	}
+	return ""
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBuiltinBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, pattern := range builtinBots {
+		if strings.Contains(userAgent, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// exclusionRules returns the global and proxy rules applying to the proxy
+func (p *Proxy) exclusionRules() []*ExclusionRules {
+	var rules []*ExclusionRules
+	settings := p.Config.Settings.Exclusion
+	if p.runtime.Exclusions != nil && (settings == nil || !settings.IgnoreGlobal) {
+		rules = append(rules, p.runtime.Exclusions)
+	}
+	if p.exclusion != nil {
+		rules = append(rules, p.exclusion)
+	}
+	return rules
+}
+
+// exclusionReason returns why the request is kept out of the experiment, empty
+// when it takes part. Allowed IPs are never excluded, the built-in bot list
+// applies unless the global or proxy rules disable it.
+func (p *Proxy) exclusionReason(r *http.Request) string {
+	rules := p.exclusionRules()
+	ip := net.ParseIP(getClientIP(r))
+	for _, er := range rules {
+		if er.allows(ip) {
+			return ""
+		}
+	}
+
+	builtin := true
+	for _, er := range rules {
+		if reason := er.reason(r, ip); reason != "" {
+			return reason
+		}
+		builtin = builtin && er.builtinBots
+	}
+	if builtin && isBuiltinBot(r.UserAgent()) {
+		return ExclusionReasonBot
+	}
+	return ""
+}
+
+// getExcludedTarget returns the target of excluded requests and counts them,
+// nil when the request takes part in the experiment. Must be called with
+// p.mutex held.
+func (p *Proxy) getExcludedTarget(r *http.Request) *Target {
+	reason := p.exclusionReason(r)
+	if reason == "" {
+		return nil
+	}
+	excludedRequests.WithLabelValues(p.ID, reason).Inc()
+
+	var targetID string
+	if settings := p.Config.Settings.Exclusion; settings != nil {
+		targetID = settings.TargetID
+	}
+	return p.targetOrControl(targetID)
+}
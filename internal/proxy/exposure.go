@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// ExposureMessageType marks Kafka messages carrying a batch of exposures,
+	// aggregated statistics messages have no type
+	ExposureMessageType = "exposures"
+
+	exposureQueueSize     = 8192
+	exposureBatchSize     = 500
+	exposureFlushInterval = time.Second
+)
+
+var exposuresDropped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ab_test_exposures_dropped_total",
+		Help: "Total number of exposure events dropped because the queue was full",
+	},
+	[]string{"proxy_id"},
+)
+
+// Exposure is a visitor being sent to a target
+type Exposure struct {
+	ProxyID   string    `json:"proxy_id"`
+	TargetID  string    `json:"target_id"`
+	UserID    string    `json:"user_id"`
+	RID       string    `json:"rid"`
+	RRID      string    `json:"rrid"`
+	RUID      string    `json:"ruid"`
+	Timestamp time.Time `json:"timestamp"`
+	// Rule is what selected the target: "sticky_cookie", "weighted" or the
+	// targeting rule, "rule:<name>" or "rule:<position>" (from 1) when unnamed.
+	// Overridden, excluded and not enrolled requests log no exposure.
+	Rule string `json:"rule"`
+
+	// Request attributes
+	Method    string `json:"method"`
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Referer   string `json:"referer"`
+	Language  string `json:"language"`
+}
+
+type ExposureBatch struct {
+	Type   string     `json:"type"`
+	Events []Exposure `json:"events"`
+}
+
+// MessageWriter writes messages to Kafka
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// ExposureLog ships exposure events to Kafka in batches. Emitting never
+// blocks: when Run can't keep up and the queue is full, events are dropped
+// and counted in ab_test_exposures_dropped_total.
+type ExposureLog struct {
+	writer MessageWriter
+	queue  chan Exposure
+}
+
+func NewExposureLog(writer MessageWriter) *ExposureLog {
+	return &ExposureLog{
+		writer: writer,
+		queue:  make(chan Exposure, exposureQueueSize),
+	}
+}
+
+func (el *ExposureLog) emit(e Exposure) {
+	if el == nil {
+		return
+	}
+
+	select {
+	case el.queue <- e:
+	default:
+		exposuresDropped.WithLabelValues(e.ProxyID).Inc()
+	}
+}
+
+// Run sends batches of exposures every second, or as soon as a batch is full,
+// until the context is canceled
+func (el *ExposureLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(exposureFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Exposure, 0, exposureBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-el.queue:
+			batch = append(batch, e)
+			if len(batch) < exposureBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		el.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (el *ExposureLog) flush(ctx context.Context, batch []Exposure) {
+	msgBytes, err := json.Marshal(ExposureBatch{Type: ExposureMessageType, Events: batch})
+	if err != nil {
+		log.Printf("Error marshaling exposures: %v", err)
+		return
+	}
+
+	if err := el.writer.WriteMessages(ctx, kafka.Message{Value: msgBytes}); err != nil {
+		log.Printf("Error writing %d exposures: %v", len(batch), err)
+	}
+}
+
+func newExposure(p *Proxy, r *http.Request, info *RedirectInfo, target *Target, rule, userID string) Exposure {
+	return Exposure{
+		ProxyID:   p.ID,
+		TargetID:  target.ID,
+		UserID:    userID,
+		RID:       info.RID,
+		RRID:      info.RRID,
+		RUID:      info.RUID,
+		Timestamp: time.Now(),
+		Rule:      rule,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		IP:        getClientIP(r),
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+		Language:  parseAcceptLanguage(r.Header.Get("Accept-Language")),
+	}
+}
@@ -1,82 +1,29 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strings"
-	"time"
 
 	"github.com/expr-lang/expr"
-)
-
-// evaluateExpression evaluates an expression using the expr-lang/expr library
-// and returns the result and any error
-func evaluateExpression(expression string, env map[string]interface{}) (interface{}, error) {
-	program, err := expr.Compile(expression, expr.Env(env))
-	if err != nil {
-		return nil, fmt.Errorf("error compiling expression: %w", err)
-	}
-
-	result, err := expr.Run(program, env)
-	if err != nil {
-		return nil, fmt.Errorf("error evaluating expression: %w", err)
-	}
-
-	return result, nil
-}
-
-// evaluateBooleanExpression evaluates an expression and returns true if it evaluates to a boolean true,
-// false otherwise
-func evaluateBooleanExpression(expression string, env map[string]interface{}) (bool, error) {
-	result, err := evaluateExpression(expression, env)
-	if err != nil {
-		return false, err
-	}
-
-	boolResult, ok := result.(bool)
-	if !ok {
-		return false, fmt.Errorf("expression did not return a boolean, got %T: %v", result, result)
-	}
-
-	return boolResult, nil
-}
-
-// evaluateStringExpression evaluates an expression and returns the string result,
-// or an error if the result is not a string
-func evaluateStringExpression(expression string, env map[string]interface{}) (string, error) {
-	result, err := evaluateExpression(expression, env)
-	if err != nil {
-		return "", err
-	}
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/vm"
 
-	stringResult, ok := result.(string)
-	if !ok {
-		return "", fmt.Errorf("expression did not return a string, got %T: %v", result, result)
-	}
-
-	return stringResult, nil
-}
+	"github.com/ab-testing-service/internal/geoip"
+)
 
-// getTargetByExpr evaluates an expression using expr-lang/expr and returns the matching target
-//
-// Expressions can be used in two ways:
-//
-//  1. Single expression in the Expr field that returns a target ID:
-//     "headers['user-agent'] contains 'iPhone' ? 'target-1' : 'target-2'"
-//
-//  2. Multiple expressions in the Values map, each evaluating to a boolean:
-//     {
-//     "target-1": "headers['user-agent'] contains 'iPhone'",
-//     "target-2": "query['version'] == '2'"
-//     }
+// exprEnv is the typed environment expressions are compiled against and run in.
 //
 // Available variables in expressions:
-// - headers: map of request headers (string keys, string values)
-// - query: map of query parameters (string keys, string values)
-// - cookies: map of cookies (string keys, string values)
+// - headers: map of request headers (lower case keys, first value)
+// - query: map of query parameters (first value)
+// - cookies: map of cookies
 // - method: request method (GET, POST, etc.)
 // - path: request path
 // - host: request host
@@ -84,54 +31,89 @@ func evaluateStringExpression(expression string, env map[string]interface{}) (st
 // - referer: request referer
 // - protocol: request protocol
 // - url: full request URL
+// - country, region, city: client location, empty without GeoIP database
 //
-// For more information on the expression syntax, see: https://github.com/expr-lang/expr
-func (p *Proxy) getTargetByExpr(r *http.Request) *Target {
-	// Create environment with request data for the expression
-	env := createExpressionEnv(r)
+// Functions for traffic steering:
+// - random(min, max): random number, different on every request
+// - randomUser(min, max): stable number for the client IP
+// - randomCookie(name, min, max): stable number for the cookie value, falls back to randomUser
+// - randomParam(value, min, max): stable number for the value, falls back to randomUser
+type exprEnv struct {
+	Headers  map[string]string `expr:"headers"`
+	Query    map[string]string `expr:"query"`
+	Cookies  map[string]string `expr:"cookies"`
+	Method   string            `expr:"method"`
+	Path     string            `expr:"path"`
+	Host     string            `expr:"host"`
+	IP       string            `expr:"ip"`
+	Referer  string            `expr:"referer"`
+	Protocol string            `expr:"protocol"`
+	URL      string            `expr:"url"`
+	Country  string            `expr:"country"`
+	Region   string            `expr:"region"`
+	City     string            `expr:"city"`
+
+	Random       func(min, max int) int               `expr:"random"`
+	RandomUser   func(min, max int) int               `expr:"randomUser"`
+	RandomCookie func(name string, min, max int) int  `expr:"randomCookie"`
+	RandomParam  func(value string, min, max int) int `expr:"randomParam"`
+}
 
-	// If there's a specific expression in the Expr field, evaluate it
-	if p.Config.Condition.Expr != "" {
-		targetID, err := evaluateStringExpression(p.Config.Condition.Expr, env)
-		if err != nil {
-			log.Printf("Error evaluating expression for proxy %s: %v", p.ID, err)
-			return p.getTargetById(p.Config.Condition.Default)
-		}
+// ExprError is an expression compilation error. Line and Column are 1-based and
+// point to the offending token, or to the start of the expression when its
+// result has the wrong type.
+type ExprError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
 
-		if target := p.getTargetById(targetID); target != nil {
-			return target
-		}
-		log.Printf("Expression returned target ID %s, but no matching target found for proxy %s", targetID, p.ID)
-	} else {
-		// Evaluate each expression in Values map
-		for targetID, expression := range p.Config.Condition.Values {
-			isMatch, err := evaluateBooleanExpression(expression, env)
-			if err != nil {
-				log.Printf("Error evaluating expression for target %s in proxy %s: %v", targetID, p.ID, err)
-				continue
-			}
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
 
-			if isMatch {
-				if target := p.getTargetById(targetID); target != nil {
-					return target
-				}
-				log.Printf("Expression evaluated to true for target ID %s, but no matching target found for proxy %s", targetID, p.ID)
-			}
-		}
-		log.Printf("No expressions evaluated to true for proxy %s", p.ID)
+// exprVars records the environment variables an expression refers to, so that
+// only those are extracted from the request
+type exprVars map[string]bool
+
+func (v exprVars) Visit(node *ast.Node) {
+	if n, ok := (*node).(*ast.IdentifierNode); ok {
+		v[n.Value] = true
 	}
+}
 
-	// Fall back to default target
-	defaultTarget := p.getTargetById(p.Config.Condition.Default)
-	if defaultTarget == nil {
-		log.Printf("Default target %s not found for proxy %s", p.Config.Condition.Default, p.ID)
+func compileExpr(expression string, kind reflect.Kind, vars exprVars) (*vm.Program, error) {
+	program, err := expr.Compile(expression,
+		expr.Env(exprEnv{}),
+		expr.AsKind(kind),
+		expr.Patch(vars),
+	)
+	if err != nil {
+		var fileErr *file.Error
+		if errors.As(err, &fileErr) {
+			return nil, &ExprError{Line: fileErr.Line, Column: fileErr.Column + 1, Message: fileErr.Message}
+		}
+		// Result type mismatch, reported for the whole expression
+		return nil, &ExprError{Line: 1, Column: 1, Message: err.Error()}
 	}
-	return defaultTarget
+	return program, nil
+}
+
+// ValidateTargetExpr checks that an expression compiles and returns a target ID
+func ValidateTargetExpr(expression string) error {
+	_, err := compileExpr(expression, reflect.String, exprVars{})
+	return err
+}
+
+// ValidateMatchExpr checks that an expression compiles and returns a boolean
+func ValidateMatchExpr(expression string) error {
+	_, err := compileExpr(expression, reflect.Bool, exprVars{})
+	return err
 }
 
 // Helper functions to convert request data to maps for expressions
 func headersToMap(headers http.Header) map[string]string {
-	result := make(map[string]string)
+	result := make(map[string]string, len(headers))
 	for name, values := range headers {
 		if len(values) > 0 {
 			result[strings.ToLower(name)] = values[0]
@@ -141,7 +123,7 @@ func headersToMap(headers http.Header) map[string]string {
 }
 
 func queryToMap(query url.Values) map[string]string {
-	result := make(map[string]string)
+	result := make(map[string]string, len(query))
 	for name, values := range query {
 		if len(values) > 0 {
 			result[name] = values[0]
@@ -158,8 +140,44 @@ func cookiesToMap(r *http.Request) map[string]string {
 	return result
 }
 
-// createExpressionEnv creates an environment map for expression evaluation
-// with all available request data
+// randomInRange returns a random number in [min, max]
+func randomInRange(min, max int) int {
+	if min > max {
+		min, max = max, min // Swap if min > max
+	}
+	return min + rand.Intn(max-min+1)
+}
+
+// stableInRange returns a number in [min, max] that only depends on the value
+func stableInRange(value string, min, max int) int {
+	if min > max {
+		min, max = max, min // Swap if min > max
+	}
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return min + int(h.Sum64()%uint64(max-min+1))
+}
+
+func (env *exprEnv) randomUser(min, max int) int {
+	return stableInRange(env.IP, min, max)
+}
+
+func (env *exprEnv) randomCookie(name string, min, max int) int {
+	if value := env.Cookies[name]; value != "" {
+		return stableInRange(value, min, max)
+	}
+	return env.randomUser(min, max)
+}
+
+func (env *exprEnv) randomParam(value string, min, max int) int {
+	if value != "" {
+		return stableInRange(value, min, max)
+	}
+	return env.randomUser(min, max)
+}
+
+// createExpressionEnv creates the environment for expression evaluation. Only
+// the request maps referenced by the compiled expressions are built.
 //
 // Example expressions:
 //
@@ -172,8 +190,8 @@ func cookiesToMap(r *http.Request) map[string]string {
 //  3. Route based on cookie:
 //     "cookies['user_type'] == 'premium' ? 'premium-target' : 'free-target'"
 //
-//  4. Route based on country (using X-Country header):
-//     "headers['x-country'] in ['US', 'CA'] ? 'na-target' : 'global-target'"
+//  4. Route based on country (resolved with the GeoIP database):
+//     "country in ['US', 'CA'] ? 'na-target' : 'global-target'"
 //
 //  5. Route based on multiple conditions:
 //     "headers['user-agent'] contains 'iPhone' && query['version'] == '2' ? 'iphone-v2-target' : 'default-target'"
@@ -201,101 +219,40 @@ func cookiesToMap(r *http.Request) map[string]string {
 //
 //  13. Parameter-based consistent traffic steering:
 //     "randomParam(query['user_id'], 1, 100) <= 70 ? 'a-target' : 'b-target'"
-func createExpressionEnv(r *http.Request) map[string]interface{} {
-	// Seed the random number generator with the current time
-	rand.Seed(time.Now().UnixNano())
-
-	// Get client IP for user-based random function
-	clientIP := getClientIP(r)
-
-	// Create a hash of the IP address for consistent random routing
-	ipHash := int64(0)
-	for _, c := range clientIP {
-		ipHash = ipHash*31 + int64(c)
+func createExpressionEnv(r *http.Request, vars exprVars, geo *geoip.DB) *exprEnv {
+	env := &exprEnv{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Host:     r.Host,
+		IP:       getClientIP(r),
+		Referer:  r.Referer(),
+		Protocol: r.Proto,
+		Random:   randomInRange,
 	}
-
-	// Create a separate random source for user-based randomization
-	userRand := rand.New(rand.NewSource(ipHash))
-
-	// Get cookies map for cookie-based randomization
-	cookiesMap := cookiesToMap(r)
-
-	// Get query parameters map
-	queryMap := queryToMap(r.URL.Query())
-
-	// Get headers map
-	headersMap := headersToMap(r.Header)
-
-	return map[string]interface{}{
-		"headers":  headersMap,
-		"query":    queryMap,
-		"cookies":  cookiesMap,
-		"method":   r.Method,
-		"path":     r.URL.Path,
-		"host":     r.Host,
-		"ip":       clientIP,
-		"referer":  r.Referer(),
-		"protocol": r.Proto,
-		"url":      r.URL.String(),
-		// Add random function for traffic steering (different result each time)
-		"random": func(min, max int) int {
-			if min > max {
-				min, max = max, min // Swap if min > max
-			}
-			return min + rand.Intn(max-min+1)
-		},
-		// Add user-based random function for consistent traffic steering
-		// (same user always gets same result)
-		"randomUser": func(min, max int) int {
-			if min > max {
-				min, max = max, min // Swap if min > max
-			}
-			return min + userRand.Intn(max-min+1)
-		},
-		// Add cookie-based random function for consistent traffic steering
-		// (same cookie value always gets same result)
-		"randomCookie": func(cookieName string, min, max int) int {
-			if min > max {
-				min, max = max, min // Swap if min > max
-			}
-
-			// If cookie exists, use its value to seed the random generator
-			if cookieValue, exists := cookiesMap[cookieName]; exists && cookieValue != "" {
-				// Create a hash of the cookie value
-				cookieHash := int64(0)
-				for _, c := range cookieValue {
-					cookieHash = cookieHash*31 + int64(c)
-				}
-
-				// Create a random source based on the cookie hash
-				cookieRand := rand.New(rand.NewSource(cookieHash))
-				return min + cookieRand.Intn(max-min+1)
-			}
-
-			// If cookie doesn't exist, fall back to user-based randomization
-			return min + userRand.Intn(max-min+1)
-		},
-		// Add parameter-based random function for consistent traffic steering
-		// (same parameter value always gets same result)
-		"randomParam": func(paramValue string, min, max int) int {
-			if min > max {
-				min, max = max, min // Swap if min > max
-			}
-
-			if paramValue != "" {
-				// Create a hash of the parameter value
-				paramHash := int64(0)
-				for _, c := range paramValue {
-					paramHash = paramHash*31 + int64(c)
-				}
-
-				// Create a random source based on the parameter hash
-				paramRand := rand.New(rand.NewSource(paramHash))
-				return min + paramRand.Intn(max-min+1)
-			}
-
-			// If parameter doesn't exist, fall back to user-based randomization
-			return min + userRand.Intn(max-min+1)
-		},
+	if vars["url"] {
+		env.URL = r.URL.String()
+	}
+	if vars["headers"] {
+		env.Headers = headersToMap(r.Header)
+	}
+	if vars["query"] {
+		env.Query = queryToMap(r.URL.Query())
+	}
+	if vars["cookies"] || vars["randomCookie"] {
+		env.Cookies = cookiesToMap(r)
+	}
+	if vars["country"] || vars["region"] || vars["city"] {
+		location := geo.LookupString(env.IP)
+		env.Country, env.Region, env.City = location.Country, location.Region, location.City
+	}
+	if vars["randomUser"] {
+		env.RandomUser = env.randomUser
+	}
+	if vars["randomCookie"] {
+		env.RandomCookie = env.randomCookie
+	}
+	if vars["randomParam"] {
+		env.RandomParam = env.randomParam
 	}
+	return env
 }
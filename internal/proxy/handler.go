@@ -4,13 +4,25 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"time"
+
+	"github.com/ab-testing-service/internal/models"
 )
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
+	// Refuse requests that went through this proxy already, before they count
+	hops := p.readHops(r)
+	if reason := p.detectLoop(hops); reason != "" {
+		p.refuseLoop(w, hops, reason)
+		return
+	}
+
+	if p.serveLifecycle(w, r, hops) {
+		return
+	}
+
 	redirectInfo, err := p.getOrCreateRedirectInfo(r)
 	if err != nil {
 		http.Error(w, "Failed to process redirect info", http.StatusInternalServerError)
@@ -18,7 +30,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	target, err := p.selectTarget(r)
+	target, rule, err := p.selectTarget(r, redirectInfo)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to select target: %s", err), http.StatusInternalServerError)
 		p.stats.IncrementErrors(p.ID, redirectInfo.RUID)
@@ -26,22 +38,47 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Selected target: %s", target.URL)
 
-	// Check if this is a redirect request from another proxy
-	if r.Header.Get("X-Internal-Redirect") == "true" {
-		// todo prevent redirect loops
-		return
-	}
-
-	p.setCookies(w, redirectInfo)
-
 	// Get user identifier (prefer X-User-ID header, fallback to IP)
 	userID := r.Header.Get("X-User-ID")
 	if userID == "" {
 		userID = redirectInfo.RUID
 	}
 
-	// Track request with user ID
-	p.stats.IncrementRequestsWithUser(target.ID, userID)
+	switch rule {
+	case RuleOverride:
+		// Forced requests are previews, not part of the experiment: the visitor
+		// keeps no variant and nothing is counted
+		p.setOverrideCookie(w, r)
+		overridesTotal.WithLabelValues(p.ID, target.URL).Inc()
+		userID = ""
+	case RuleExcluded:
+		// Excluded traffic never enters the statistics
+		userID = ""
+	case RuleNotEnrolled:
+		// Visitors not enrolled are counted apart from the experiment targets and
+		// keep no variant, so that they can be enrolled later. They keep their
+		// ruid, which they are allocated by unless bucketing says otherwise.
+		p.setIDCookies(w, redirectInfo)
+		p.stats.IncrementRequestsWithUser(NotEnrolledStatsID, userID)
+		notEnrolledRequests.WithLabelValues(p.ID).Inc()
+		userID = ""
+	default:
+		p.setCookies(w, redirectInfo, target)
+
+		// Track request with user ID
+		p.stats.IncrementRequestsWithUser(target.ID, userID)
+
+		// Remember the assignment so that conversions can be attributed to the target
+		p.runtime.Assignments.record(assignment{
+			proxyID:  p.ID,
+			ruid:     redirectInfo.RUID,
+			rrid:     redirectInfo.RRID,
+			targetID: target.ID,
+		})
+
+		// Log the exposure, joined against conversions and used to debug assignments
+		p.runtime.Exposures.emit(newExposure(p, r, redirectInfo, target, rule, userID))
+	}
 
 	defer func() {
 		duration := time.Since(start).Seconds()
@@ -49,28 +86,35 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		p.metrics.RequestsTotal.WithLabelValues(target.URL).Inc()
 	}()
 
-	// Check if the target URL has a different host
-	//targetURL := p.appendRedirectParams(target.URL, redirectInfo)
-	parsedTarget, err := url.Parse(target.URL)
-	if err != nil {
-		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+	p.send(w, r, target, redirectInfo, userID, hops)
+}
+
+// send redirects the visitor to the target or, in proxy mode, returns the
+// target's response under the listen URL. userID is empty for requests that
+// don't count in the stats.
+func (p *Proxy) send(w http.ResponseWriter, r *http.Request, target *Target, info *RedirectInfo, userID string,
+	hops []string) {
+	if p.Mode == models.ProxyModeProxy {
+		p.forward(w, r, target, info, userID, hops)
 		return
 	}
 
-	if parsedTarget.Scheme == "" {
-		parsedTarget.Scheme = "https"
+	// Fill in the target URL template and merge the request data in its query
+	location, err := expandTarget(target, r, info)
+	if err != nil {
+		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+		return
 	}
+	p.appendRedirectParams(location, info)
 
-	// If target host is different from current host, do external redirect
-	if parsedTarget.Host != r.Host {
-		log.Printf("Redirecting to %s", parsedTarget.String())
-		http.Redirect(w, r, parsedTarget.String(), http.StatusMovedPermanently)
-		return
+	// Targets on the same host are redirected to by path, loops through the
+	// proxy itself are caught by the hop marker
+	if location.Host == "" || location.Host == r.Host {
+		location.Scheme, location.Host = "", ""
+	} else if location.Scheme == "" {
+		location.Scheme = "https"
 	}
 
-	// For same host, do internal redirect
-	r.URL.Path = parsedTarget.Path
-	r.URL.RawQuery = parsedTarget.RawQuery
-	r.Header.Set("X-Internal-Redirect", "true")
-	http.Redirect(w, r, r.URL.String(), http.StatusTemporaryRedirect)
+	log.Printf("Redirecting to %s", location.String())
+	p.redirect(w, r, location, hops)
 }
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// Health check defaults, used for zero settings
+const (
+	defaultHealthCheckMethod             = http.MethodGet
+	defaultHealthCheckPath               = "/"
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckHealthyThreshold   = 2
+	defaultHealthCheckUnhealthyThreshold = 3
+)
+
+var targetHealthy = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ab_test_target_healthy",
+		Help: "Whether the target passes its health checks (1) or not (0)",
+	},
+	[]string{"proxy_id", "target"},
+)
+
+// HealthCheck is the resolved health check configuration of a proxy
+type HealthCheck struct {
+	Method             string
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	ExpectedStatus     int // any 2xx or 3xx when zero
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// NewHealthCheck applies the defaults to the health check settings, it returns
+// nil when health checks are disabled
+func NewHealthCheck(settings *models.HealthCheckSettings) *HealthCheck {
+	if settings == nil || !settings.Enabled {
+		return nil
+	}
+
+	hc := &HealthCheck{
+		Method:             settings.Method,
+		Path:               settings.Path,
+		Interval:           time.Duration(settings.Interval) * time.Second,
+		Timeout:            time.Duration(settings.Timeout) * time.Second,
+		ExpectedStatus:     settings.ExpectedStatus,
+		HealthyThreshold:   settings.HealthyThreshold,
+		UnhealthyThreshold: settings.UnhealthyThreshold,
+	}
+	if hc.Method == "" {
+		hc.Method = defaultHealthCheckMethod
+	}
+	if hc.Path == "" {
+		hc.Path = defaultHealthCheckPath
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = defaultHealthCheckInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = defaultHealthCheckTimeout
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	return hc
+}
+
+// URL returns the health check URL of a target: the path on the target host
+func (hc *HealthCheck) URL(target Target) (string, error) {
+	u, err := url.Parse(staticURL(target.URL))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("target URL %q is not absolute", target.URL)
+	}
+
+	check, err := url.Parse(hc.Path)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(check).String(), nil
+}
+
+// Check sends one health check request to the target. Redirects are not
+// followed, a 3xx status is the target's answer.
+func (hc *HealthCheck) Check(ctx context.Context, client *http.Client, target Target) error {
+	checkURL, err := hc.URL(target)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, hc.Method, checkURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "ab-testing-service-health-check")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	if hc.ExpectedStatus != 0 {
+		if resp.StatusCode != hc.ExpectedStatus {
+			return fmt.Errorf("unexpected status %d, expected %d", resp.StatusCode, hc.ExpectedStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// NewHealthCheckClient returns the client health checks are sent with
+func NewHealthCheckClient() *http.Client {
+	return &http.Client{
+		Transport: newUpstreamTransport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// TargetHealth is the health state of a target
+type TargetHealth struct {
+	TargetID             string    `json:"target_id"`
+	URL                  string    `json:"url"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	LastCheck            time.Time `json:"last_check"`
+	LastError            string    `json:"last_error,omitempty"`
+	Since                time.Time `json:"since"` // time of the last transition
+}
+
+// HealthRegistry holds the health of the checked targets by target ID. Targets
+// that are not checked are healthy.
+type HealthRegistry struct {
+	mutex   sync.RWMutex
+	targets map[string]*TargetHealth
+}
+
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{targets: make(map[string]*TargetHealth)}
+}
+
+// IsHealthy reports whether a target may receive traffic
+func (hr *HealthRegistry) IsHealthy(targetID string) bool {
+	if hr == nil {
+		return true
+	}
+
+	hr.mutex.RLock()
+	defer hr.mutex.RUnlock()
+	state, ok := hr.targets[targetID]
+	return !ok || state.Healthy
+}
+
+// Get returns a copy of the health of a target, nil when it is not checked
+func (hr *HealthRegistry) Get(targetID string) *TargetHealth {
+	if hr == nil {
+		return nil
+	}
+
+	hr.mutex.RLock()
+	defer hr.mutex.RUnlock()
+	state, ok := hr.targets[targetID]
+	if !ok {
+		return nil
+	}
+	copied := *state
+	return &copied
+}
+
+// Report records the result of a health check of a target and returns its new
+// state and whether it went from healthy to unhealthy or back. A target starts
+// healthy, it fails after UnhealthyThreshold consecutive failures and recovers
+// after HealthyThreshold consecutive successes.
+func (hr *HealthRegistry) Report(proxyID string, target Target, hc *HealthCheck, checkErr error) (TargetHealth, bool) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	now := time.Now()
+	state, ok := hr.targets[target.ID]
+	if !ok {
+		state = &TargetHealth{TargetID: target.ID, Healthy: true, Since: now}
+		hr.targets[target.ID] = state
+	}
+	state.URL = target.URL
+	state.LastCheck = now
+
+	if checkErr != nil {
+		state.ConsecutiveSuccesses = 0
+		state.ConsecutiveFailures++
+		state.LastError = checkErr.Error()
+	} else {
+		state.ConsecutiveFailures = 0
+		state.ConsecutiveSuccesses++
+		state.LastError = ""
+	}
+
+	changed := false
+	switch {
+	case state.Healthy && state.ConsecutiveFailures >= hc.UnhealthyThreshold:
+		state.Healthy, state.Since, changed = false, now, true
+	case !state.Healthy && state.ConsecutiveSuccesses >= hc.HealthyThreshold:
+		state.Healthy, state.Since, changed = true, now, true
+	}
+
+	gauge := targetHealthy.WithLabelValues(proxyID, target.URL)
+	if state.Healthy {
+		gauge.Set(1)
+	} else {
+		gauge.Set(0)
+	}
+
+	return *state, changed
+}
+
+// Forget drops the state of a target that is no longer checked, making it
+// healthy again
+func (hr *HealthRegistry) Forget(proxyID string, target Target) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	delete(hr.targets, target.ID)
+	targetHealthy.DeleteLabelValues(proxyID, target.URL)
+}
+
+// Health returns the health of the proxy targets, nil entries are not checked
+func (p *Proxy) Health() map[string]*TargetHealth {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	health := make(map[string]*TargetHealth, len(p.Targets))
+	for _, target := range p.Targets {
+		health[target.ID] = p.runtime.Health.Get(target.ID)
+	}
+	return health
+}
+
+// isAvailable reports whether a target may receive traffic: it is active and
+// passes its health checks
+func (p *Proxy) isAvailable(target Target) bool {
+	return target.IsActive && p.runtime.Health.IsHealthy(target.ID)
+}
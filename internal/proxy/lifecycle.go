@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+var ErrNotRunning = errors.New("proxy is not running")
+
+// State returns the lifecycle state of the proxy
+func (p *Proxy) State() models.ProxyState {
+	return p.Config.State
+}
+
+// countsStats reports whether requests and conversions count in the proxy
+// statistics: only running proxies run an experiment
+func (p *Proxy) countsStats() bool {
+	return p.Config.State == models.ProxyStateRunning
+}
+
+// countError counts a failed request of a running proxy, requests without user
+// are not counted
+func (p *Proxy) countError(targetID, userID string) {
+	if userID != "" && p.countsStats() {
+		p.stats.IncrementErrors(targetID, userID)
+	}
+}
+
+// serveLifecycle answers the requests of proxies that are not running and
+// reports whether it did. Archived proxies serve no traffic, drafts only serve
+// override tokens. Paused and completed proxies send everyone to a single
+// target without setting cookies or counting the request.
+func (p *Proxy) serveLifecycle(w http.ResponseWriter, r *http.Request, hops []string) bool {
+	switch p.Config.State {
+	case models.ProxyStateRunning:
+		return false
+	case models.ProxyStateArchived:
+		http.Error(w, "proxy is archived", http.StatusGone)
+		return true
+	}
+
+	// Other states can be previewed: overrides are served like on a running
+	// proxy and count in no stats either
+	if p.hasOverride(r) {
+		return false
+	}
+	if p.Config.State == models.ProxyStateDraft {
+		http.Error(w, "proxy is not started", http.StatusServiceUnavailable)
+		return true
+	}
+
+	target := p.fixedTarget()
+	if target == nil {
+		http.Error(w, "proxy has no targets", http.StatusServiceUnavailable)
+		return true
+	}
+
+	start := time.Now()
+	defer func() {
+		p.metrics.LatencyHistogram.WithLabelValues(target.URL).Observe(time.Since(start).Seconds())
+		p.metrics.RequestsTotal.WithLabelValues(target.URL).Inc()
+	}()
+
+	info, err := p.getOrCreateRedirectInfo(r)
+	if err != nil {
+		http.Error(w, "Failed to process redirect info", http.StatusInternalServerError)
+		return true
+	}
+	p.send(w, r, target, info, "", hops)
+	return true
+}
+
+// fixedTarget returns the target of a paused or completed proxy. When it was
+// removed since the transition, the control target takes over.
+func (p *Proxy) fixedTarget() *Target {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.targetOrControl(p.Config.Settings.Lifecycle.FixedTarget(p.Config.State))
+}
+
+// targetOrControl returns the target with the given ID, whether it is active
+// or not, then the control target, then the first target. Must be called with
+// p.mutex held.
+func (p *Proxy) targetOrControl(id string) *Target {
+	if len(p.Targets) == 0 {
+		return nil
+	}
+	for _, id := range []string{id, p.Config.Settings.ControlTargetID} {
+		for i := range p.Targets {
+			if id != "" && p.Targets[i].ID == id {
+				return &p.Targets[i]
+			}
+		}
+	}
+	return &p.Targets[0]
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// CatchAllHost is the listen host of the proxy serving the hosts no other
+// proxy listens on
+const CatchAllHost = "*"
+
+// ListenAddress is a listen URL split in its parts: [scheme://]host[:port][/path].
+// Empty parts match everything: a listen URL without scheme serves http and
+// https, without port every port and without path every path.
+type ListenAddress struct {
+	Scheme string `json:"scheme,omitempty"` // "http" or "https"
+	Host   string `json:"host"`             // lower case, "*.example.com" for subdomains, "*" for every host
+	Port   string `json:"port,omitempty"`
+	Path   string `json:"path,omitempty"` // prefix on segment boundaries, e.g. "/shop"
+}
+
+// ParseListenURL parses a listen URL, e.g. "promo.example.com",
+// "https://*.promo.example.com:8443/shop" or "*"
+func ParseListenURL(listenURL string) (ListenAddress, error) {
+	raw := strings.TrimSpace(listenURL)
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ListenAddress{}, fmt.Errorf("invalid listen URL %s: %w", listenURL, err)
+	}
+
+	addr := ListenAddress{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   strings.TrimSuffix(strings.ToLower(u.Hostname()), "."),
+		Port:   u.Port(),
+	}
+	if addr.Scheme != "" && addr.Scheme != "http" && addr.Scheme != "https" {
+		return ListenAddress{}, fmt.Errorf("invalid listen URL %s: scheme must be http or https", listenURL)
+	}
+	if err := validateListenHost(addr.Host); err != nil {
+		return ListenAddress{}, fmt.Errorf("invalid listen URL %s: %w", listenURL, err)
+	}
+	if u.Path != "" {
+		if strings.Contains(u.Path, "..") {
+			return ListenAddress{}, fmt.Errorf("invalid listen URL %s: invalid path", listenURL)
+		}
+		if p := path.Clean("/" + u.Path); p != "/" {
+			addr.Path = p
+		}
+	}
+	return addr, nil
+}
+
+func validateListenHost(host string) error {
+	switch {
+	case host == "":
+		return errors.New("host is empty")
+	case host == CatchAllHost:
+		return nil
+	case strings.HasPrefix(host, "*."):
+		host = strings.TrimPrefix(host, "*.")
+	}
+	if strings.Contains(host, "*") {
+		return errors.New("wildcards are only allowed as the first label, e.g. *.example.com")
+	}
+	if strings.HasPrefix(host, ".") || strings.Contains(host, "..") {
+		return errors.New("invalid host")
+	}
+	return nil
+}
+
+// String returns the listen URL of the address
+func (a ListenAddress) String() string {
+	var sb strings.Builder
+	if a.Scheme != "" {
+		sb.WriteString(a.Scheme + "://")
+	}
+	if a.Port != "" {
+		sb.WriteString(net.JoinHostPort(a.Host, a.Port))
+	} else if strings.Contains(a.Host, ":") {
+		sb.WriteString("[" + a.Host + "]") // IPv6
+	} else {
+		sb.WriteString(a.Host)
+	}
+	sb.WriteString(a.Path)
+	return sb.String()
+}
+
+// HostPatterns returns the listen hosts matching a request host, from the most
+// specific to the catch-all: "a.b.example.com", "*.b.example.com",
+// "*.example.com", "*.com", "*"
+func HostPatterns(host string) []string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	patterns := []string{host}
+	if net.ParseIP(host) == nil {
+		for i := strings.Index(host, "."); i >= 0; i = strings.Index(host, ".") {
+			host = host[i+1:]
+			patterns = append(patterns, "*."+host)
+		}
+	}
+	return append(patterns, CatchAllHost)
+}
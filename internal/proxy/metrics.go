@@ -7,72 +7,88 @@ import (
 
 type Metrics struct {
 	RequestsTotal       *prometheus.CounterVec
-	LatencyHistogram    *prometheus.HistogramVec
+	LatencyHistogram    prometheus.ObserverVec
 	BytesSentTotal      *prometheus.CounterVec
 	BytesReceivedTotal  *prometheus.CounterVec
 	ResponseStatusTotal *prometheus.CounterVec
 	ActiveConnections   *prometheus.GaugeVec
 	RequestErrors       *prometheus.CounterVec
+	ConversionsTotal    *prometheus.CounterVec
 }
 
+// Collectors are registered once and shared by all proxies. A proxy is rebuilt
+// on every config change, registering its own collectors again would panic.
+var (
+	requestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_requests_total",
+			Help: "Total number of requests per target",
+		},
+		[]string{"proxy_id", "target"},
+	)
+	latencyHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ab_test_request_duration_seconds",
+			Help:    "Request duration in seconds",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"proxy_id", "target"},
+	)
+	bytesSentTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_bytes_sent_total",
+			Help: "Total number of bytes sent to clients",
+		},
+		[]string{"proxy_id", "target"},
+	)
+	bytesReceivedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_bytes_received_total",
+			Help: "Total number of bytes received from targets",
+		},
+		[]string{"proxy_id", "target"},
+	)
+	responseStatusTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_response_status_total",
+			Help: "Total number of responses by status code",
+		},
+		[]string{"proxy_id", "target", "status"},
+	)
+	activeConnections = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ab_test_active_connections",
+			Help: "Number of active connections",
+		},
+		[]string{"proxy_id", "target"},
+	)
+	requestErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_request_errors_total",
+			Help: "Total number of request errors",
+		},
+		[]string{"proxy_id", "target", "error_type"},
+	)
+	conversionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_conversions_total",
+			Help: "Total number of conversions attributed to targets",
+		},
+		[]string{"proxy_id", "target", "goal"},
+	)
+)
+
 func newProxyMetrics(proxyID string) *Metrics {
+	labels := prometheus.Labels{"proxy_id": proxyID}
+
 	return &Metrics{
-		RequestsTotal: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        "ab_test_requests_total",
-				Help:        "Total number of requests per target",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target"},
-		),
-		LatencyHistogram: promauto.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:        "ab_test_request_duration_seconds",
-				Help:        "Request duration in seconds",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-				Buckets:     []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
-			},
-			[]string{"target"},
-		),
-		BytesSentTotal: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        "ab_test_bytes_sent_total",
-				Help:        "Total number of bytes sent to clients",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target"},
-		),
-		BytesReceivedTotal: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        "ab_test_bytes_received_total",
-				Help:        "Total number of bytes received from targets",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target"},
-		),
-		ResponseStatusTotal: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        "ab_test_response_status_total",
-				Help:        "Total number of responses by status code",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target", "status"},
-		),
-		ActiveConnections: promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name:        "ab_test_active_connections",
-				Help:        "Number of active connections",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target"},
-		),
-		RequestErrors: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name:        "ab_test_request_errors_total",
-				Help:        "Total number of request errors",
-				ConstLabels: prometheus.Labels{"proxy_id": proxyID},
-			},
-			[]string{"target", "error_type"},
-		),
+		RequestsTotal:       requestsTotal.MustCurryWith(labels),
+		LatencyHistogram:    latencyHistogram.MustCurryWith(labels),
+		BytesSentTotal:      bytesSentTotal.MustCurryWith(labels),
+		BytesReceivedTotal:  bytesReceivedTotal.MustCurryWith(labels),
+		ResponseStatusTotal: responseStatusTotal.MustCurryWith(labels),
+		ActiveConnections:   activeConnections.MustCurryWith(labels),
+		RequestErrors:       requestErrors.MustCurryWith(labels),
+		ConversionsTotal:    conversionsTotal.MustCurryWith(labels),
 	}
 }
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// OverrideParam is the query parameter carrying an override token
+	OverrideParam = "ab_force"
+	// OverrideHeader is the request header carrying an override token
+	OverrideHeader = "X-Ab-Force"
+	// MaxOverrideTTL bounds the lifetime of override tokens
+	MaxOverrideTTL = 7 * 24 * time.Hour
+
+	// RuleOverride is reported for targets forced by an override token
+	RuleOverride = "override"
+)
+
+var overridesTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ab_test_overrides_total",
+		Help: "Total number of requests forced to a target by an override token",
+	},
+	[]string{"proxy_id", "target"},
+)
+
+// OverrideToken returns a token forcing the target of the proxy until
+// expiresAt: target ID, expiry and signature separated by dots
+func (p *Proxy) OverrideToken(targetID string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return targetID + "." + expiry + "." + p.runtime.sign("override", p.ID, targetID, expiry)
+}
+
+// overrideCookieName returns the cookie keeping an override for the following
+// requests, named after the proxy so that proxies sharing a domain don't clash
+func (p *Proxy) overrideCookieName() string {
+	return "ab_force_" + p.ID
+}
+
+// readOverride returns the override token of the request, from the query
+// parameter, the header or the cookie, in that order
+func (p *Proxy) readOverride(r *http.Request) (token string, fromCookie bool) {
+	if token := r.URL.Query().Get(OverrideParam); token != "" {
+		return token, false
+	}
+	if token := r.Header.Get(OverrideHeader); token != "" {
+		return token, false
+	}
+	if cookie, err := r.Cookie(p.overrideCookieName()); err == nil {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// parseOverride checks the signature and expiry of an override token and
+// returns the forced target ID
+func (p *Proxy) parseOverride(token string, now time.Time) (string, time.Time, bool) {
+	rest, signature, ok := cutLast(token, ".")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	targetID, expiry, ok := cutLast(rest, ".")
+	if !ok || !p.runtime.verify(signature, "override", p.ID, targetID, expiry) {
+		return "", time.Time{}, false
+	}
+
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	expiresAt := time.Unix(unix, 0)
+	if !now.Before(expiresAt) {
+		return "", time.Time{}, false
+	}
+	return targetID, expiresAt, true
+}
+
+// getOverrideTarget returns the target forced by a valid override token.
+// Inactive and unhealthy targets can be forced, so that they can be previewed
+// before they get traffic. Must be called with p.mutex held.
+func (p *Proxy) getOverrideTarget(r *http.Request) *Target {
+	token, _ := p.readOverride(r)
+	if token == "" {
+		return nil
+	}
+	targetID, _, ok := p.parseOverride(token, time.Now())
+	if !ok {
+		return nil
+	}
+	for i := range p.Targets {
+		if p.Targets[i].ID == targetID {
+			return &p.Targets[i]
+		}
+	}
+	return nil
+}
+
+// hasOverride reports whether the request forces a target of the proxy
+func (p *Proxy) hasOverride(r *http.Request) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.getOverrideTarget(r) != nil
+}
+
+// setOverrideCookie keeps the override of a query parameter or header for the
+// following requests, until the token expires
+func (p *Proxy) setOverrideCookie(w http.ResponseWriter, r *http.Request) {
+	token, fromCookie := p.readOverride(r)
+	if fromCookie {
+		return
+	}
+	_, expiresAt, ok := p.parseOverride(token, time.Now())
+	if !ok {
+		return
+	}
+
+	settings := p.cookieSettings()
+	http.SetCookie(w, &http.Cookie{
+		Name:     p.overrideCookieName(),
+		Value:    token,
+		Path:     "/",
+		Domain:   settings.Domain,
+		MaxAge:   int(time.Until(expiresAt).Seconds()) + 1,
+		Secure:   settings.Secure,
+		HttpOnly: true,
+		SameSite: sameSiteMode(settings.SameSite),
+	})
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
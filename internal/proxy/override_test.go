@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOverride(t *testing.T) {
+	now := time.Now()
+	p := testProxy("p")
+	otherProxy := testProxy("q")
+	otherKey := testProxy("p")
+	otherKey.runtime.SigningKey = []byte("other key")
+
+	expiresAt := now.Add(time.Hour).Truncate(time.Second)
+	valid := p.OverrideToken("t1", expiresAt)
+	signature := valid[strings.LastIndex(valid, ".")+1:]
+
+	tests := []struct {
+		name   string
+		token  string
+		target string // empty when the token is rejected
+	}{
+		{name: "valid", token: valid, target: "t1"},
+		{name: "target ID with dots", token: p.OverrideToken("v1.2", expiresAt), target: "v1.2"},
+		{name: "expired", token: p.OverrideToken("t1", now.Add(-time.Second))},
+		{name: "expiring now", token: p.OverrideToken("t1", now)},
+		{name: "tampered target", token: "t2" + valid[len("t1"):]},
+		{name: "extended expiry", token: "t1.9999999999." + signature},
+		{name: "other proxy", token: otherProxy.OverrideToken("t1", expiresAt)},
+		{name: "other key", token: otherKey.OverrideToken("t1", expiresAt)},
+		{name: "no signature", token: "t1.9999999999"},
+		{name: "no expiry", token: "t1"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, gotExpiresAt, ok := p.parseOverride(tt.token, now)
+			if ok != (tt.target != "") || target != tt.target {
+				t.Fatalf("parseOverride() = %q, %v, want %q", target, ok, tt.target)
+			}
+			if ok && !gotExpiresAt.Equal(expiresAt) {
+				t.Errorf("expires at %v, want %v", gotExpiresAt, expiresAt)
+			}
+		})
+	}
+}
+
+func TestReadOverride(t *testing.T) {
+	p := testProxy("p")
+	tests := []struct {
+		name       string
+		query      string
+		header     string
+		cookie     string
+		want       string
+		fromCookie bool
+	}{
+		{name: "none"},
+		{name: "query first", query: "q", header: "h", cookie: "c", want: "q"},
+		{name: "header before cookie", header: "h", cookie: "c", want: "h"},
+		{name: "cookie", cookie: "c", want: "c", fromCookie: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.query != "" {
+				r.URL.RawQuery = OverrideParam + "=" + tt.query
+			}
+			if tt.header != "" {
+				r.Header.Set(OverrideHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: p.overrideCookieName(), Value: tt.cookie})
+			}
+			// The cookie of another proxy is ignored
+			r.AddCookie(&http.Cookie{Name: "ab_force_q", Value: "other"})
+
+			token, fromCookie := p.readOverride(r)
+			if token != tt.want || fromCookie != tt.fromCookie {
+				t.Errorf("readOverride() = %q, %v, want %q, %v", token, fromCookie, tt.want, tt.fromCookie)
+			}
+		})
+	}
+}
@@ -17,16 +17,21 @@ type Target struct {
 }
 
 type Config struct {
-	ID                   string           `json:"id"`
-	Name                 string           `json:"name"`
-	ListenURLs           []ListenURL      `json:"listen_urls"`
-	Mode                 models.ProxyMode `json:"mode"`
-	Targets              []Target         `json:"targets"`
-	Condition            *Condition       `json:"condition"`
-	Tags                 []string         `json:"tags"`
-	SavingCookiesFlg     bool             `json:"saving_cookies_flg"`
-	QueryForwardingFlg   bool             `json:"query_forwarding_flg"`
-	CookiesForwardingFlg bool             `json:"cookies_forwarding_flg"`
+	ID                   string               `json:"id"`
+	Name                 string               `json:"name"`
+	ListenURLs           []ListenURL          `json:"listen_urls"`
+	Mode                 models.ProxyMode     `json:"mode"`
+	Targets              []Target             `json:"targets"`
+	Condition            *Condition           `json:"condition"`
+	Tags                 []string             `json:"tags"`
+	SavingCookiesFlg     bool                 `json:"saving_cookies_flg"`
+	QueryForwardingFlg   bool                 `json:"query_forwarding_flg"`
+	CookiesForwardingFlg bool                 `json:"cookies_forwarding_flg"`
+	Settings             models.ProxySettings `json:"settings"`
+	State                models.ProxyState    `json:"state"`
+	// Version is the config version in PostgreSQL, increased by every change
+	// of the proxy, its targets and listen URLs
+	Version int64 `json:"version"`
 }
 
 type ListenURL struct {
@@ -35,12 +40,15 @@ type ListenURL struct {
 	PathKey   *string `json:"path_key,omitempty"`
 }
 
+// Condition holds the targeting rules of a proxy, or a single condition saved
+// before rules, see models.RouteCondition
 type Condition struct {
-	Type      models.ConditionType `json:"type"`
-	ParamName string               `json:"param_name"`
-	Values    map[string]string    `json:"values"`
-	Default   string               `json:"default"`
-	Expr      string               `json:"expr,omitempty"`
+	Rules     []models.TargetingRule `json:"rules,omitempty"`
+	Type      models.ConditionType   `json:"type,omitempty"`
+	ParamName string                 `json:"param_name,omitempty"`
+	Values    map[string]string      `json:"values,omitempty"`
+	Default   string                 `json:"default,omitempty"`
+	Expr      string                 `json:"expr,omitempty"`
 }
 
 type RedirectInfo struct {
@@ -65,9 +73,13 @@ type Proxy struct {
 	metrics              *Metrics
 	cookieName           string
 	stats                *Stats
+	transport            *http.Transport // used to reach targets in proxy mode
+	runtime              *Runtime
+	rules                *compiledRules  // compiled targeting rules, nil when there are none
+	exclusion            *ExclusionRules // compiled proxy exclusion rules, nil when there are none
 }
 
-func NewProxy(cfg Config) (*Proxy, error) {
+func NewProxy(cfg Config, runtime *Runtime) (*Proxy, error) {
 	totalWeight, err := validate(cfg)
 	if err != nil {
 		return nil, err
@@ -80,6 +92,11 @@ func NewProxy(cfg Config) (*Proxy, error) {
 		}
 	}
 
+	// Configs saved before lifecycle states were introduced are live
+	if cfg.State == "" {
+		cfg.State = models.ProxyStateRunning
+	}
+
 	proxy := &Proxy{
 		ID:                   cfg.ID,
 		Name:                 cfg.Name,
@@ -91,8 +108,27 @@ func NewProxy(cfg Config) (*Proxy, error) {
 		QueryForwardingFlg:   cfg.QueryForwardingFlg,
 		CookiesForwardingFlg: cfg.CookiesForwardingFlg,
 		metrics:              newProxyMetrics(cfg.ID),
-		cookieName:           fmt.Sprintf("proxy_%s", cfg.ID),
 		stats:                NewProxyStats(cfg.ID),
+		runtime:              runtime,
+	}
+	proxy.cookieName = proxy.cookieSettings().Name
+
+	if cfg.Condition != nil {
+		proxy.rules, err = compileRules(cfg.Condition, cfg.Targets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Settings.Exclusion != nil {
+		proxy.exclusion, err = NewExclusionRules(cfg.Settings.Exclusion.ExclusionRules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Mode == models.ProxyModeProxy {
+		proxy.transport = newUpstreamTransport()
 	}
 
 	return proxy, nil
@@ -126,6 +162,12 @@ func (p *Proxy) UpdateTargets(targets []Target) {
 	p.mutex.Unlock()
 }
 
+// InheritStats takes over the statistics not yet collected from the proxy
+// being replaced, so that a config change does not lose them
+func (p *Proxy) InheritStats(previous *Proxy) {
+	p.stats = previous.stats
+}
+
 func (p *Proxy) GetStats() *Stats {
 	return p.stats
 }
@@ -14,33 +14,60 @@ const (
 	proxySettingsChannel = "proxy:settings:changes"
 )
 
+// Operation is the change of a proxy announced to the other instances
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
 type SettingsChangeMessage struct {
-	ProxyID   string `json:"proxy_id"`
-	SenderID  string `json:"sender_id"` // Unique ID of the service instance that sent the message
-	Operation string `json:"operation"` // e.g., "update", "delete"
+	ProxyID   string    `json:"proxy_id"`
+	SenderID  string    `json:"sender_id"` // Unique ID of the service instance that sent the message
+	Operation Operation `json:"operation"`
+	Version   int64     `json:"version"` // Config version of the change, zero for deletes
 }
 
 type RedisPubSub struct {
 	client     *redis.Client
 	instanceID string
-	onUpdate   func(ctx context.Context, proxyID string) error // Callback function for handling updates
+	onChange   func(ctx context.Context, msg SettingsChangeMessage) error // Callback function for handling changes
 }
 
-func NewRedisPubSub(redisClient *redis.Client, updateCallback func(ctx context.Context, proxyID string) error) *RedisPubSub {
+func NewRedisPubSub(redisClient *redis.Client, changeCallback func(ctx context.Context, msg SettingsChangeMessage) error) *RedisPubSub {
 	return &RedisPubSub{
 		client:     redisClient,
 		instanceID: uuid.New().String(),
-		onUpdate:   updateCallback,
+		onChange:   changeCallback,
 	}
 }
 
-// StartSubscriber starts listening for proxy settings changes
+// InstanceID returns the unique ID of this service instance
+func (ps *RedisPubSub) InstanceID() string {
+	return ps.instanceID
+}
+
+// StartSubscriber starts listening for proxy settings changes until ctx is
+// done. Messages lost while Redis is unreachable are caught up by the
+// reconciler of the supervisor.
 func (ps *RedisPubSub) StartSubscriber(ctx context.Context) error {
 	pubsub := ps.client.Subscribe(ctx, proxySettingsChannel)
-	defer pubsub.Close()
+
+	// Wait for the subscription, so that changes published from now on are received
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("error subscribing to %s: %w", proxySettingsChannel, err)
+	}
 
 	ch := pubsub.Channel()
 
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
 	go func() {
 		for msg := range ch {
 			var changeMsg SettingsChangeMessage
@@ -54,9 +81,9 @@ func (ps *RedisPubSub) StartSubscriber(ctx context.Context) error {
 				continue
 			}
 
-			// Call the update callback to handle the change
-			if err := ps.onUpdate(ctx, changeMsg.ProxyID); err != nil {
-				log.Printf("Error handling settings change for proxy %s: %v", changeMsg.ProxyID, err)
+			// Call the change callback to apply the change
+			if err := ps.onChange(ctx, changeMsg); err != nil {
+				log.Printf("Error handling %s of proxy %s: %v", changeMsg.Operation, changeMsg.ProxyID, err)
 			}
 		}
 	}()
@@ -64,12 +91,13 @@ func (ps *RedisPubSub) StartSubscriber(ctx context.Context) error {
 	return nil
 }
 
-// PublishSettingsChange notifies other instances about proxy settings changes
-func (ps *RedisPubSub) PublishSettingsChange(ctx context.Context, proxyID string) error {
+// PublishChange notifies other instances about a change of a proxy
+func (ps *RedisPubSub) PublishChange(ctx context.Context, op Operation, proxyID string, version int64) error {
 	msg := SettingsChangeMessage{
 		ProxyID:   proxyID,
 		SenderID:  ps.instanceID,
-		Operation: "update",
+		Operation: op,
+		Version:   version,
 	}
 
 	payload, err := json.Marshal(msg)
@@ -9,40 +9,80 @@ import (
 	"github.com/google/uuid"
 )
 
-func (p *Proxy) appendRedirectParams(targetURL string, info *RedirectInfo) string {
-	u, err := url.Parse(targetURL)
-	if err != nil {
-		return targetURL
-	}
+// internalParams are query parameters read by the proxy, never forwarded to
+// targets
+var internalParams = map[string]bool{
+	OverrideParam: true,
+	HopsParam:     true,
+}
 
+// appendRedirectParams merges the request data into the query of the target
+// location:
+//   - parameters of the target URL are kept and win over the request ones
+//   - rid, rrid and ruid are set, so that target pages can report conversions
+//   - with query forwarding, request parameters the target doesn't set are added
+//   - with cookies forwarding, request cookies are added as cookie_<name>
+//
+// Parameters and cookies used by the proxy itself are never forwarded.
+func (p *Proxy) appendRedirectParams(location *url.URL, info *RedirectInfo) {
 	// Get existing query parameters
-	query := u.Query()
+	query := location.Query()
 
 	// Add redirect info parameters
 	query.Set("rid", info.RID)
 	query.Set("rrid", info.RRID)
 	query.Set("ruid", info.RUID)
 
-	// Add all original query parameters if query forwarding is enabled
+	// Add the original query parameters the target doesn't set if query
+	// forwarding is enabled
 	if p.QueryForwardingFlg {
+		targetKeys := location.Query()
 		for key, values := range info.Query {
-			for _, value := range values {
-				query.Add(key, value)
+			if internalParams[key] || targetKeys.Has(key) || isRedirectInfoParam(key) {
+				continue
 			}
+			query[key] = append([]string(nil), values...)
 		}
 	}
 
 	// Add cookies as query parameters if cookies forwarding is enabled
 	if p.CookiesForwardingFlg {
 		for _, cookie := range info.Cookies {
+			if p.isProxyCookie(cookie.Name) {
+				continue
+			}
 			query.Add(fmt.Sprintf("cookie_%s", cookie.Name), cookie.Value)
 		}
 	}
 
 	// Set the updated query string
-	u.RawQuery = query.Encode()
+	location.RawQuery = query.Encode()
+}
+
+// stripInternalParams removes the parameters read by the proxy from a query
+// forwarded to a target
+func stripInternalParams(location *url.URL) {
+	query := location.Query()
+	stripped := false
+	for key := range internalParams {
+		if query.Has(key) {
+			query.Del(key)
+			stripped = true
+		}
+	}
+	if stripped {
+		location.RawQuery = query.Encode()
+	}
+}
+
+func isRedirectInfoParam(key string) bool {
+	return key == "rid" || key == "rrid" || key == "ruid"
+}
 
-	return u.String()
+// isProxyCookie reports whether the cookie is set by the proxy: identifiers,
+// sticky target and override
+func (p *Proxy) isProxyCookie(name string) bool {
+	return isRedirectInfoParam(name) || name == p.cookieName || name == p.overrideCookieName()
 }
 
 func (p *Proxy) getOrCreateRedirectInfo(r *http.Request) (*RedirectInfo, error) {
@@ -65,9 +105,10 @@ func (p *Proxy) getOrCreateRedirectInfo(r *http.Request) (*RedirectInfo, error)
 	query := r.URL.Query()
 
 	return &RedirectInfo{
-		RID:   rid,
-		RRID:  rrid,
-		RUID:  ruid,
-		Query: query,
+		RID:     rid,
+		RRID:    rrid,
+		RUID:    ruid,
+		Query:   query,
+		Cookies: r.Cookies(),
 	}, nil
 }
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAppendRedirectParams(t *testing.T) {
+	cookies := []*http.Cookie{
+		{Name: "session", Value: "s"},
+		{Name: "ruid", Value: "ru"},
+		{Name: "ab_sticky", Value: "t1.sig"},
+		{Name: "ab_force_p", Value: "token"},
+	}
+	query := url.Values{
+		"utm":      {"request"},
+		"a":        {"1"},
+		"tag":      {"x", "y"},
+		"ab_force": {"token"},
+		"ab_hops":  {"hops"},
+		"rid":      {"forged"},
+	}
+
+	tests := []struct {
+		name              string
+		target            string
+		queryForwarding   bool
+		cookiesForwarding bool
+		want              string
+	}{
+		{name: "no forwarding", target: "https://example.com/landing?utm=x",
+			want: "https://example.com/landing?rid=rid_p&rrid=rr&ruid=ru&utm=x"},
+		{name: "query forwarding", target: "https://example.com/landing?utm=x", queryForwarding: true,
+			want: "https://example.com/landing?a=1&rid=rid_p&rrid=rr&ruid=ru&tag=x&tag=y&utm=x"},
+		{name: "cookies forwarding", target: "https://example.com/landing", cookiesForwarding: true,
+			want: "https://example.com/landing?cookie_session=s&rid=rid_p&rrid=rr&ruid=ru"},
+		{name: "both", target: "https://example.com/", queryForwarding: true, cookiesForwarding: true,
+			want: "https://example.com/?a=1&cookie_session=s&rid=rid_p&rrid=rr&ruid=ru&tag=x&tag=y&utm=request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{
+				ID:                   "p",
+				QueryForwardingFlg:   tt.queryForwarding,
+				CookiesForwardingFlg: tt.cookiesForwarding,
+				cookieName:           "ab_sticky",
+			}
+			location, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info := &RedirectInfo{RID: "rid_p", RRID: "rr", RUID: "ru", Query: query, Cookies: cookies}
+
+			p.appendRedirectParams(location, info)
+			if location.String() != tt.want {
+				t.Errorf("location = %s, want %s", location, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripInternalParams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "b=2&a=1", want: "b=2&a=1"}, // untouched without internal parameters
+		{query: "a=1&ab_force=token&ab_hops=hops", want: "a=1"},
+		{query: "ab_hops=hops", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			location := &url.URL{Scheme: "https", Host: "example.com", RawQuery: tt.query}
+			stripInternalParams(location)
+			if location.RawQuery != tt.want {
+				t.Errorf("query = %s, want %s", location.RawQuery, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+const (
+	// HopsParam is the query parameter carrying the proxies a visitor was
+	// redirected through, signed so that it can't be forged
+	HopsParam = "ab_hops"
+	// HopsHeader carries the same marker on requests forwarded in proxy mode
+	HopsHeader = "X-Ab-Hops"
+
+	defaultRedirectStatus       = http.StatusFound
+	defaultRedirectCacheControl = "no-store"
+	defaultMaxHops              = 5
+
+	// hopsTTL is how long a hop marker is trusted. Redirect chains complete in
+	// seconds, an older marker was kept in a link or bookmark.
+	hopsTTL = time.Minute
+)
+
+var redirectLoops = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ab_test_redirect_loops_total",
+		Help: "Total number of requests refused because of a redirect loop",
+	},
+	[]string{"proxy_id", "reason"},
+)
+
+// redirectSettings returns the redirect settings with defaults applied
+func (p *Proxy) redirectSettings() models.RedirectSettings {
+	var settings models.RedirectSettings
+	if p.Config.Settings.Redirect != nil {
+		settings = *p.Config.Settings.Redirect
+	}
+	if settings.Status == 0 {
+		settings.Status = defaultRedirectStatus
+	}
+	if settings.CacheControl == "" {
+		settings.CacheControl = defaultRedirectCacheControl
+	}
+	if settings.MaxHops == 0 {
+		settings.MaxHops = defaultMaxHops
+	}
+	return settings
+}
+
+// IsValidRedirectStatus reports whether a status can be configured for redirects
+func IsValidRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// hopID is the short identifier of a proxy in hop markers
+func hopID(proxyID string) string {
+	sum := sha256.Sum256([]byte(proxyID))
+	return hex.EncodeToString(sum[:4])
+}
+
+// readHops returns the proxies the request was redirected or forwarded through,
+// from a valid and recent hop marker. Invalid or expired markers are ignored.
+//
+// A marker is "<hop>-<hop>-....<unix time>.<signature>".
+func (p *Proxy) readHops(r *http.Request) []string {
+	value := r.URL.Query().Get(HopsParam)
+	if value == "" {
+		value = r.Header.Get(HopsHeader)
+	}
+	if value == "" {
+		return nil
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || !p.runtime.verify(parts[2], "hops", parts[0], parts[1]) {
+		return nil
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > hopsTTL {
+		return nil
+	}
+	return strings.Split(parts[0], "-")
+}
+
+func (p *Proxy) hopsValue(hops []string) string {
+	chain := strings.Join(hops, "-")
+	issued := strconv.FormatInt(time.Now().Unix(), 10)
+	return chain + "." + issued + "." + p.runtime.sign("hops", chain, issued)
+}
+
+// detectLoop returns why the request is a redirect loop, or an empty string
+func (p *Proxy) detectLoop(hops []string) string {
+	own := hopID(p.ID)
+	for _, hop := range hops {
+		if hop == own {
+			return "revisit"
+		}
+	}
+	if len(hops) >= p.redirectSettings().MaxHops {
+		return "max_hops"
+	}
+	return ""
+}
+
+// refuseLoop answers a request caught in a redirect loop
+func (p *Proxy) refuseLoop(w http.ResponseWriter, hops []string, reason string) {
+	redirectLoops.WithLabelValues(p.ID, reason).Inc()
+
+	message := fmt.Sprintf("redirect loop detected: proxy %s was reached through %d redirects", p.ID, len(hops))
+	if reason == "revisit" {
+		message = fmt.Sprintf("redirect loop detected: proxy %s redirected the request to itself", p.ID)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, message, http.StatusLoopDetected)
+}
+
+// nextHops returns the marker passed on by the proxy
+func (p *Proxy) nextHops(hops []string) string {
+	return p.hopsValue(append(hops, hopID(p.ID)))
+}
+
+// redirect sends the visitor to the location with the configured status and
+// cache headers, adding the proxy to the hop marker
+func (p *Proxy) redirect(w http.ResponseWriter, r *http.Request, location *url.URL, hops []string) {
+	settings := p.redirectSettings()
+
+	query := location.Query()
+	query.Set(HopsParam, p.nextHops(hops))
+	location.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", settings.CacheControl)
+	http.Redirect(w, r, location.String(), settings.Status)
+}
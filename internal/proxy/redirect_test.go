@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+func testProxy(id string) *Proxy {
+	return &Proxy{ID: id, runtime: &Runtime{SigningKey: []byte("test key")}}
+}
+
+// hopsMarker returns a marker of the chain issued at the time, signed by p
+func hopsMarker(p *Proxy, chain string, issued time.Time) string {
+	unix := strconv.FormatInt(issued.Unix(), 10)
+	return chain + "." + unix + "." + p.runtime.sign("hops", chain, unix)
+}
+
+func TestReadHops(t *testing.T) {
+	p := testProxy("p")
+	other := testProxy("p")
+	other.runtime.SigningKey = []byte("other key")
+
+	valid := p.nextHops([]string{"aaaa"})
+	chain := "aaaa-" + hopID("p")
+
+	tests := []struct {
+		name   string
+		marker string
+		header bool
+		want   []string
+	}{
+		{name: "none"},
+		{name: "valid", marker: valid, want: []string{"aaaa", hopID("p")}},
+		{name: "header", marker: valid, header: true, want: []string{"aaaa", hopID("p")}},
+		{name: "recent", marker: hopsMarker(p, chain, time.Now().Add(-hopsTTL/2)), want: []string{"aaaa", hopID("p")}},
+		{name: "expired", marker: hopsMarker(p, chain, time.Now().Add(-2*hopsTTL))},
+		{name: "tampered chain", marker: "bbbb" + valid[len("aaaa"):]},
+		{name: "refreshed time", marker: chain + "." + strconv.FormatInt(time.Now().Unix(), 10) + "." +
+			p.runtime.sign("hops", chain, "0")},
+		{name: "other key", marker: other.nextHops([]string{"aaaa"})},
+		{name: "malformed", marker: "aaaa.123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header {
+				r.Header.Set(HopsHeader, tt.marker)
+			} else if tt.marker != "" {
+				r.URL.RawQuery = url.Values{HopsParam: {tt.marker}}.Encode()
+			}
+			if got := p.readHops(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readHops() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectLoop(t *testing.T) {
+	own := hopID("p")
+	tests := []struct {
+		name    string
+		maxHops int
+		hops    []string
+		want    string
+	}{
+		{name: "first visit"},
+		{name: "other proxies", hops: []string{"aaaa", "bbbb"}},
+		{name: "revisit", hops: []string{"aaaa", own}, want: "revisit"},
+		{name: "revisit over max_hops", maxHops: 1, hops: []string{own}, want: "revisit"},
+		{name: "default max_hops", hops: []string{"a", "b", "c", "d", "e"}, want: "max_hops"},
+		{name: "under default max_hops", hops: []string{"a", "b", "c", "d"}},
+		{name: "configured max_hops", maxHops: 2, hops: []string{"a", "b"}, want: "max_hops"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testProxy("p")
+			p.Config.Settings.Redirect = &models.RedirectSettings{MaxHops: tt.maxHops}
+			if got := p.detectLoop(tt.hops); got != tt.want {
+				t.Errorf("detectLoop() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextHopsLoop(t *testing.T) {
+	// A proxy redirecting to itself reads its own hop back
+	p := testProxy("p")
+	r := httptest.NewRequest("GET", "/?"+url.Values{HopsParam: {p.nextHops(nil)}}.Encode(), nil)
+	if got := p.detectLoop(p.readHops(r)); got != "revisit" {
+		t.Errorf("detectLoop() = %q, want revisit", got)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Timeouts for upstream connections made in proxy mode
+const (
+	upstreamDialTimeout           = 10 * time.Second
+	upstreamKeepAlive             = 30 * time.Second
+	upstreamTLSHandshakeTimeout   = 10 * time.Second
+	upstreamResponseHeaderTimeout = 30 * time.Second
+	upstreamIdleConnTimeout       = 90 * time.Second
+	upstreamMaxIdleConnsPerHost   = 32
+)
+
+// newUpstreamTransport creates the transport shared by all targets of a proxy
+// running in proxy mode
+func newUpstreamTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   upstreamDialTimeout,
+		KeepAlive: upstreamKeepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   upstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:       upstreamIdleConnTimeout,
+		TLSHandshakeTimeout:   upstreamTLSHandshakeTimeout,
+		ResponseHeaderTimeout: upstreamResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// forward streams the request to the target and copies the target's response
+// back to the client under the original listen URL.
+//
+// The outgoing request gets the target's Host header, X-Forwarded-For,
+// X-Forwarded-Host and X-Forwarded-Proto headers describing the original request,
+// and the hop marker used to detect loops through proxies.
+// Upgrade requests (websockets) are tunneled as is.
+//
+// The request path and query are appended to those of the target URL, unless
+// it is a template, see expandTarget. Parameters read by the proxy itself are
+// not forwarded.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, target *Target, info *RedirectInfo, userID string,
+	hops []string) {
+	targetURL, err := expandTarget(target, r, info)
+	if err != nil {
+		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+		p.countError(target.ID, userID)
+		return
+	}
+	if targetURL.Scheme == "" {
+		targetURL.Scheme = "https"
+	}
+	templated := IsTemplate(target.URL)
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if templated {
+				// The template sets the whole path, the request query fills in the
+				// parameters it doesn't set
+				pr.SetURL(&url.URL{Scheme: targetURL.Scheme, Host: targetURL.Host})
+				pr.Out.URL.Path, pr.Out.URL.RawPath = targetURL.Path, targetURL.RawPath
+				query := targetURL.Query()
+				for key, values := range pr.In.URL.Query() {
+					if !query.Has(key) {
+						query[key] = values
+					}
+				}
+				pr.Out.URL.RawQuery = query.Encode()
+			} else {
+				pr.SetURL(targetURL)
+			}
+			stripInternalParams(pr.Out.URL)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(HopsHeader, p.nextHops(hops))
+		},
+		Transport:     p.transport,
+		FlushInterval: -1, // flush immediately to support streaming responses
+		ModifyResponse: func(resp *http.Response) error {
+			p.metrics.ResponseStatusTotal.WithLabelValues(target.URL, strconv.Itoa(resp.StatusCode)).Inc()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				p.countError(target.ID, userID)
+			}
+			if resp.ContentLength > 0 {
+				p.metrics.BytesReceivedTotal.WithLabelValues(target.URL).Add(float64(resp.ContentLength))
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				// Client went away, nothing to answer
+				p.metrics.RequestErrors.WithLabelValues(target.URL, "canceled").Inc()
+				return
+			}
+
+			status := http.StatusBadGateway
+			errorType := "upstream"
+			var netErr net.Error
+			if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				status = http.StatusGatewayTimeout
+				errorType = "timeout"
+			}
+
+			log.Printf("Proxy %s failed to forward request to %s: %v", p.ID, target.URL, err)
+			p.metrics.RequestErrors.WithLabelValues(target.URL, errorType).Inc()
+			p.countError(target.ID, userID)
+
+			w.WriteHeader(status)
+		},
+	}
+
+	p.metrics.ActiveConnections.WithLabelValues(target.URL).Inc()
+	defer p.metrics.ActiveConnections.WithLabelValues(target.URL).Dec()
+
+	rp.ServeHTTP(w, r)
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/ab-testing-service/internal/geoip"
+)
+
+// Runtime holds the dependencies shared by all proxies of a service instance.
+// Proxies are rebuilt on every config change, the runtime outlives them.
+type Runtime struct {
+	// SigningKey is used to sign values handed out to clients, e.g. sticky cookies
+	SigningKey []byte
+	// Assignments records the target every visitor was sent to, for conversion attribution
+	Assignments *AssignmentStore
+	// Exposures ships an event for every visitor sent to a target
+	Exposures *ExposureLog
+	// Health holds the health of the targets checked by the supervisor
+	Health *HealthRegistry
+	// Exclusions are the global exclusion rules, nil when there are none
+	Exclusions *ExclusionRules
+	// Geo resolves client IPs for geo conditions, nil when no database is configured
+	Geo *geoip.DB
+}
+
+// sign returns a truncated HMAC-SHA256 of the parts, URL-safe base64 encoded
+func (rt *Runtime) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, rt.SigningKey)
+	mac.Write([]byte(strings.Join(parts, "|")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// verify checks a signature produced by sign in constant time
+func (rt *Runtime) verify(signature string, parts ...string) bool {
+	return hmac.Equal([]byte(signature), []byte(rt.sign(parts...)))
+}
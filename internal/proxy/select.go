@@ -2,140 +2,123 @@ package proxy
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
 	"net"
 	"net/http"
 	"strings"
 
+	"github.com/ab-testing-service/internal/geoip"
 	"github.com/ab-testing-service/internal/models"
 )
 
-func (p *Proxy) selectTarget(r *http.Request) (*Target, error) {
+// Rules reported with exposures, telling what selected the target
+const (
+	RuleStickyCookie = "sticky_cookie"
+	RuleWeighted     = "weighted"
+)
+
+// selectTarget returns the target for the request and the rule that selected it
+func (p *Proxy) selectTarget(r *http.Request, info *RedirectInfo) (*Target, string, error) {
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	// First, try to get target from cookie
-	if target := p.getTargetFromCookie(r); target != nil {
-		return target, nil
+	// QA and previews force a target with a signed override token
+	if target := p.getOverrideTarget(r); target != nil {
+		return target, RuleOverride, nil
 	}
 
-	// Then, check routing conditions if present
-	if p.Config.Condition != nil {
-		if target := p.getTargetByCondition(r); target != nil {
-			return target, nil
-		}
-		return nil, fmt.Errorf("no matching target found")
+	// Bots, monitors and internal visitors are kept out of the experiment
+	if target := p.getExcludedTarget(r); target != nil {
+		return target, RuleExcluded, nil
 	}
 
-	// Fall back to weighted random selection if no condition matches or no condition is set
-	var totalWeight float64
-	var activeTargets []Target
-
-	for _, target := range p.Targets {
-		if target.IsActive {
-			totalWeight += target.Weight
-			activeTargets = append(activeTargets, target)
-		}
+	// Visitors outside the traffic allocation see the default experience
+	if target := p.getNotEnrolledTarget(r, info); target != nil {
+		return target, RuleNotEnrolled, nil
 	}
 
-	if len(activeTargets) == 0 {
-		return nil, fmt.Errorf("no active targets available")
-	}
-
-	// Choose a random target based on weights
-	rnd := rand.Float64() * totalWeight
-	var cumulativeWeight float64
-
-	for _, target := range activeTargets {
-		cumulativeWeight += target.Weight
-		if rnd <= cumulativeWeight {
-			return &target, nil
-		}
+	// Then, try to get target from cookie
+	if target := p.getTargetFromCookie(r); target != nil {
+		return target, RuleStickyCookie, nil
 	}
 
-	// Fallback to the first active target if something goes wrong with the random selection
-	return &activeTargets[0], nil
-}
-
-func (p *Proxy) getTargetByCondition(r *http.Request) *Target {
-	var value string
-
-	if p.Config.Condition == nil {
-		log.Printf("No condition defined for proxy %s, using default target selection", p.ID)
-		// If no condition is defined, return nil to fall back to weighted random selection
-		return nil
+	// Then, the first matching targeting rule
+	if target, rule := p.getTargetByRules(r, info); target != nil {
+		return target, rule, nil
 	}
 
-	if p.Config.Condition.Type == models.ConditionTypeExpr {
-		// Use expr-lang/expr to evaluate the expression
-		log.Printf("Using expression-based condition for proxy %s", p.ID)
-		return p.getTargetByExpr(r)
+	// Fall back to weighted selection by the visitor's bucket when no rule matches
+	target := p.assign(p.unitID(r, info))
+	if target == nil {
+		return nil, "", fmt.Errorf("no active targets available")
 	}
+	return target, RuleWeighted, nil
+}
 
-	switch p.Config.Condition.Type {
+// requestValue returns the request attribute a predicate of the given type
+// compares to its values
+func (p *Proxy) requestValue(r *http.Request, conditionType models.ConditionType, paramName string) string {
+	switch conditionType {
 	case models.ConditionTypeHeader:
-		value = r.Header.Get(p.Config.Condition.ParamName)
-		log.Printf("Header condition: %s=%s for proxy %s", p.Config.Condition.ParamName, value, p.ID)
+		return r.Header.Get(paramName)
 	case models.ConditionTypeQuery:
-		value = r.URL.Query().Get(p.Config.Condition.ParamName)
-		log.Printf("Query condition: %s=%s for proxy %s", p.Config.Condition.ParamName, value, p.ID)
+		return r.URL.Query().Get(paramName)
 	case models.ConditionTypeCookie:
-		cookie, err := r.Cookie(p.Config.Condition.ParamName)
-		if err == nil {
-			value = cookie.Value
-			log.Printf("Cookie condition: %s=%s for proxy %s", p.Config.Condition.ParamName, value, p.ID)
-		} else {
-			log.Printf("Cookie %s not found for proxy %s", p.Config.Condition.ParamName, p.ID)
+		if cookie, err := r.Cookie(paramName); err == nil {
+			return cookie.Value
 		}
 	case models.ConditionTypeUserAgent:
-		ua := r.Header.Get("User-Agent")
-		switch p.Config.Condition.ParamName {
+		switch paramName {
 		case "platform":
-			value = detectPlatform(ua)
-			log.Printf("User-Agent platform condition: %s for proxy %s", value, p.ID)
+			return detectPlatform(r.UserAgent())
 		case "browser":
-			value = detectBrowser(ua)
-			log.Printf("User-Agent browser condition: %s for proxy %s", value, p.ID)
-		default:
-			log.Printf("Unknown User-Agent parameter: %s for proxy %s", p.Config.Condition.ParamName, p.ID)
+			return detectBrowser(r.UserAgent())
 		}
 	case models.ConditionTypeLanguage:
-		value = parseAcceptLanguage(r.Header.Get("Accept-Language"))
-		log.Printf("Language condition: %s for proxy %s", value, p.ID)
-	default:
-		log.Printf("Unknown condition type: %s for proxy %s, using default target", p.Config.Condition.Type, p.ID)
-		return p.getTargetById(p.Config.Condition.Default)
-	}
-
-	// Check if the value matches any of the specified values
-	if targetID, ok := p.Config.Condition.Values[value]; ok {
-		log.Printf("Condition value %s matched target %s for proxy %s", value, targetID, p.ID)
-		if target := p.getTargetById(targetID); target != nil {
-			return target
-		}
-		log.Printf("Target %s not found for proxy %s", targetID, p.ID)
-	} else {
-		log.Printf("No match for condition value %s in proxy %s, using default target", value, p.ID)
+		return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	case models.ConditionTypeGeo:
+		return geoValue(p.runtime.Geo.LookupString(getClientIP(r)), paramName)
 	}
-
-	// Fall back to default target
-	defaultTarget := p.getTargetById(p.Config.Condition.Default)
-	if defaultTarget == nil {
-		log.Printf("Default target %s not found for proxy %s", p.Config.Condition.Default, p.ID)
-	}
-	return defaultTarget
+	return ""
 }
 
 func (p *Proxy) getTargetById(id string) *Target {
 	for _, target := range p.Targets {
-		if target.ID == id && target.IsActive {
+		if target.ID == id && p.isAvailable(target) {
 			return &target
 		}
 	}
 	return nil
 }
 
+// Location fields geo conditions match on
+const (
+	GeoFieldCountry = "country" // ISO 3166-1 alpha-2 code, e.g. "US"
+	GeoFieldRegion  = "region"  // ISO 3166-2 code, e.g. "US-CA"
+	GeoFieldCity    = "city"    // English name, e.g. "Los Angeles"
+)
+
+// IsValidGeoField reports whether a geo condition can match on the field
+func IsValidGeoField(field string) bool {
+	switch field {
+	case GeoFieldCountry, GeoFieldRegion, GeoFieldCity:
+		return true
+	}
+	return false
+}
+
+// geoValue returns the location field a geo condition matches on
+func geoValue(location geoip.Location, field string) string {
+	switch field {
+	case GeoFieldCountry:
+		return location.Country
+	case GeoFieldRegion:
+		return location.Region
+	case GeoFieldCity:
+		return location.City
+	}
+	return ""
+}
+
 // detectPlatform detects the platform (mobile/desktop) from User-Agent
 func detectPlatform(ua string) string {
 	ua = strings.ToLower(ua)
@@ -4,13 +4,21 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"github.com/ab-testing-service/internal/hll"
 )
 
 type TargetStats struct {
 	RequestCount int64
 	ErrorCount   int64
 	LastUpdated  time.Time
-	UniqueUsers  map[string]struct{} // Track unique users by ID/IP
+	Users        *hll.Sketch                 // Distinct users by ID/IP
+	Conversions  map[string]*ConversionStats // key is goal ID
+}
+
+type ConversionStats struct {
+	Count   int64   `json:"count"`
+	Revenue float64 `json:"revenue"`
 }
 
 type Stats struct {
@@ -32,11 +40,11 @@ func (s *Stats) IncrementRequestsWithUser(targetID string, userID string) {
 
 	if _, exists := s.Targets[targetID]; !exists {
 		s.Targets[targetID] = &TargetStats{
-			UniqueUsers: make(map[string]struct{}),
+			Users: hll.New(),
 		}
 	}
 	s.Targets[targetID].RequestCount++
-	s.Targets[targetID].UniqueUsers[userID] = struct{}{}
+	s.Targets[targetID].Users.Add(userID)
 	s.Targets[targetID].LastUpdated = time.Now()
 	log.Printf("Request count for target %s: %d", targetID, s.Targets[targetID].RequestCount)
 }
@@ -47,14 +55,35 @@ func (s *Stats) IncrementErrors(targetID string, userID string) {
 
 	if _, exists := s.Targets[targetID]; !exists {
 		s.Targets[targetID] = &TargetStats{
-			UniqueUsers: make(map[string]struct{}),
+			Users: hll.New(),
 		}
 	}
 	s.Targets[targetID].ErrorCount++
-	s.Targets[targetID].UniqueUsers[userID] = struct{}{}
+	s.Targets[targetID].Users.Add(userID)
 	s.Targets[targetID].LastUpdated = time.Now()
 }
 
+func (s *Stats) IncrementConversions(targetID string, goalID string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.Targets[targetID]; !exists {
+		s.Targets[targetID] = &TargetStats{
+			Users: hll.New(),
+		}
+	}
+	target := s.Targets[targetID]
+	if target.Conversions == nil {
+		target.Conversions = make(map[string]*ConversionStats)
+	}
+	if _, exists := target.Conversions[goalID]; !exists {
+		target.Conversions[goalID] = &ConversionStats{}
+	}
+	target.Conversions[goalID].Count++
+	target.Conversions[goalID].Revenue += value
+	target.LastUpdated = time.Now()
+}
+
 func (s *Stats) GetStats() map[string]*TargetStats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -65,10 +94,14 @@ func (s *Stats) GetStats() map[string]*TargetStats {
 			RequestCount: target.RequestCount,
 			ErrorCount:   target.ErrorCount,
 			LastUpdated:  target.LastUpdated,
-			UniqueUsers:  make(map[string]struct{}),
+			Users:        target.Users.Clone(),
 		}
-		for user := range target.UniqueUsers {
-			stats[id].UniqueUsers[user] = struct{}{}
+		if len(target.Conversions) > 0 {
+			stats[id].Conversions = make(map[string]*ConversionStats, len(target.Conversions))
+			for goalID, conversions := range target.Conversions {
+				c := *conversions
+				stats[id].Conversions[goalID] = &c
+			}
 		}
 	}
 	//log.Printf("Stats for proxy %s: %v", s.ProxyID, stats)
@@ -80,3 +113,13 @@ func (s *Stats) Reset() {
 	defer s.mu.Unlock()
 	s.Targets = make(map[string]*TargetStats)
 }
+
+// Flush returns the statistics collected since the previous flush and resets
+// them, without losing the requests counted in between
+func (s *Stats) Flush() map[string]*TargetStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := s.Targets
+	s.Targets = make(map[string]*TargetStats)
+	return targets
+}
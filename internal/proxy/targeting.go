@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// compiledPredicate is a predicate with its expression compiled or its values
+// indexed
+type compiledPredicate struct {
+	models.Predicate
+	values  map[string]bool
+	program *vm.Program
+}
+
+// compiledRule is a targeting rule compiled once when the proxy is built
+type compiledRule struct {
+	label      string // reported with exposures
+	any        bool
+	predicates []compiledPredicate
+	targetID   string
+	split      []models.WeightedTarget
+	targetExpr *vm.Program
+}
+
+// compiledRules are the targeting rules of a proxy, vars are the environment
+// variables their expressions refer to
+type compiledRules struct {
+	rules []compiledRule
+	vars  exprVars
+}
+
+// targetingRules returns the rules of the condition, see models.RouteCondition
+func (c *Condition) targetingRules(targets []Target) []models.TargetingRule {
+	targetIDs := make([]string, len(targets))
+	for i, t := range targets {
+		targetIDs[i] = t.ID
+	}
+	rc := models.RouteCondition{
+		Rules:     c.Rules,
+		Type:      c.Type,
+		ParamName: c.ParamName,
+		Values:    c.Values,
+		Default:   c.Default,
+		Expr:      c.Expr,
+	}
+	return rc.TargetingRules(targetIDs)
+}
+
+// compileRules compiles the targeting rules of a condition, nil when there are
+// none
+func compileRules(cond *Condition, targets []Target) (*compiledRules, error) {
+	rules := cond.targetingRules(targets)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	compiled := &compiledRules{vars: exprVars{}}
+	for i, rule := range rules {
+		cr := compiledRule{
+			label:    "rule:" + strconv.Itoa(i+1),
+			any:      rule.Match == models.MatchAny,
+			targetID: rule.TargetID,
+			split:    rule.Split,
+		}
+		if rule.Name != "" {
+			cr.label = "rule:" + rule.Name
+		}
+
+		if rule.TargetExpr != "" {
+			program, err := compileExpr(rule.TargetExpr, reflect.String, compiled.vars)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target expression in rule %d: %w", i+1, err)
+			}
+			cr.targetExpr = program
+		}
+
+		for j, predicate := range rule.Predicates {
+			cp := compiledPredicate{Predicate: predicate}
+			if predicate.Type == models.ConditionTypeExpr {
+				program, err := compileExpr(predicate.Expr, reflect.Bool, compiled.vars)
+				if err != nil {
+					return nil, fmt.Errorf("invalid expression in rule %d, predicate %d: %w", i+1, j+1, err)
+				}
+				cp.program = program
+			} else {
+				cp.values = make(map[string]bool, len(predicate.Values))
+				for _, value := range predicate.Values {
+					cp.values[value] = true
+				}
+			}
+			cr.predicates = append(cr.predicates, cp)
+		}
+		compiled.rules = append(compiled.rules, cr)
+	}
+	return compiled, nil
+}
+
+// ValidateRules compiles the expressions of targeting rules, so that errors are
+// reported when the rules are saved rather than when the proxy is built
+func ValidateRules(rules []models.TargetingRule) error {
+	for i, rule := range rules {
+		if rule.TargetExpr != "" {
+			if err := ValidateTargetExpr(rule.TargetExpr); err != nil {
+				return fmt.Errorf("rules[%d]: invalid target_expr: %w", i, err)
+			}
+		}
+		for j, predicate := range rule.Predicates {
+			switch predicate.Type {
+			case models.ConditionTypeExpr:
+				if err := ValidateMatchExpr(predicate.Expr); err != nil {
+					return fmt.Errorf("rules[%d].predicates[%d]: invalid expr: %w", i, j, err)
+				}
+			case models.ConditionTypeGeo:
+				if !IsValidGeoField(predicate.ParamName) {
+					return fmt.Errorf("rules[%d].predicates[%d]: param_name must be country, region or city", i, j)
+				}
+			case models.ConditionTypeUserAgent:
+				if predicate.ParamName != "platform" && predicate.ParamName != "browser" {
+					return fmt.Errorf("rules[%d].predicates[%d]: param_name must be platform or browser", i, j)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getTargetByRules returns the target of the first rule matching the request
+// and the rule label. It returns nil when no rule matches, or when the target
+// of the matching rule is unavailable, so that weighted selection takes over.
+// Must be called with p.mutex held.
+func (p *Proxy) getTargetByRules(r *http.Request, info *RedirectInfo) (*Target, string) {
+	if p.rules == nil {
+		return nil, ""
+	}
+
+	// The expression environment is built on first use, requests matched by
+	// the other predicate types don't need it
+	var env *exprEnv
+	getEnv := func() *exprEnv {
+		if env == nil {
+			env = createExpressionEnv(r, p.rules.vars, p.runtime.Geo)
+		}
+		return env
+	}
+
+	for _, rule := range p.rules.rules {
+		if !p.ruleMatches(r, &rule, getEnv) {
+			continue
+		}
+
+		target := p.ruleTarget(r, info, &rule, getEnv)
+		if target == nil {
+			log.Printf("Target of %s is unavailable in proxy %s, falling back to weighted selection", rule.label, p.ID)
+			return nil, ""
+		}
+		return target, rule.label
+	}
+	return nil, ""
+}
+
+// ruleMatches combines the predicates of a rule, a rule without predicates
+// matches every request
+func (p *Proxy) ruleMatches(r *http.Request, rule *compiledRule, getEnv func() *exprEnv) bool {
+	for i := range rule.predicates {
+		matched := p.predicateMatches(r, &rule.predicates[i], getEnv)
+		if matched == rule.any {
+			// First match of OR or first mismatch of AND decides
+			return matched
+		}
+	}
+	return !rule.any || len(rule.predicates) == 0
+}
+
+func (p *Proxy) predicateMatches(r *http.Request, predicate *compiledPredicate, getEnv func() *exprEnv) bool {
+	if predicate.program != nil {
+		result, err := expr.Run(predicate.program, getEnv())
+		if err != nil {
+			log.Printf("Error evaluating expression %q in proxy %s: %v", predicate.Expr, p.ID, err)
+			return false
+		}
+		matched, _ := result.(bool)
+		return matched
+	}
+	return predicate.values[p.requestValue(r, predicate.Type, predicate.ParamName)]
+}
+
+// ruleTarget returns the available target a matching rule leads to
+func (p *Proxy) ruleTarget(r *http.Request, info *RedirectInfo, rule *compiledRule, getEnv func() *exprEnv) *Target {
+	switch {
+	case rule.targetExpr != nil:
+		result, err := expr.Run(rule.targetExpr, getEnv())
+		if err != nil {
+			log.Printf("Error evaluating target expression in proxy %s: %v", p.ID, err)
+			return nil
+		}
+		targetID, _ := result.(string)
+		return p.getTargetById(targetID)
+	case len(rule.split) > 0:
+		targets := make([]Target, 0, len(rule.split))
+		for _, wt := range rule.split {
+			if target := p.getTargetById(wt.TargetID); target != nil {
+				target.Weight = wt.Weight
+				targets = append(targets, *target)
+			}
+		}
+		// Same hashing as weighted selection, see pickWeighted
+		return pickWeighted(p.ID, p.salt(), p.unitID(r, info), targets)
+	default:
+		return p.getTargetById(rule.targetID)
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholders of target URL templates:
+//
+//   - {path}: request path left after the path key in path mode, e.g. "/products/42"
+//   - {query.NAME}: value of the NAME query parameter, empty when missing
+//   - {host}: request host, without port
+//   - {rid}, {rrid}, {ruid}: identifiers of the redirect, see RedirectInfo
+//   - {variant}: ID of the target
+//
+// Values are escaped for the part of the URL they are in, {path} is inserted
+// as is in the path. Target URLs without placeholders are used unchanged.
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)(?:\.([^{}]+))?\}`)
+
+// IsTemplate reports whether a target URL has placeholders
+func IsTemplate(targetURL string) bool {
+	return placeholderPattern.MatchString(targetURL)
+}
+
+// ValidateTargetURL checks the placeholders of a target URL
+func ValidateTargetURL(targetURL string) error {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(targetURL, -1) {
+		name, arg := match[1], match[2]
+		switch name {
+		case "query":
+			if arg == "" {
+				return fmt.Errorf("placeholder %s needs a parameter name, e.g. {query.id}", match[0])
+			}
+		case "path", "host", "rid", "rrid", "ruid", "variant":
+			if arg != "" {
+				return fmt.Errorf("placeholder {%s} takes no argument", name)
+			}
+		default:
+			return fmt.Errorf("unknown placeholder %s", match[0])
+		}
+	}
+	if _, err := url.Parse(placeholderPattern.ReplaceAllString(targetURL, "x")); err != nil {
+		return fmt.Errorf("invalid target URL: %w", err)
+	}
+	return nil
+}
+
+// staticURL returns the target URL without its placeholders, e.g. to reach the
+// target host for health checks
+func staticURL(targetURL string) string {
+	return placeholderPattern.ReplaceAllString(targetURL, "")
+}
+
+// expandTarget returns the URL of the target for the request, with the
+// placeholders of its template replaced
+func expandTarget(target *Target, r *http.Request, info *RedirectInfo) (*url.URL, error) {
+	if !IsTemplate(target.URL) {
+		return url.Parse(target.URL)
+	}
+
+	// The query part of the template starts at the first "?"
+	queryStart := strings.Index(target.URL, "?")
+	if queryStart < 0 {
+		queryStart = len(target.URL)
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(target.URL, -1) {
+		start, end := loc[0], loc[1]
+		sb.WriteString(target.URL[last:start])
+		last = end
+
+		name := target.URL[loc[2]:loc[3]]
+		var arg string
+		if loc[4] >= 0 {
+			arg = target.URL[loc[4]:loc[5]]
+		}
+		inQuery := start > queryStart
+
+		if name == "path" && !inQuery {
+			path := r.URL.EscapedPath()
+			// "https://example.com/{path}" keeps a single slash
+			if strings.HasSuffix(sb.String(), "/") {
+				path = strings.TrimPrefix(path, "/")
+			}
+			sb.WriteString(path)
+			continue
+		}
+
+		value, ok := placeholderValue(name, arg, target, r, info)
+		if !ok {
+			// Unknown placeholders are left as they are
+			sb.WriteString(target.URL[start:end])
+			continue
+		}
+		if inQuery {
+			sb.WriteString(url.QueryEscape(value))
+		} else {
+			sb.WriteString(url.PathEscape(value))
+		}
+	}
+	sb.WriteString(target.URL[last:])
+
+	return url.Parse(sb.String())
+}
+
+func placeholderValue(name, arg string, target *Target, r *http.Request, info *RedirectInfo) (string, bool) {
+	switch name {
+	case "path":
+		return r.URL.Path, true
+	case "query":
+		return r.URL.Query().Get(arg), true
+	case "host":
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			return host, true
+		}
+		return r.Host, true
+	case "rid":
+		return info.RID, true
+	case "rrid":
+		return info.RRID, true
+	case "ruid":
+		return info.RUID, true
+	case "variant":
+		return target.ID, true
+	}
+	return "", false
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExpandTarget(t *testing.T) {
+	info := &RedirectInfo{RID: "rid_p", RRID: "rr", RUID: "ru"}
+
+	tests := []struct {
+		name   string
+		target string
+		path   string // request path and query
+		want   string
+	}{
+		{name: "static", target: "https://example.com/landing?utm=x", path: "/products/42",
+			want: "https://example.com/landing?utm=x"},
+		{name: "static with unknown braces", target: "https://example.com/{Path}", path: "/products/42",
+			want: "https://example.com/%7BPath%7D"},
+		{name: "path after slash", target: "https://example.com/{path}", path: "/products/42",
+			want: "https://example.com/products/42"},
+		{name: "path after host", target: "https://example.com{path}", path: "/products/42",
+			want: "https://example.com/products/42"},
+		{name: "path under prefix", target: "https://example.com/shop/{path}?from=ab", path: "/cart",
+			want: "https://example.com/shop/cart?from=ab"},
+		{name: "root path", target: "https://example.com/shop/{path}", path: "/",
+			want: "https://example.com/shop/"},
+		{name: "escaped path kept", target: "https://example.com/{path}", path: "/a%2Fb/c%20d",
+			want: "https://example.com/a%2Fb/c%20d"},
+		{name: "path in query", target: "https://example.com/?from={path}", path: "/products/42",
+			want: "https://example.com/?from=%2Fproducts%2F42"},
+		{name: "query value in path and query", target: "https://example.com/items/{query.id}?q={query.id}",
+			path: "/?id=a+b%2Fc&x=1", want: "https://example.com/items/a%20b%2Fc?q=a+b%2Fc"},
+		{name: "missing query value", target: "https://example.com/?id={query.id}", path: "/",
+			want: "https://example.com/?id="},
+		{name: "host without port", target: "https://cdn.example.com/{host}/", path: "/",
+			want: "https://cdn.example.com/shop.example.com/"},
+		{name: "identifiers", target: "https://example.com/{variant}?rid={rid}&rrid={rrid}&ruid={ruid}", path: "/",
+			want: "https://example.com/t1?rid=rid_p&rrid=rr&ruid=ru"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Host = "shop.example.com:8080"
+			got, err := expandTarget(&Target{ID: "t1", URL: tt.target}, r, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("expandTarget() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTargetURL(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{target: "https://example.com/landing?utm=x"},
+		{target: "https://example.com/{path}?id={query.id}&v={variant}"},
+		{target: "https://{host}/{rid}/{rrid}/{ruid}"},
+		{target: "https://example.com/{Path}"}, // not a placeholder
+		{target: "https://example.com/?id={query}", wantErr: true},
+		{target: "https://example.com/{path.x}", wantErr: true},
+		{target: "https://example.com/{user}", wantErr: true},
+		{target: "http://[::1/{path}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if err := ValidateTargetURL(tt.target); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTargetURL() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// updateProxyAllocation sets the share of the visitors enrolled into the
+// experiment and the target the others are sent to
+func (s *Server) updateProxyAllocation(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req models.AllocationSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "percent must be between 0 and 100"})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+	if req.TargetID != "" && findTarget(currentProxy.Targets, req.TargetID) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target not found"})
+		return
+	}
+
+	if err := s.storage.UpdateProxyAllocation(c.Request.Context(), proxyID, &req, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/analysis"
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/storage"
+)
+
+const defaultConfidence = 0.95
+
+type AnalysisResponse struct {
+	*analysis.Result
+	GoalID    string    `json:"goal_id,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+type UpdateControlRequest struct {
+	TargetID string `json:"target_id"`
+}
+
+// updateProxyControl designates the target variants are compared to
+func (s *Server) updateProxyControl(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req UpdateControlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	if req.TargetID != "" && findTarget(currentProxy.Targets, req.TargetID) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target not found"})
+		return
+	}
+
+	if err := s.storage.UpdateProxyControl(c.Request.Context(), proxyID, req.TargetID, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// getProxyAnalysis compares every target of a proxy to the control on a metric.
+//
+// Query parameters:
+//   - metric: conversion_rate (default), error_rate or requests_per_user
+//   - goal: goal ID or name, required for conversion_rate when the proxy has several goals
+//   - control: control target ID, defaults to the designated control, then to the first target
+//   - confidence: confidence level of intervals and significance, 0.95 by default
+//   - start_time, end_time: RFC3339 time range, the last 7 days by default
+func (s *Server) getProxyAnalysis(c *gin.Context) {
+	proxyID := c.Param("proxy_id")
+
+	start, end, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	metric := analysis.Metric(c.DefaultQuery("metric", string(analysis.MetricConversionRate)))
+	if !metric.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metric"})
+		return
+	}
+
+	confidence := defaultConfidence
+	if value := c.Query("confidence"); value != "" {
+		confidence, err = strconv.ParseFloat(value, 64)
+		if err != nil || confidence <= 0 || confidence >= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "confidence must be between 0 and 1"})
+			return
+		}
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+	if len(currentProxy.Targets) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "proxy has no targets"})
+		return
+	}
+
+	controlID := c.Query("control")
+	if controlID == "" {
+		controlID = currentProxy.Settings.ControlTarget(currentProxy.Targets)
+	}
+	if findTarget(currentProxy.Targets, controlID) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "control target not found"})
+		return
+	}
+
+	var goal *models.Goal
+	if metric == analysis.MetricConversionRate {
+		goal, err = findGoal(currentProxy.Settings.Goals, c.Query("goal"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	totals, err := s.storage.GetTargetTotals(c.Request.Context(), start, end, proxyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var control analysis.Sample
+	var variants []analysis.Sample
+	for _, target := range currentProxy.Targets {
+		sample := newSample(metric, goal, target.ID, totals[target.ID])
+		if target.ID == controlID {
+			control = sample
+		} else {
+			variants = append(variants, sample)
+		}
+	}
+
+	response := AnalysisResponse{
+		Result:    analysis.Compare(metric, control, variants, confidence),
+		StartTime: start,
+		EndTime:   end,
+	}
+	if goal != nil {
+		response.GoalID = goal.ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func newSample(metric analysis.Metric, goal *models.Goal, targetID string, totals *storage.TargetTotals) analysis.Sample {
+	if totals == nil {
+		return analysis.NewSample(targetID, 0, 0)
+	}
+
+	switch metric {
+	case analysis.MetricErrorRate:
+		return analysis.NewSample(targetID, totals.Requests, totals.Errors)
+	case analysis.MetricRequestsPerUser:
+		return analysis.NewSample(targetID, totals.Users, totals.Requests)
+	default:
+		// Users are the trials: conversions are counted once per visitor and
+		// the requests of a visitor are not independent
+		var conversions int64
+		if c := totals.Conversions[goal.ID]; c != nil {
+			conversions = c.Conversions
+		}
+		return analysis.NewSample(targetID, totals.Users, conversions)
+	}
+}
+
+func findTarget(targets []models.Target, id string) *models.Target {
+	for i := range targets {
+		if targets[i].ID == id {
+			return &targets[i]
+		}
+	}
+	return nil
+}
+
+// findGoal returns the goal with the given ID or name, or the only goal when key is empty
+func findGoal(goals []models.Goal, key string) (*models.Goal, error) {
+	if key == "" {
+		if len(goals) != 1 {
+			return nil, errors.New("goal is required")
+		}
+		return &goals[0], nil
+	}
+
+	for i := range goals {
+		if goals[i].ID == key || goals[i].Name == key {
+			return &goals[i], nil
+		}
+	}
+	return nil, errors.New("goal not found")
+}
+
+// parseTimeRange reads the RFC3339 start_time and end_time query parameters,
+// defaulting to the last 7 days
+func parseTimeRange(c *gin.Context) (start, end time.Time, err error) {
+	end = time.Now()
+	start = end.AddDate(0, 0, -7)
+
+	if value := c.Query("start_time"); value != "" {
+		if start, err = time.Parse(time.RFC3339, value); err != nil {
+			return start, end, errors.New("invalid start_time format")
+		}
+	}
+	if value := c.Query("end_time"); value != "" {
+		if end, err = time.Parse(time.RFC3339, value); err != nil {
+			return start, end, errors.New("invalid end_time format")
+		}
+	}
+	return start, end, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// BucketingRequest selects the identifier visitors are bucketed by:
+// "ruid" (default, X-User-ID header or ruid cookie), "header", "cookie" or "query"
+// with the name of the header, cookie or query parameter in unit_key
+type BucketingRequest struct {
+	UnitType string `json:"unit_type"`
+	UnitKey  string `json:"unit_key,omitempty"`
+}
+
+func (r *BucketingRequest) toModel(salt string) (*models.BucketingSettings, error) {
+	unitType := models.UnitType(r.UnitType)
+	if unitType == "" {
+		unitType = models.UnitTypeRUID
+	}
+	if !unitType.IsValid() {
+		return nil, errors.New("invalid bucketing unit type")
+	}
+	if unitType != models.UnitTypeRUID && r.UnitKey == "" {
+		return nil, errors.New("unit_key is required for header, cookie and query unit types")
+	}
+
+	return &models.BucketingSettings{
+		UnitType: unitType,
+		UnitKey:  r.UnitKey,
+		Salt:     salt,
+	}, nil
+}
+
+func (s *Server) updateProxyBucketing(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req BucketingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	// Changing the unit source keeps the salt
+	var salt string
+	if currentProxy.Settings.Bucketing != nil {
+		salt = currentProxy.Settings.Bucketing.Salt
+	}
+
+	bucketing, err := req.toModel(salt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.saveProxyBucketing(c, proxyID, bucketing)
+}
+
+// reshuffleProxy rotates the bucketing salt, so every visitor is assigned again
+func (s *Server) reshuffleProxy(c *gin.Context) {
+	proxyID := c.Param("id")
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	bucketing := &models.BucketingSettings{UnitType: models.UnitTypeRUID}
+	if currentProxy.Settings.Bucketing != nil {
+		*bucketing = *currentProxy.Settings.Bucketing
+	}
+	bucketing.Salt = uuid.New().String()
+
+	s.saveProxyBucketing(c, proxyID, bucketing)
+}
+
+func (s *Server) saveProxyBucketing(c *gin.Context, proxyID string, bucketing *models.BucketingSettings) {
+	if err := s.storage.UpdateProxyBucketing(c.Request.Context(), proxyID, bucketing, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, bucketing)
+}
+
+// getProxyAssignment returns the target a unit ID is bucketed into by weighted
+// selection, along with the per-target hash values used to pick it
+func (s *Server) getProxyAssignment(c *gin.Context) {
+	proxyID := c.Param("id")
+	unitID := c.Query("unit_id")
+	if unitID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unit_id is required"})
+		return
+	}
+
+	p := s.supervisor.GetProxy(proxyID)
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "proxy not found"})
+		return
+	}
+
+	target := p.Assign(unitID)
+	if target == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "no active targets available"})
+		return
+	}
+
+	var salt string
+	if p.Config.Settings.Bucketing != nil {
+		salt = p.Config.Settings.Bucketing.Salt
+	}
+
+	hashes := make(map[string]float64, len(p.Targets))
+	for _, t := range p.Targets {
+		hashes[t.ID] = proxy.BucketHash(proxyID, salt, unitID, t.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"unit_id":   unitID,
+		"salt":      salt,
+		"target_id": target.ID,
+		"hashes":    hashes,
+	})
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/certs"
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+	"github.com/ab-testing-service/internal/storage"
+)
+
+type CertificateRequest struct {
+	CertPEM string `json:"cert_pem" binding:"required"` // certificate chain, leaf first
+	KeyPEM  string `json:"key_pem" binding:"required"`
+}
+
+// listCertificates returns the TLS certificates in use by host, loaded from
+// files or uploaded through the API
+func (s *Server) listCertificates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": s.supervisor.Certificates().List()})
+}
+
+// putCertificate uploads the TLS certificate of a listen host. The private key
+// is stored encrypted, the listeners of this instance use the certificate right
+// away and the other instances on their next reload.
+func (s *Server) putCertificate(c *gin.Context) {
+	host := strings.ToLower(c.Param("host"))
+	if addr, err := proxy.ParseListenURL(host); err != nil || addr.String() != host {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "host must be a host name, a wildcard like *.example.com or *"})
+		return
+	}
+
+	var req CertificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cert, err := certs.Parse([]byte(req.CertPEM), []byte(req.KeyPEM))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid certificate: " + err.Error()})
+		return
+	}
+	if !certs.Covers(cert, host) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "certificate is not valid for " + host})
+		return
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "certificate has expired"})
+		return
+	}
+
+	store := s.supervisor.Certificates()
+	encryptedKey, err := store.Encrypt([]byte(req.KeyPEM))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	certificate := &models.Certificate{
+		Host:         host,
+		CertPEM:      req.CertPEM,
+		EncryptedKey: encryptedKey,
+		NotAfter:     cert.Leaf.NotAfter,
+		CreatedBy:    s.getUserID(c),
+	}
+	if err := s.storage.SaveCertificate(c.Request.Context(), certificate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := store.Reload(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, certs.Info{
+		Host:     host,
+		Source:   certs.SourceAPI,
+		Names:    cert.Leaf.DNSNames,
+		NotAfter: cert.Leaf.NotAfter,
+	})
+}
+
+// deleteCertificate deletes a certificate uploaded through the API, a
+// certificate file for the same host is used again
+func (s *Server) deleteCertificate(c *gin.Context) {
+	host := strings.ToLower(c.Param("host"))
+	if err := s.storage.DeleteCertificate(c.Request.Context(), host); err != nil {
+		if errors.Is(err, storage.ErrCertificateNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.supervisor.Certificates().Reload(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// transparentPixel is a 1x1 transparent GIF
+var transparentPixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// CollectRequest is a conversion reported by a target page. The proxy is given
+// by proxy_id or by rid ("rid_<proxy id>"), the visitor by the ruid and/or rrid
+// the proxy issued when sending it to the target.
+type CollectRequest struct {
+	ProxyID string  `form:"proxy_id" json:"proxy_id"`
+	Goal    string  `form:"goal" json:"goal"` // goal ID or name
+	RID     string  `form:"rid" json:"rid"`
+	RRID    string  `form:"rrid" json:"rrid"`
+	RUID    string  `form:"ruid" json:"ruid"`
+	Value   float64 `form:"value" json:"value"` // revenue for revenue goals
+}
+
+// collectPixel records a conversion from an image request:
+// <img src="/api/collect?rid=...&ruid=...&goal=signup">
+func (s *Server) collectPixel(c *gin.Context) {
+	var req CollectRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, ok := s.collect(c, &req); !ok {
+		return // Error already sent to client
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/gif", transparentPixel)
+}
+
+// collectEvent records a conversion sent as JSON
+func (s *Server) collectEvent(c *gin.Context) {
+	var req CollectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, ok := s.collect(c, &req)
+	if !ok {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *Server) collect(c *gin.Context, req *CollectRequest) (*proxy.ConversionResult, bool) {
+	proxyID := req.ProxyID
+	if proxyID == "" {
+		proxyID = strings.TrimPrefix(req.RID, "rid_")
+	}
+	if proxyID == "" || req.Goal == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "proxy_id or rid and goal are required"})
+		return nil, false
+	}
+
+	// The ruid cookie is sent when the collect endpoint shares the proxy domain
+	if req.RUID == "" {
+		if cookie, err := c.Request.Cookie("ruid"); err == nil {
+			req.RUID = cookie.Value
+		}
+	}
+	if req.RUID == "" && req.RRID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ruid or rrid is required"})
+		return nil, false
+	}
+	if req.Value < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value must be non-negative"})
+		return nil, false
+	}
+
+	p := s.supervisor.GetProxy(proxyID)
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "proxy not found"})
+		return nil, false
+	}
+
+	result, err := p.RecordConversion(c.Request.Context(), proxy.Conversion{
+		Goal:  req.Goal,
+		RUID:  req.RUID,
+		RRID:  req.RRID,
+		Value: req.Value,
+	})
+	switch {
+	case errors.Is(err, proxy.ErrUnknownGoal), errors.Is(err, proxy.ErrNotAssigned):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	case errors.Is(err, proxy.ErrNotRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return result, true
+}
@@ -1,34 +1,159 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
 )
 
+// TargetingRuleRequest is a targeting rule of a request. Targets are referred to
+// by ID, or by position in the targets of the request like the values of a
+// single condition.
+type TargetingRuleRequest struct {
+	Name       string             `json:"name,omitempty"`
+	Match      models.MatchMode   `json:"match,omitempty"` // "all" (AND, default) or "any" (OR)
+	Predicates []models.Predicate `json:"predicates"`
+	Target     *int               `json:"target,omitempty"`
+	TargetID   string             `json:"target_id,omitempty"`
+	Split      []RuleSplitRequest `json:"split,omitempty"`
+}
+
+type RuleSplitRequest struct {
+	Target   *int    `json:"target,omitempty"`
+	TargetID string  `json:"target_id,omitempty"`
+	Weight   float64 `json:"weight"`
+}
+
+// updateProxyCondition replaces the targeting rules of a proxy, referring to its
+// current targets
 func (s *Server) updateProxyCondition(c *gin.Context) {
 	proxyID := c.Param("id")
-	var condition models.RouteCondition
-	if err := c.ShouldBindJSON(&condition); err != nil {
+	var req RouteCondition
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get user ID from context
-	var userID *string
-	if user, exists := c.Get("user"); exists {
-		if u, ok := user.(*models.User); ok {
-			userID = &u.ID
-		}
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	condition, err := s.convertCondition(&req, currentProxy.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if condition == nil {
+		condition = &models.RouteCondition{}
 	}
 
 	// Update condition in storage
-	if err := s.storage.UpdateProxyCondition(c.Request.Context(), proxyID, &condition, userID); err != nil {
+	if err := s.storage.UpdateProxyCondition(c.Request.Context(), proxyID, condition, s.getUserID(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.Status(http.StatusOK)
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, condition)
+}
+
+// convertCondition converts and validates the condition of a request. A single
+// condition is converted to targeting rules, nil means no rules.
+func (s *Server) convertCondition(req *RouteCondition, targets []models.Target) (*models.RouteCondition, error) {
+	if req == nil {
+		return nil, nil
+	}
+	if len(req.Rules) > 0 && req.Type != "" {
+		return nil, errors.New("condition takes either rules or a single condition")
+	}
+
+	targetIDs := make([]string, len(targets))
+	known := make(map[string]bool, len(targets))
+	for i, t := range targets {
+		targetIDs[i] = t.ID
+		known[t.ID] = true
+	}
+
+	var rules []models.TargetingRule
+	switch {
+	case len(req.Rules) > 0:
+		for i, ruleReq := range req.Rules {
+			rule, err := convertRule(ruleReq, targetIDs)
+			if err != nil {
+				return nil, fmt.Errorf("rules[%d]: %w", i, err)
+			}
+			rules = append(rules, rule)
+		}
+	case req.Type != "":
+		if err := s.validateConditionFields(req, len(targets)); err != nil {
+			return nil, err
+		}
+		// Values are matched to targets by position
+		legacy := models.RouteCondition{
+			Type:      models.ConditionType(req.Type),
+			ParamName: req.ParamName,
+			Values:    make(map[string]string, len(req.Values)),
+			Default:   req.Default,
+			Expr:      req.Expr,
+		}
+		for i, v := range req.Values {
+			legacy.Values[targets[i].ID] = v
+		}
+		rules = legacy.TargetingRules(targetIDs)
+	default:
+		return nil, nil
+	}
+
+	for i := range rules {
+		if err := rules[i].Validate(known); err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+	}
+	if err := proxy.ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return &models.RouteCondition{Rules: rules}, nil
+}
+
+func convertRule(req TargetingRuleRequest, targetIDs []string) (models.TargetingRule, error) {
+	rule := models.TargetingRule{
+		Name:       req.Name,
+		Match:      req.Match,
+		Predicates: req.Predicates,
+	}
+
+	var err error
+	if req.Target != nil || req.TargetID != "" {
+		if rule.TargetID, err = resolveTarget(req.Target, req.TargetID, targetIDs); err != nil {
+			return rule, err
+		}
+	}
+	for _, split := range req.Split {
+		targetID, err := resolveTarget(split.Target, split.TargetID, targetIDs)
+		if err != nil {
+			return rule, err
+		}
+		rule.Split = append(rule.Split, models.WeightedTarget{TargetID: targetID, Weight: split.Weight})
+	}
+	return rule, nil
+}
+
+// resolveTarget returns the ID of a target referred to by position or by ID
+func resolveTarget(position *int, targetID string, targetIDs []string) (string, error) {
+	if position == nil {
+		return targetID, nil
+	}
+	if *position < 0 || *position >= len(targetIDs) {
+		return "", fmt.Errorf("target %d out of range", *position)
+	}
+	return targetIDs[*position], nil
 }
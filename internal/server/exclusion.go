@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+type ExclusionResponse struct {
+	Exclusion *models.ExclusionSettings `json:"exclusion"`
+	Global    models.ExclusionRules     `json:"global"` // rules of the service config
+}
+
+func (s *Server) getProxyExclusion(c *gin.Context) {
+	currentProxy, err := s.getCurrentProxy(c, c.Param("id"))
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, ExclusionResponse{
+		Exclusion: currentProxy.Settings.Exclusion,
+		Global:    s.config.Exclusion,
+	})
+}
+
+// updateProxyExclusion replaces the exclusion rules of a proxy and the target
+// excluded traffic is sent to
+func (s *Server) updateProxyExclusion(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req models.ExclusionSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+	if req.TargetID != "" && findTarget(currentProxy.Targets, req.TargetID) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target not found"})
+		return
+	}
+
+	if err := s.storage.UpdateProxyExclusion(c.Request.Context(), proxyID, &req, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+type GoalRequest struct {
+	ID          string `json:"id,omitempty"` // keeps the goal and its statistics when renaming
+	Name        string `json:"name"`
+	Type        string `json:"type"` // "count" (default) or "revenue"
+	DedupWindow int    `json:"dedup_window"`
+}
+
+type UpdateGoalsRequest struct {
+	Goals []GoalRequest `json:"goals"`
+}
+
+func (s *Server) getProxyGoals(c *gin.Context) {
+	currentProxy, err := s.getCurrentProxy(c, c.Param("id"))
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	goals := currentProxy.Settings.Goals
+	if goals == nil {
+		goals = []models.Goal{}
+	}
+	c.JSON(http.StatusOK, gin.H{"goals": goals})
+}
+
+// updateProxyGoals replaces the goals of a proxy. Goals keep their IDs when
+// matched by id or name, so conversions collected so far stay attached.
+func (s *Server) updateProxyGoals(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req UpdateGoalsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	goals, err := convertToGoalModels(currentProxy.Settings.Goals, req.Goals)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.storage.UpdateProxyGoals(c.Request.Context(), proxyID, goals, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, gin.H{"goals": goals})
+}
+
+func convertToGoalModels(current []models.Goal, req []GoalRequest) ([]models.Goal, error) {
+	existingIDs := make(map[string]string, len(current))
+	for _, g := range current {
+		existingIDs[g.Name] = g.ID
+		existingIDs[g.ID] = g.ID
+	}
+
+	names := make(map[string]bool, len(req))
+	used := make(map[string]bool, len(req))
+	goals := make([]models.Goal, 0, len(req))
+	for _, g := range req {
+		if g.Name == "" {
+			return nil, errors.New("goal name is required")
+		}
+		if names[g.Name] {
+			return nil, fmt.Errorf("duplicate goal name %s", g.Name)
+		}
+		names[g.Name] = true
+
+		goalType := models.GoalType(g.Type)
+		if goalType == "" {
+			goalType = models.GoalTypeCount
+		}
+		if !goalType.IsValid() {
+			return nil, fmt.Errorf("invalid type for goal %s", g.Name)
+		}
+		if g.DedupWindow < 0 {
+			return nil, fmt.Errorf("dedup_window of goal %s must be non-negative", g.Name)
+		}
+
+		id, ok := existingIDs[g.ID]
+		if !ok || g.ID == "" {
+			id, ok = existingIDs[g.Name]
+		}
+		if !ok || used[id] {
+			id = uuid.New().String()
+		}
+		used[id] = true
+
+		goals = append(goals, models.Goal{
+			ID:          id,
+			Name:        g.Name,
+			Type:        goalType,
+			DedupWindow: g.DedupWindow,
+		})
+	}
+	return goals, nil
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/storage"
+)
+
+type GuardrailRequest struct {
+	ID          string  `json:"id,omitempty"`
+	Name        string  `json:"name"`
+	Metric      string  `json:"metric"`    // "error_rate" or "traffic"
+	Threshold   float64 `json:"threshold"` // error rate percent or minimum requests
+	Window      int     `json:"window"`    // minutes
+	MinRequests int64   `json:"min_requests"`
+	Action      string  `json:"action"` // "deactivate" (default) or "shift_to_control"
+}
+
+type UpdateGuardrailsRequest struct {
+	Guardrails []GuardrailRequest `json:"guardrails"`
+}
+
+type GuardrailsResponse struct {
+	Guardrails []models.Guardrail     `json:"guardrails"`
+	Trips      []models.GuardrailTrip `json:"trips"`
+}
+
+func (s *Server) getProxyGuardrails(c *gin.Context) {
+	currentProxy, err := s.getCurrentProxy(c, c.Param("id"))
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, newGuardrailsResponse(currentProxy.Settings.Guardrails, currentProxy.Settings.GuardrailTrips))
+}
+
+// updateProxyGuardrails replaces the guardrails of a proxy. New and changed
+// guardrails are armed now and only consider the statistics collected from now on.
+func (s *Server) updateProxyGuardrails(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req UpdateGuardrailsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	guardrails, err := convertToGuardrailModels(currentProxy.Settings.Guardrails, req.Guardrails, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if currentProxy.Mode != models.ProxyModeProxy {
+		// Redirected visitors never reach the targets through the proxy, there
+		// are no errors to count
+		for _, guardrail := range guardrails {
+			if guardrail.Metric == models.GuardrailMetricErrorRate {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("guardrail %s: error_rate requires a proxy in proxy mode", guardrail.Name),
+				})
+				return
+			}
+		}
+	}
+
+	if err := s.storage.UpdateProxyGuardrails(c.Request.Context(), proxyID, guardrails, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, newGuardrailsResponse(guardrails, currentProxy.Settings.GuardrailTrips))
+}
+
+// revertGuardrailTrip undoes an automatic rollback and re-arms the guardrail
+func (s *Server) revertGuardrailTrip(c *gin.Context) {
+	proxyID := c.Param("id")
+	if _, err := s.getCurrentProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	trip, err := s.storage.RevertGuardrailTrip(c.Request.Context(), proxyID, c.Param("trip_id"), s.getUserID(c))
+	switch {
+	case errors.Is(err, storage.ErrTripNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrTripReverted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, trip)
+}
+
+func newGuardrailsResponse(guardrails []models.Guardrail, trips []models.GuardrailTrip) GuardrailsResponse {
+	if guardrails == nil {
+		guardrails = []models.Guardrail{}
+	}
+	if trips == nil {
+		trips = []models.GuardrailTrip{}
+	}
+	return GuardrailsResponse{Guardrails: guardrails, Trips: trips}
+}
+
+func convertToGuardrailModels(current []models.Guardrail, req []GuardrailRequest, now time.Time) ([]models.Guardrail, error) {
+	existing := make(map[string]models.Guardrail, len(current))
+	for _, g := range current {
+		existing[g.ID] = g
+	}
+
+	names := make(map[string]bool, len(req))
+	guardrails := make([]models.Guardrail, 0, len(req))
+	for _, g := range req {
+		if g.Name == "" {
+			return nil, errors.New("guardrail name is required")
+		}
+		if names[g.Name] {
+			return nil, fmt.Errorf("duplicate guardrail name %s", g.Name)
+		}
+		names[g.Name] = true
+
+		metric := models.GuardrailMetric(g.Metric)
+		if !metric.IsValid() {
+			return nil, fmt.Errorf("invalid metric for guardrail %s", g.Name)
+		}
+		action := models.GuardrailAction(g.Action)
+		if action == "" {
+			action = models.GuardrailActionDeactivate
+		}
+		if !action.IsValid() {
+			return nil, fmt.Errorf("invalid action for guardrail %s", g.Name)
+		}
+		if g.Window <= 0 {
+			return nil, fmt.Errorf("window of guardrail %s must be positive", g.Name)
+		}
+		if g.Threshold < 0 || (metric == models.GuardrailMetricErrorRate && g.Threshold >= 100) {
+			return nil, fmt.Errorf("invalid threshold for guardrail %s", g.Name)
+		}
+		if g.MinRequests < 0 {
+			return nil, fmt.Errorf("min_requests of guardrail %s must be non-negative", g.Name)
+		}
+
+		guardrail := models.Guardrail{
+			ID:          g.ID,
+			Name:        g.Name,
+			Metric:      metric,
+			Threshold:   g.Threshold,
+			Window:      g.Window,
+			MinRequests: g.MinRequests,
+			Action:      action,
+			ArmedAt:     now,
+		}
+
+		// Guardrails keep their arming time unless their rule changed
+		if previous, ok := existing[g.ID]; ok && g.ID != "" {
+			if previous.Metric == guardrail.Metric && previous.Threshold == guardrail.Threshold &&
+				previous.Window == guardrail.Window && previous.MinRequests == guardrail.MinRequests &&
+				previous.Action == guardrail.Action {
+				guardrail.ArmedAt = previous.ArmedAt
+			}
+		} else {
+			guardrail.ID = uuid.New().String()
+		}
+
+		guardrails = append(guardrails, guardrail)
+	}
+	return guardrails, nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// ProxyResponse is a running proxy with the health of its targets
+type ProxyResponse struct {
+	*proxy.Proxy
+	Health map[string]*proxy.TargetHealth `json:"health"` // target ID -> health, null when not checked
+}
+
+type HealthResponse struct {
+	HealthCheck *models.HealthCheckSettings `json:"health_check"`
+	Targets     []TargetHealthResponse      `json:"targets"`
+}
+
+type TargetHealthResponse struct {
+	proxy.TargetHealth
+	Checked bool `json:"checked"`
+}
+
+// getProxyHealth returns the health check settings and the health of every target
+func (s *Server) getProxyHealth(c *gin.Context) {
+	p := s.supervisor.GetProxy(c.Param("id"))
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "proxy not found"})
+		return
+	}
+
+	health := p.Health()
+	response := HealthResponse{
+		HealthCheck: p.Config.Settings.HealthCheck,
+		Targets:     make([]TargetHealthResponse, 0, len(p.Config.Targets)),
+	}
+	for _, target := range p.Config.Targets {
+		item := TargetHealthResponse{
+			TargetHealth: proxy.TargetHealth{TargetID: target.ID, URL: target.URL, Healthy: true},
+		}
+		if state := health[target.ID]; state != nil {
+			item.TargetHealth, item.Checked = *state, true
+		}
+		response.Targets = append(response.Targets, item)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// updateProxyHealthCheck replaces the health check settings of a proxy
+func (s *Server) updateProxyHealthCheck(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req models.HealthCheckSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Method = strings.ToUpper(req.Method)
+	if err := validateHealthCheck(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := s.getCurrentProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	if err := s.storage.UpdateProxyHealthCheck(c.Request.Context(), proxyID, &req, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func validateHealthCheck(hc *models.HealthCheckSettings) error {
+	switch hc.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return errors.New("method must be GET, HEAD or OPTIONS")
+	}
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return errors.New("path must start with /")
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 {
+		return errors.New("interval and timeout must be non-negative")
+	}
+	if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+		return errors.New("expected_status must be a valid HTTP status")
+	}
+	if hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		return errors.New("thresholds must be non-negative")
+	}
+
+	resolved := proxy.NewHealthCheck(&models.HealthCheckSettings{
+		Enabled:  true,
+		Interval: hc.Interval,
+		Timeout:  hc.Timeout,
+	})
+	if resolved.Timeout > resolved.Interval {
+		return errors.New("timeout must not exceed interval")
+	}
+	return nil
+}
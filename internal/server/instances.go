@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listInstances returns the live service instances, flagging the ones running
+// other proxy config versions than the database
+func (s *Server) listInstances(c *gin.Context) {
+	instances, err := s.supervisor.Instances(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": instances})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/storage"
+)
+
+type TransitionRequest struct {
+	// TargetID is the fallback target of pause, the control target by default,
+	// and the winner of complete
+	TargetID string `json:"target_id"`
+}
+
+type TransitionResponse struct {
+	State     models.ProxyState `json:"state"`
+	Lifecycle *models.Lifecycle `json:"lifecycle"`
+}
+
+// transitionProxy returns the handler taking a lifecycle action on a proxy
+func (s *Server) transitionProxy(action models.LifecycleAction) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proxyID := c.Param("id")
+		var req TransitionRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		if _, err := s.getCurrentProxy(c, proxyID); err != nil {
+			return // Error already sent to client
+		}
+
+		state, lifecycle, err := s.storage.TransitionProxyState(c.Request.Context(), proxyID, action,
+			req.TargetID, s.getUserID(c))
+		switch {
+		case errors.Is(err, storage.ErrInvalidTransition):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, storage.ErrTargetNotFound):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := s.reloadProxy(c, proxyID); err != nil {
+			return // Error already sent to client
+		}
+
+		c.JSON(http.StatusOK, TransitionResponse{State: state, Lifecycle: lifecycle})
+	}
+}
+
+// rejectArchivedChanges refuses the changes of archived proxies, their
+// configuration, history and results are read-only
+func (s *Server) rejectArchivedChanges() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proxyID := c.Param("id")
+		if proxyID == "" || c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		}
+
+		// Unknown proxies are answered by the handler
+		p, err := s.storage.GetProxy(c.Request.Context(), proxyID)
+		if err == nil && p.State == models.ProxyStateArchived {
+			c.JSON(http.StatusConflict, gin.H{"error": "proxy is archived"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/proxy"
+	"github.com/ab-testing-service/internal/supervisor"
+)
+
+const defaultPreviewTTL = 24 * time.Hour
+
+type PreviewLink struct {
+	TargetID  string    `json:"target_id"`
+	TargetURL string    `json:"target_url"`
+	IsActive  bool      `json:"is_active"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	URLs      []string  `json:"urls"` // one per listen URL
+}
+
+// getProxyPreviewLinks returns links forcing every target of a proxy, to share
+// with QA and stakeholders. Visits through them count in no stats.
+//
+// Query parameters:
+//   - ttl: lifetime of the links in seconds, 24 hours by default, 7 days at most
+//   - scheme: scheme of the links, http by default
+func (s *Server) getProxyPreviewLinks(c *gin.Context) {
+	ttl := defaultPreviewTTL
+	if value := c.Query("ttl"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > proxy.MaxOverrideTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ttl must be between 1 second and 7 days"})
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	scheme := c.DefaultQuery("scheme", "http")
+	if scheme != "http" && scheme != "https" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scheme must be http or https"})
+		return
+	}
+
+	p := s.supervisor.GetProxy(c.Param("id"))
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "proxy not found"})
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	links := make([]PreviewLink, 0, len(p.Config.Targets))
+	for _, target := range p.Config.Targets {
+		token := p.OverrideToken(target.ID, expiresAt)
+		link := PreviewLink{
+			TargetID:  target.ID,
+			TargetURL: target.URL,
+			IsActive:  target.IsActive,
+			Token:     token,
+			ExpiresAt: expiresAt,
+			URLs:      []string{},
+		}
+		for _, listenURL := range p.Config.ListenURLs {
+			if u, ok := previewURL(scheme, listenURL, token); ok {
+				link.URLs = append(link.URLs, u)
+			}
+		}
+		links = append(links, link)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"items": links})
+}
+
+// previewURL returns the URL of a listen URL carrying the override token. There
+// is none for wildcard hosts and regular expression path keys.
+func previewURL(scheme string, listenURL proxy.ListenURL, token string) (string, bool) {
+	addr, err := proxy.ParseListenURL(listenURL.ListenURL)
+	if err != nil || strings.Contains(addr.Host, "*") {
+		return "", false
+	}
+	if addr.Scheme != "" {
+		scheme = addr.Scheme
+	}
+	u := url.URL{Scheme: scheme, Host: addr.Host, Path: addr.Path}
+	if addr.Port != "" {
+		u.Host = net.JoinHostPort(addr.Host, addr.Port)
+	}
+	if listenURL.PathKey != nil {
+		route, err := supervisor.ParseRoute(*listenURL.PathKey)
+		if err != nil || route.Kind == supervisor.RouteRegex {
+			return "", false
+		}
+		u.Path = route.Pattern
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawQuery = url.Values{proxy.OverrideParam: {token}}.Encode()
+	return u.String(), true
+}
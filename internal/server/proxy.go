@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
@@ -13,49 +14,68 @@ import (
 	"github.com/ab-testing-service/internal/proxy"
 )
 
+// RouteCondition is the condition of a request: ordered targeting rules, or a
+// single condition converted to rules when saved
 type RouteCondition struct {
-	Type      string   `json:"type" db:"type"`        // Type of condition: "header", "query", "cookie", "user_agent", "language", "expr"
+	Rules []TargetingRuleRequest `json:"rules,omitempty"`
+
+	Type      string   `json:"type" db:"type"`        // Type of condition: "header", "query", "cookie", "user_agent", "language", "expr", "geo"
 	ParamName string   `json:"param_name" db:"param"` // Name of the parameter to check (for header, query, cookie)
 	Values    []string `json:"values" db:"values"`    // List of parameter values to match targets
 	Default   string   `json:"default" db:"default"`  // Default target ID if no match is found
 	Expr      string   `json:"expr,omitempty"`        // Expression for expr type condition
 }
 
-// ExpressionConditionExample shows how to use expression-based conditions
-// For example, to route traffic based on multiple conditions:
+// Targeting rules are evaluated in order, the first matching rule wins and
+// requests matching no rule get weighted selection. For example, Safari on
+// mobile in Germany gets the second target, visitors from a partner the third
+// and everyone else is split by the target weights:
 //
 //	{
-//	  "type": "expr",
-//	  "expr": "headers[\"user-agent\"] contains \"iPhone\" && query[\"version\"] == \"2\"",
-//	  "default": "target-id-1"
+//	  "rules": [
+//	    {
+//	      "name": "de-mobile-safari",
+//	      "predicates": [
+//	        {"type": "user_agent", "param_name": "browser", "values": ["safari"]},
+//	        {"type": "user_agent", "param_name": "platform", "values": ["mobile"]},
+//	        {"type": "geo", "param_name": "country", "values": ["DE"]}
+//	      ],
+//	      "target": 1
+//	    },
+//	    {
+//	      "name": "partner",
+//	      "match": "any",
+//	      "predicates": [
+//	        {"type": "expr", "expr": "referer contains 'partner.example'"},
+//	        {"type": "query", "param_name": "utm_source", "values": ["partner"]}
+//	      ],
+//	      "split": [{"target": 0, "weight": 0.5}, {"target": 2, "weight": 0.5}]
+//	    }
+//	  ]
 //	}
 //
-// Or using multiple expressions in the Values map:
+// A single expression condition is saved as one rule:
 //
 //	{
 //	  "type": "expr",
-//	  "values": [
-//	    "headers[\"user-agent\"] contains \"iPhone\"",
-//	    "query[\"version\"] == \"2\""
-//	  ],
-//	  "default": "target-id-3"
+//	  "expr": "headers[\"user-agent\"] contains \"iPhone\" ? \"target-id-1\" : \"target-id-2\"",
+//	  "default": "target-id-1"
 //	}
 //
-// Available variables in expressions:
-// - headers: map of request headers
-// - query: map of query parameters
-// - cookies: map of cookies
-// - method: request method (GET, POST, etc.)
-// - path: request path
-// - host: request host
+// See exprEnv in the proxy package for the variables available in expressions.
 type CreateProxyRequest struct {
-	ListenURL     string             `json:"listen_url" binding:"required"`
-	ListenURLs    []string           `json:"listen_urls,omitempty"`
-	Mode          string             `json:"mode" binding:"required"`
-	Tags          []string           `json:"tags"`
-	Targets       []CreateTargetSpec `json:"targets"`
-	Condition     *RouteCondition    `json:"condition,omitempty"`
-	PathKeyLength int                `json:"path_key_length,omitempty"` // Length of random path key for path-based routing
+	ListenURL     string                       `json:"listen_url" binding:"required"`
+	ListenURLs    []string                     `json:"listen_urls,omitempty"`
+	Mode          string                       `json:"mode" binding:"required"`
+	Tags          []string                     `json:"tags"`
+	Targets       []CreateTargetSpec           `json:"targets"`
+	Condition     *RouteCondition              `json:"condition,omitempty"`
+	PathKeyLength int                          `json:"path_key_length,omitempty"` // Length of random path key for path-based routing
+	PathKey       string                       `json:"path_key,omitempty"`        // Path key for path-based routing instead of a random one, see supervisor.Route
+	AllowOverlap  bool                         `json:"allow_overlap,omitempty"`   // Accept routes overlapping the routes of other proxies
+	Bucketing     *BucketingRequest            `json:"bucketing,omitempty"`
+	StickyCookie  *models.StickyCookieSettings `json:"sticky_cookie,omitempty"`
+	Start         bool                         `json:"start,omitempty"` // Create the proxy running instead of as a draft
 }
 
 type CreateTargetSpec struct {
@@ -65,9 +85,10 @@ type CreateTargetSpec struct {
 }
 
 type UpdateProxyURLRequest struct {
-	ListenURL  string   `json:"listen_url"`
-	ListenURLs []string `json:"listen_urls,omitempty"`
-	PathKey    *string  `json:"path_key,omitempty"`
+	ListenURL    string   `json:"listen_url"`
+	ListenURLs   []string `json:"listen_urls,omitempty"`
+	PathKey      *string  `json:"path_key,omitempty"`
+	AllowOverlap bool     `json:"allow_overlap,omitempty"`
 }
 
 func generateRandomString(length int) string {
@@ -79,6 +100,38 @@ func generateRandomString(length int) string {
 	return sb.String()
 }
 
+// pathKey returns the path key of the request, a random one when it has none
+func (req *CreateProxyRequest) pathKey() string {
+	if req.PathKey != "" {
+		return req.PathKey
+	}
+	if req.PathKeyLength == 0 {
+		req.PathKeyLength = 8 // Default length
+	}
+	return generateRandomString(req.PathKeyLength)
+}
+
+// checkRoutes rejects listen URLs conflicting with the routes of the other
+// proxies. Overlapping routes are accepted with allowOverlap, ambiguous ones
+// never are.
+func (s *Server) checkRoutes(c *gin.Context, proxyID string, listenURLs []proxy.ListenURL, allowOverlap bool) error {
+	conflicts, err := s.supervisor.CheckRoutes(proxyID, listenURLs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return err
+	}
+	for _, conflict := range conflicts {
+		if conflict.Ambiguous || !allowOverlap {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "routes conflict with other proxies",
+				"conflicts": conflicts,
+			})
+			return errors.New("routes conflict")
+		}
+	}
+	return nil
+}
+
 func (s *Server) createProxy(c *gin.Context) {
 	var req CreateProxyRequest
 	if err := c.BindJSON(&req); err != nil {
@@ -92,16 +145,36 @@ func (s *Server) createProxy(c *gin.Context) {
 	}
 
 	// Validate mode
-	if req.Mode != string(models.ProxyModeRedirect) &&
-		req.Mode != string(models.ProxyModePath) {
+	if !models.ProxyMode(req.Mode).IsValid() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid proxy mode"})
 		return
 	}
 
 	// Create proxy model
 	p := &models.Proxy{
-		Mode: models.ProxyMode(req.Mode),
-		Tags: req.Tags,
+		Mode:  models.ProxyMode(req.Mode),
+		Tags:  req.Tags,
+		State: models.ProxyStateDraft,
+	}
+	if req.Start {
+		p.State = models.ProxyStateRunning
+	}
+
+	if req.Bucketing != nil {
+		bucketing, err := req.Bucketing.toModel("")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p.Settings.Bucketing = bucketing
+	}
+
+	if req.StickyCookie != nil {
+		if err := validateStickyCookie(req.StickyCookie); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		p.Settings.StickyCookie = req.StickyCookie
 	}
 
 	// Handle listen URLs
@@ -109,11 +182,7 @@ func (s *Server) createProxy(c *gin.Context) {
 		// Use the provided listen URLs array
 		for _, url := range req.ListenURLs {
 			if req.Mode == string(models.ProxyModePath) {
-				if req.PathKeyLength == 0 {
-					req.PathKeyLength = 8 // Default length
-				}
-
-				key := generateRandomString(req.PathKeyLength)
+				key := req.pathKey()
 				// Create a ListenURL with the path key
 				listenURL := models.ListenURL{
 					ListenURL: url,
@@ -131,11 +200,7 @@ func (s *Server) createProxy(c *gin.Context) {
 	} else {
 		// Backward compatibility: use the single listen URL
 		if req.Mode == string(models.ProxyModePath) {
-			if req.PathKeyLength == 0 {
-				req.PathKeyLength = 8 // Default length
-			}
-
-			key := generateRandomString(req.PathKeyLength)
+			key := req.pathKey()
 			// Create a ListenURL with the path key
 			listenURL := models.ListenURL{
 				ListenURL: req.ListenURL,
@@ -151,10 +216,26 @@ func (s *Server) createProxy(c *gin.Context) {
 		}
 	}
 
+	// Check the routes before saving the proxy
+	configListenURLs := make([]proxy.ListenURL, len(p.ListenURLs))
+	for i, url := range p.ListenURLs {
+		configListenURLs[i] = proxy.ListenURL{
+			ListenURL: url.ListenURL,
+			PathKey:   url.PathKey,
+		}
+	}
+	if err := s.checkRoutes(c, "", configListenURLs, req.AllowOverlap); err != nil {
+		return // Error already sent to client
+	}
+
 	// Convert targets
 	if len(req.Targets) > 0 {
 		p.Targets = make([]models.Target, len(req.Targets))
 		for i, t := range req.Targets {
+			if err := proxy.ValidateTargetURL(t.URL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("targets[%d]: %v", i, err)})
+				return
+			}
 			p.Targets[i] = models.Target{
 				ID:       uuid.New().String(),
 				URL:      t.URL,
@@ -165,45 +246,12 @@ func (s *Server) createProxy(c *gin.Context) {
 	}
 
 	// Convert condition
-	if req.Condition != nil && req.Condition.Type != "" {
-		conditionType := models.ConditionType(req.Condition.Type)
-
-		if !conditionType.IsValid() {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid condition type"})
-			return
-		}
-
-		// For expression type, validate that we have either an Expr field or Values map
-		if conditionType == models.ConditionTypeExpr {
-			if req.Condition.Expr == "" && len(req.Condition.Values) == 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "expr condition requires either Expr field or Values map with expressions"})
-				return
-			}
-		} else {
-			// For non-expression types, validate that we have ParamName and Values
-			if req.Condition.ParamName == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "param_name is required for non-expr conditions"})
-				return
-			}
-			if len(req.Condition.Values) == 0 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "values are required for non-expr conditions"})
-				return
-			}
-		}
-
-		conditionValues := make(map[string]string, len(req.Condition.Values))
-		for i, v := range req.Condition.Values {
-			conditionValues[p.Targets[i].ID] = v
-		}
-
-		p.Condition = &models.RouteCondition{
-			Type:      conditionType,
-			ParamName: req.Condition.ParamName,
-			Values:    conditionValues,
-			Default:   req.Condition.Default,
-			Expr:      req.Condition.Expr,
-		}
+	condition, err := s.convertCondition(req.Condition, p.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	p.Condition = condition
 
 	// Create proxy in storage -> postgres
 	if err := s.storage.CreateProxy(c.Request.Context(), p); err != nil {
@@ -214,48 +262,18 @@ func (s *Server) createProxy(c *gin.Context) {
 		return
 	}
 
-	// Create proxy configuration for supervisor
-	cfg := proxy.Config{
-		ID:   p.ID,
-		Mode: p.Mode,
-	}
-
-	// Add ListenURLs to the config
-	configListenURLs := make([]proxy.ListenURL, len(p.ListenURLs))
-	for i, url := range p.ListenURLs {
-		configListenURLs[i] = proxy.ListenURL{
-			ListenURL: url.ListenURL,
-			PathKey:   url.PathKey,
-		}
-	}
-	cfg.ListenURLs = configListenURLs
-
-	// Convert targets to config format
-	if len(p.Targets) > 0 {
-		cfg.Targets = make([]proxy.Target, len(p.Targets))
-		for i, t := range p.Targets {
-			cfg.Targets[i] = proxy.Target{
-				ID:       t.ID,
-				URL:      t.URL,
-				Weight:   t.Weight,
-				IsActive: t.IsActive,
-			}
-		}
-	}
-
-	// Add condition if provided
-	if p.Condition != nil {
-		cfg.Condition = &proxy.Condition{
-			Type:      p.Condition.Type,
-			ParamName: p.Condition.ParamName,
-			Values:    p.Condition.Values,
-			Default:   p.Condition.Default,
-			Expr:      p.Condition.Expr,
-		}
+	// Load the proxy configuration for supervisor, with its config version
+	cfg, err := s.storage.LoadProxyConfig(c.Request.Context(), p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "failed to load proxy config",
+			"details": err.Error(),
+		})
+		return
 	}
 
 	// Create proxy in supervisor -> start proxy server
-	if err := s.supervisor.CreateProxy(cfg); err != nil {
+	if err := s.supervisor.CreateProxy(c.Request.Context(), cfg); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "failed to create proxy in supervisor",
 			"details": err.Error(),
@@ -282,6 +300,15 @@ func (s *Server) updateProxyURL(c *gin.Context) {
 		}
 	}
 
+	// Check the routes of the new listen URL before saving it
+	listenURL := req.ListenURL
+	if len(req.ListenURLs) > 0 {
+		listenURL = req.ListenURLs[0]
+	}
+	if err := s.checkRoutes(c, proxyID, []proxy.ListenURL{{ListenURL: listenURL, PathKey: req.PathKey}}, req.AllowOverlap); err != nil {
+		return // Error already sent to client
+	}
+
 	// Check if we're dealing with multiple listen URLs
 	if len(req.ListenURLs) > 0 {
 		// For now, use the first listen URL as the primary one
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+const maxRedirectHops = 20
+
+// updateProxyRedirect sets the status code, cache headers and hop limit of the
+// redirects sent by a proxy
+func (s *Server) updateProxyRedirect(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req models.RedirectSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateRedirect(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := s.getCurrentProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	if err := s.storage.UpdateProxyRedirect(c.Request.Context(), proxyID, &req, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func validateRedirect(settings *models.RedirectSettings) error {
+	if settings.Status != 0 && !proxy.IsValidRedirectStatus(settings.Status) {
+		return errors.New("status must be 302, 303, 307 or 308")
+	}
+	if strings.ContainsAny(settings.CacheControl, "\r\n") {
+		return errors.New("invalid cache_control")
+	}
+	if settings.MaxHops < 0 || settings.MaxHops > maxRedirectHops {
+		return errors.New("max_hops must be between 0 and 20")
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/ab-testing-service/internal/middleware"
+	"github.com/ab-testing-service/internal/models"
 )
 
 func (s *Server) setupRouter() {
@@ -34,9 +35,13 @@ func (s *Server) setupRouter() {
 		auth.POST("/register", s.register)
 	}
 
+	// Conversion collection, called from target pages
+	r.GET("/api/collect", s.collectPixel)
+	r.POST("/api/collect", s.collectEvent)
+
 	// Protected routes
 	api := r.Group("/api")
-	api.Use(middleware.AuthMiddleware(s.config))
+	api.Use(middleware.AuthMiddleware(s.config), s.rejectArchivedChanges())
 	{
 		api.GET("/proxies", s.listProxies)
 		api.POST("/proxies", s.createProxy)
@@ -45,19 +50,53 @@ func (s *Server) setupRouter() {
 		api.GET("/proxies/:id/history", s.getProxyChanges)
 		api.GET("/proxies/:id/changes", s.getProxyChanges)
 		api.PUT("/proxies/:id/targets", s.updateProxyTargets)
+		api.PUT("/proxies/:id/condition", s.updateProxyCondition)
 		api.PUT("/proxies/:id/url", s.updateProxyURL)
 		api.PUT("/proxies/:id/cookies", s.updateProxySavingCookies)
 		api.PUT("/proxies/:id/query-forwarding", s.updateProxyQueryForwarding)
 		api.PUT("/proxies/:id/cookies-forwarding", s.updateProxyCookiesForwarding)
+		api.PUT("/proxies/:id/bucketing", s.updateProxyBucketing)
+		api.POST("/proxies/:id/reshuffle", s.reshuffleProxy)
+		api.GET("/proxies/:id/assignment", s.getProxyAssignment)
+		api.GET("/proxies/:id/goals", s.getProxyGoals)
+		api.PUT("/proxies/:id/goals", s.updateProxyGoals)
+		api.PUT("/proxies/:id/control", s.updateProxyControl)
+		api.GET("/proxies/:id/health", s.getProxyHealth)
+		api.PUT("/proxies/:id/health-check", s.updateProxyHealthCheck)
+		api.GET("/proxies/:id/schedule", s.getProxySchedule)
+		api.PUT("/proxies/:id/schedule", s.updateProxySchedule)
+		api.DELETE("/proxies/:id/schedule", s.deleteProxySchedule)
+		api.PUT("/proxies/:id/redirect", s.updateProxyRedirect)
+		api.GET("/proxies/:id/guardrails", s.getProxyGuardrails)
+		api.PUT("/proxies/:id/guardrails", s.updateProxyGuardrails)
+		api.POST("/proxies/:id/guardrails/trips/:trip_id/revert", s.revertGuardrailTrip)
+		api.GET("/proxies/:id/exclusion", s.getProxyExclusion)
+		api.PUT("/proxies/:id/exclusion", s.updateProxyExclusion)
+		api.PUT("/proxies/:id/allocation", s.updateProxyAllocation)
+		api.GET("/proxies/:id/preview-links", s.getProxyPreviewLinks)
+		api.POST("/proxies/:id/start", s.transitionProxy(models.LifecycleActionStart))
+		api.POST("/proxies/:id/pause", s.transitionProxy(models.LifecycleActionPause))
+		api.POST("/proxies/:id/resume", s.transitionProxy(models.LifecycleActionResume))
+		api.POST("/proxies/:id/complete", s.transitionProxy(models.LifecycleActionComplete))
+		api.POST("/proxies/:id/archive", s.transitionProxy(models.LifecycleActionArchive))
 
 		// Tag management
 		api.GET("/tags", s.getAllTags)
 		api.GET("/proxies/by-tags", s.getProxiesByTags)
 		api.PUT("/proxies/:id/tags", s.updateProxyTags)
 
+		// TLS certificates of the proxy listeners
+		api.GET("/certificates", s.listCertificates)
+		api.PUT("/certificates/:host", s.putCertificate)
+		api.DELETE("/certificates/:host", s.deleteCertificate)
+
+		// Service instances and the proxy config versions they run
+		api.GET("/instances", s.listInstances)
+
 		// Stats endpoints
 		api.GET("/stats", s.getStats)
 		api.GET("/stats/:proxy_id", s.getProxyStats)
+		api.GET("/stats/:proxy_id/analysis", s.getProxyAnalysis)
 	}
 
 	// Metrics
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+type ScheduleRequest struct {
+	StartAt      *time.Time         `json:"start_at"`
+	StartWeights map[string]float64 `json:"start_weights"`
+	Ramp         []models.RampStep  `json:"ramp"`
+	EndAt        *time.Time         `json:"end_at"`
+	EndAction    *models.EndAction  `json:"end_action"` // all traffic to the control target by default
+}
+
+type ScheduleResponse struct {
+	Schedule *models.Schedule       `json:"schedule"`
+	Steps    []ScheduleStepResponse `json:"steps"`
+}
+
+type ScheduleStepResponse struct {
+	models.ScheduleStep
+	Applied bool `json:"applied"`
+}
+
+func (s *Server) getProxySchedule(c *gin.Context) {
+	currentProxy, err := s.getCurrentProxy(c, c.Param("id"))
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, newScheduleResponse(currentProxy.Settings.Schedule))
+}
+
+// updateProxySchedule replaces the schedule of a proxy. Steps whose time has
+// passed are not replayed one by one: the latest of them is applied right away.
+func (s *Server) updateProxySchedule(c *gin.Context) {
+	proxyID := c.Param("id")
+	var req ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentProxy, err := s.getCurrentProxy(c, proxyID)
+	if err != nil {
+		return // Error already sent to client
+	}
+
+	schedule, err := convertToScheduleModel(currentProxy, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.saveProxySchedule(c, proxyID, schedule)
+}
+
+func (s *Server) deleteProxySchedule(c *gin.Context) {
+	proxyID := c.Param("id")
+	if _, err := s.getCurrentProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	s.saveProxySchedule(c, proxyID, nil)
+}
+
+func (s *Server) saveProxySchedule(c *gin.Context, proxyID string, schedule *models.Schedule) {
+	if err := s.storage.UpdateProxySchedule(c.Request.Context(), proxyID, schedule, s.getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.reloadProxy(c, proxyID); err != nil {
+		return // Error already sent to client
+	}
+
+	c.JSON(http.StatusOK, newScheduleResponse(schedule))
+}
+
+func newScheduleResponse(schedule *models.Schedule) ScheduleResponse {
+	response := ScheduleResponse{Schedule: schedule, Steps: []ScheduleStepResponse{}}
+	if schedule == nil {
+		return response
+	}
+	for i, step := range schedule.Steps() {
+		response.Steps = append(response.Steps, ScheduleStepResponse{ScheduleStep: step, Applied: i < schedule.Applied})
+	}
+	return response
+}
+
+func convertToScheduleModel(currentProxy *models.Proxy, req ScheduleRequest) (*models.Schedule, error) {
+	schedule := &models.Schedule{
+		StartAt:      req.StartAt,
+		StartWeights: req.StartWeights,
+		Ramp:         req.Ramp,
+		EndAt:        req.EndAt,
+		EndAction:    req.EndAction,
+	}
+
+	if schedule.StartAt != nil && len(schedule.StartWeights) == 0 {
+		return nil, errors.New("start_weights are required with start_at")
+	}
+	if schedule.StartAt == nil && len(schedule.StartWeights) > 0 {
+		return nil, errors.New("start_at is required with start_weights")
+	}
+
+	if schedule.EndAction != nil && schedule.EndAt == nil {
+		return nil, errors.New("end_at is required with end_action")
+	}
+	if schedule.EndAt != nil {
+		if schedule.EndAction == nil {
+			schedule.EndAction = &models.EndAction{}
+		}
+		if schedule.EndAction.Type == "" {
+			schedule.EndAction.Type = models.EndActionSendAll
+		}
+		if !schedule.EndAction.Type.IsValid() {
+			return nil, errors.New("invalid end_action type")
+		}
+		if schedule.EndAction.TargetID == "" {
+			schedule.EndAction.TargetID = currentProxy.Settings.ControlTarget(currentProxy.Targets)
+		}
+		if findTarget(currentProxy.Targets, schedule.EndAction.TargetID) == nil {
+			return nil, errors.New("end_action target not found")
+		}
+	}
+
+	steps := schedule.Steps()
+	if len(steps) == 0 {
+		return nil, errors.New("schedule has no steps")
+	}
+	for i, step := range steps {
+		if i > 0 && !step.At.After(steps[i-1].At) {
+			return nil, fmt.Errorf("step %s must come after step %s", step.Name, steps[i-1].Name)
+		}
+
+		var total float64
+		for targetID, weight := range step.Weights {
+			if findTarget(currentProxy.Targets, targetID) == nil {
+				return nil, fmt.Errorf("target %s of step %s not found", targetID, step.Name)
+			}
+			if weight < 0 {
+				return nil, fmt.Errorf("weights of step %s must be non-negative", step.Name)
+			}
+			total += weight
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("step %s sends no traffic", step.Name)
+		}
+	}
+	return schedule, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -95,12 +96,24 @@ func (s *Server) getProxy(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, proxy)
+	c.JSON(http.StatusOK, ProxyResponse{Proxy: proxy, Health: proxy.Health()})
 }
 
+// deleteProxy deletes a proxy, the instances stop serving it and its listen
+// URLs are freed. Its history and statistics are kept.
 func (s *Server) deleteProxy(c *gin.Context) {
 	id := c.Param("id")
-	if err := s.supervisor.DeleteProxy(c.Request.Context(), id); err != nil {
+	version, err := s.storage.DeleteProxy(c.Request.Context(), id, s.getUserID(c))
+	if err != nil {
+		if errors.Is(err, storage.ErrProxyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.supervisor.DeleteProxy(c.Request.Context(), id, version); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -14,8 +14,10 @@ type StatsResponse struct {
 	TotalErrors   int64                            `json:"total_errors"`
 	UniqueUsers   int64                            `json:"unique_users"`
 	TargetStats   map[string][]storage.TargetStats `json:"target_stats,omitempty"`
-	StartTime     time.Time                        `json:"start_time"`
-	EndTime       time.Time                        `json:"end_time"`
+	// Conversions per target ID and goal ID
+	Conversions map[string]map[string]*storage.ConversionStats `json:"conversions,omitempty"`
+	StartTime   time.Time                                      `json:"start_time"`
+	EndTime     time.Time                                      `json:"end_time"`
 }
 
 func (s *Server) getStats(c *gin.Context) {
@@ -104,11 +106,25 @@ func (s *Server) getProxyStats(c *gin.Context) {
 		return
 	}
 
+	// Name the goals, goals removed since keep their ID only
+	if p, err := s.storage.GetProxy(c.Request.Context(), proxyID); err == nil {
+		goalNames := make(map[string]string, len(p.Settings.Goals))
+		for _, goal := range p.Settings.Goals {
+			goalNames[goal.ID] = goal.Name
+		}
+		for _, goals := range proxyStats.Conversions {
+			for goalID, conversions := range goals {
+				conversions.GoalName = goalNames[goalID]
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, StatsResponse{
 		TotalRequests: proxyStats.TotalRequests,
 		TotalErrors:   proxyStats.TotalErrors,
 		UniqueUsers:   proxyStats.TotalUniqueUsers,
 		TargetStats:   proxyStats.TargetStats,
+		Conversions:   proxyStats.Conversions,
 		StartTime:     start,
 		EndTime:       end,
 	})
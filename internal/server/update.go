@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,6 +15,7 @@ import (
 
 type UpdateTargetsRequest struct {
 	Targets []struct {
+		ID       string  `json:"id,omitempty"` // Keep the ID of an existing target so its visitors stay on it
 		URL      string  `json:"url" binding:"required"`
 		Weight   float64 `json:"weight" binding:"required,min=0,max=1"`
 		IsActive bool    `json:"is_active"`
@@ -22,7 +24,8 @@ type UpdateTargetsRequest struct {
 }
 
 type UpdateSavingCookiesRequest struct {
-	SavingCookiesFlg bool `json:"saving_cookies_flg"`
+	SavingCookiesFlg bool                         `json:"saving_cookies_flg"`
+	StickyCookie     *models.StickyCookieSettings `json:"sticky_cookie,omitempty"`
 }
 
 type UpdateQueryForwardingRequest struct {
@@ -46,14 +49,18 @@ func (s *Server) updateProxyTargets(c *gin.Context) {
 		return // Error already sent to client
 	}
 
-	targets := s.convertToTargetModels(proxyID, req)
-	condition := s.convertToConditionModels(targets, req)
+	targets := s.convertToTargetModels(proxyID, currentProxy, req)
+	condition, err := s.convertToConditionModels(currentProxy, targets, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	if err := s.executeTransaction(c, proxyID, currentProxy, targets, condition); err != nil {
 		return // Error already sent to client
 	}
 
-	if err := s.updateSupervisor(c, proxyID, currentProxy, targets, condition); err != nil {
+	if err := s.reloadProxy(c, proxyID); err != nil {
 		return // Error already sent to client
 	}
 
@@ -76,12 +83,27 @@ func (s *Server) updateProxySavingCookies(c *gin.Context) {
 		}
 	}
 
+	if req.StickyCookie != nil {
+		if err := validateStickyCookie(req.StickyCookie); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Update saving cookies flag in storage
 	if err := s.storage.UpdateProxySavingCookies(c.Request.Context(), proxyID, req.SavingCookiesFlg, userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Update sticky cookie attributes if provided
+	if req.StickyCookie != nil {
+		if err := s.storage.UpdateProxyStickyCookie(c.Request.Context(), proxyID, req.StickyCookie, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Update supervisor
 	cfg, err := s.storage.GetProxyConfig(c.Request.Context(), proxyID)
 	if err != nil {
@@ -146,8 +168,7 @@ func (s *Server) updateProxyCookiesForwarding(c *gin.Context) {
 	userID := s.getUserID(c)
 
 	// Update cookies forwarding flag in storage
-	// TODO: Implement UpdateProxyCookiesForwarding in storage package
-	if err := s.storage.UpdateProxyQueryForwarding(c.Request.Context(), proxyID, req.CookiesForwardingFlg, userID); err != nil {
+	if err := s.storage.UpdateProxyCookiesForwarding(c.Request.Context(), proxyID, req.CookiesForwardingFlg, userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -167,6 +188,29 @@ func (s *Server) updateProxyCookiesForwarding(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func validateStickyCookie(settings *models.StickyCookieSettings) error {
+	if settings.Name != "" {
+		if err := (&http.Cookie{Name: settings.Name, Value: "x"}).Valid(); err != nil {
+			return fmt.Errorf("invalid sticky cookie name: %w", err)
+		}
+	}
+	if settings.MaxAge < 0 {
+		return errors.New("sticky cookie max_age must be non-negative")
+	}
+
+	switch strings.ToLower(settings.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		// Browsers reject SameSite=None cookies without Secure
+		if !settings.Secure {
+			return errors.New("same_site none requires secure sticky cookie")
+		}
+	default:
+		return errors.New("sticky cookie same_site must be one of lax, strict, none")
+	}
+	return nil
+}
+
 // Request parsing and validation
 func (s *Server) parseAndValidateRequest(c *gin.Context) (UpdateTargetsRequest, error) {
 	var req UpdateTargetsRequest
@@ -175,42 +219,43 @@ func (s *Server) parseAndValidateRequest(c *gin.Context) (UpdateTargetsRequest,
 		return req, err
 	}
 
-	if err := s.validateCondition(c, &req); err != nil {
-		return req, err
+	for i, t := range req.Targets {
+		if err := proxy.ValidateTargetURL(t.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("targets[%d]: %v", i, err)})
+			return req, err
+		}
 	}
 
 	return req, nil
 }
 
-func (s *Server) validateCondition(c *gin.Context, req *UpdateTargetsRequest) error {
-	if req.Condition == nil {
-		return nil
-	}
-
-	if err := s.validateConditionFields(req.Condition); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return err
-	}
-
-	// todo
-	//if err := s.validateConditionTargets(req); err != nil {
-	//	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	//	return err
-	//}
-
-	return nil
-}
-
-func (s *Server) validateConditionFields(condition *RouteCondition) error {
+func (s *Server) validateConditionFields(condition *RouteCondition, targetCount int) error {
 	if !models.ConditionType(condition.Type).IsValid() {
 		return errors.New("invalid condition type")
 	}
 
+	// Values are matched to targets by position
+	if len(condition.Values) > targetCount {
+		return errors.New("condition has more values than targets")
+	}
+
 	// For expression type, validate that we have either an Expr field or Values map
+	// and that the expressions compile: Expr must return a target ID, Values booleans
 	if models.ConditionType(condition.Type) == models.ConditionTypeExpr {
 		if condition.Expr == "" && len(condition.Values) == 0 {
 			return errors.New("expr condition requires either Expr field or Values map with expressions")
 		}
+		if condition.Expr != "" {
+			if err := proxy.ValidateTargetExpr(condition.Expr); err != nil {
+				return fmt.Errorf("invalid expr: %w", err)
+			}
+			return nil
+		}
+		for i, expression := range condition.Values {
+			if err := proxy.ValidateMatchExpr(expression); err != nil {
+				return fmt.Errorf("invalid expression in values[%d]: %w", i, err)
+			}
+		}
 		return nil
 	}
 
@@ -221,25 +266,9 @@ func (s *Server) validateConditionFields(condition *RouteCondition) error {
 	if len(condition.Values) == 0 {
 		return errors.New("values are required for non-expr conditions")
 	}
-	return nil
-}
-
-func (s *Server) validateConditionTargets(req *UpdateTargetsRequest) error {
-	targetIDs := make(map[string]bool)
-	for _, target := range req.Targets {
-		targetIDs[target.URL] = true // Assuming URL is unique we have no id of new target here
-	}
-
-	for _, targetID := range req.Condition.Values {
-		if !targetIDs[targetID] {
-			return fmt.Errorf("target ID %s in condition not found in targets", targetID)
-		}
-	}
-
-	if req.Condition.Default != "" && !targetIDs[req.Condition.Default] {
-		return errors.New("default target ID not found in targets")
+	if models.ConditionType(condition.Type) == models.ConditionTypeGeo && !proxy.IsValidGeoField(condition.ParamName) {
+		return errors.New("param_name must be country, region or city for geo conditions")
 	}
-
 	return nil
 }
 
@@ -254,11 +283,44 @@ func (s *Server) getCurrentProxy(c *gin.Context, proxyID string) (*models.Proxy,
 	return p, nil
 }
 
-func (s *Server) convertToTargetModels(proxyID string, req UpdateTargetsRequest) []models.Target {
+// reloadProxy applies the stored proxy config to the supervisor
+func (s *Server) reloadProxy(c *gin.Context, proxyID string) error {
+	cfg, err := s.storage.GetProxyConfig(c.Request.Context(), proxyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to get updated proxy config: %v", err)})
+		return err
+	}
+
+	if err := s.supervisor.UpdateProxy(c.Request.Context(), cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to update proxy in supervisor: %v", err)})
+		return err
+	}
+	return nil
+}
+
+func (s *Server) convertToTargetModels(proxyID string, currentProxy *models.Proxy, req UpdateTargetsRequest) []models.Target {
+	// Existing targets keep their IDs, otherwise every update would reassign
+	// all visitors: bucketing and sticky cookies are based on target IDs
+	existingIDs := make(map[string]string, len(currentProxy.Targets))
+	for _, t := range currentProxy.Targets {
+		existingIDs[t.ID] = t.ID
+		existingIDs[t.URL] = t.ID
+	}
+
+	used := make(map[string]bool, len(req.Targets))
 	targets := make([]models.Target, len(req.Targets))
 	for i, t := range req.Targets {
+		id, ok := existingIDs[t.ID]
+		if !ok || t.ID == "" {
+			id, ok = existingIDs[t.URL]
+		}
+		if !ok || used[id] {
+			id = uuid.New().String()
+		}
+		used[id] = true
+
 		targets[i] = models.Target{
-			ID:       uuid.New().String(),
+			ID:       id,
 			ProxyID:  proxyID,
 			URL:      t.URL,
 			Weight:   t.Weight,
@@ -268,22 +330,14 @@ func (s *Server) convertToTargetModels(proxyID string, req UpdateTargetsRequest)
 	return targets
 }
 
-func (s *Server) convertToConditionModels(targets []models.Target, req UpdateTargetsRequest) *models.RouteCondition {
-	if req.Condition == nil || req.Condition.Type == "" {
-		return nil
-	}
-
-	conditionValues := make(map[string]string, len(req.Condition.Values))
-	for i, v := range req.Condition.Values {
-		conditionValues[targets[i].ID] = v
-	}
-	return &models.RouteCondition{
-		Type:      models.ConditionType(req.Condition.Type),
-		ParamName: req.Condition.ParamName,
-		Values:    conditionValues,
-		Default:   req.Condition.Default,
-		Expr:      req.Condition.Expr,
+// convertToConditionModels converts the condition of the request, the current
+// condition is kept when the request has none
+func (s *Server) convertToConditionModels(currentProxy *models.Proxy, targets []models.Target,
+	req UpdateTargetsRequest) (*models.RouteCondition, error) {
+	if req.Condition == nil {
+		return currentProxy.Condition, nil
 	}
+	return s.convertCondition(req.Condition, targets)
 }
 
 // Get user ID from request context
@@ -323,64 +377,3 @@ func (s *Server) executeTransaction(c *gin.Context, proxyID string,
 
 	return nil
 }
-
-// Supervisor update
-func (s *Server) updateSupervisor(c *gin.Context, proxyID string, currentProxy *models.Proxy,
-	targets []models.Target, condition *models.RouteCondition) error {
-
-	config := s.buildProxyConfig(proxyID, currentProxy, targets, condition)
-
-	if err := s.supervisor.UpdateProxy(c.Request.Context(), config); err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": fmt.Sprintf("failed to update proxy targets: %v", err)})
-		return err
-	}
-
-	return nil
-}
-
-func (s *Server) buildProxyConfig(proxyID string, currentProxy *models.Proxy,
-	targets []models.Target, condition *models.RouteCondition) proxy.Config {
-
-	// Convert ListenURLs from models.ListenURL to proxy.ListenURL
-	listenURLs := make([]proxy.ListenURL, len(currentProxy.ListenURLs))
-	for i, url := range currentProxy.ListenURLs {
-		listenURLs[i] = proxy.ListenURL{
-			ID:        url.ID,
-			ListenURL: url.ListenURL,
-			PathKey:   url.PathKey,
-		}
-	}
-
-	config := proxy.Config{
-		ID:         proxyID,
-		ListenURLs: listenURLs,
-		Mode:       models.ProxyMode(currentProxy.Mode),
-		Targets:    s.convertToConfigTargets(targets),
-	}
-
-	if condition != nil {
-		config.Condition = &proxy.Condition{
-			Type:      condition.Type,
-			ParamName: condition.ParamName,
-			Values:    condition.Values,
-			Default:   condition.Default,
-			Expr:      condition.Expr,
-		}
-	}
-
-	return config
-}
-
-func (s *Server) convertToConfigTargets(targets []models.Target) []proxy.Target {
-	configTargets := make([]proxy.Target, len(targets))
-	for i, t := range targets {
-		configTargets[i] = proxy.Target{
-			ID:       t.ID,
-			URL:      t.URL,
-			Weight:   t.Weight,
-			IsActive: t.IsActive,
-		}
-	}
-	return configTargets
-}
@@ -0,0 +1,281 @@
+// Package spool keeps messages on local disk until they are delivered
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	recordExt = ".msg"
+	tempExt   = ".tmp"
+)
+
+// ErrFull is returned when appending would exceed the size cap of the spool
+var ErrFull = errors.New("spool is full")
+
+var (
+	spoolDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ab_test_spool_depth",
+			Help: "Number of messages waiting in the spool",
+		},
+		[]string{"spool"},
+	)
+	spoolBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ab_test_spool_bytes",
+			Help: "Size of the messages waiting in the spool",
+		},
+		[]string{"spool"},
+	)
+	spoolDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ab_test_spool_dropped_total",
+			Help: "Total number of messages dropped by the spool",
+		},
+		[]string{"spool", "reason"},
+	)
+)
+
+// Record is a message in the spool
+type Record struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Spool is a write-ahead queue of messages on local disk, one file per
+// message named by its sequence number. Appended messages survive restarts
+// until they are removed, Peek returns them in the order they were appended.
+type Spool struct {
+	name     string
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	next  uint64           // sequence number of the next record
+	queue []uint64         // sequence numbers in order, including removed ones
+	sizes map[uint64]int64 // sizes of the records on disk, by sequence number
+	size  int64
+
+	ready chan struct{}
+}
+
+// Open opens the spool in dir, creating dir if needed, with the records left
+// by a previous process. maxBytes caps the size of the records, zero is no cap.
+func Open(name, dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s := &Spool{
+		name:     name,
+		dir:      dir,
+		maxBytes: maxBytes,
+		next:     1,
+		sizes:    make(map[uint64]int64),
+		ready:    make(chan struct{}, 1),
+	}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if strings.HasSuffix(fileName, tempExt) {
+			// Interrupted while being written, it was never appended
+			if err := os.Remove(filepath.Join(dir, fileName)); err != nil {
+				log.Printf("Failed to remove partial spool record %s: %v", fileName, err)
+			}
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(fileName, recordExt), 10, 64)
+		if err != nil || !strings.HasSuffix(fileName, recordExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spool record %s: %w", fileName, err)
+		}
+		s.queue = append(s.queue, seq)
+		s.sizes[seq] = info.Size()
+		s.size += info.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.queue, func(i, j int) bool { return s.queue[i] < s.queue[j] })
+	s.updateMetrics()
+	if len(s.sizes) > 0 {
+		s.signal()
+	}
+	return s, nil
+}
+
+func (s *Spool) path(seq uint64, ext string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+// Append writes messages to disk, in order. It returns ErrFull, dropping the
+// messages not written, when they don't fit under the size cap.
+func (s *Spool) Append(messages ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.signal()
+	defer s.updateMetrics()
+
+	var err error
+	for i, data := range messages {
+		if s.maxBytes > 0 && s.size+int64(len(data)) > s.maxBytes {
+			spoolDropped.WithLabelValues(s.name, "full").Add(float64(len(messages) - i))
+			err = ErrFull
+			break
+		}
+		if err = s.write(s.next, data); err != nil {
+			spoolDropped.WithLabelValues(s.name, "write_error").Add(float64(len(messages) - i))
+			break
+		}
+		s.queue = append(s.queue, s.next)
+		s.sizes[s.next] = int64(len(data))
+		s.size += int64(len(data))
+		s.next++
+	}
+
+	// Make the renames of the records durable
+	if dirErr := syncDir(s.dir); dirErr != nil && err == nil {
+		err = dirErr
+	}
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open spool directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool directory: %w", err)
+	}
+	return nil
+}
+
+// write writes a record to a temporary file renamed once synced, so that a
+// crash never leaves a partial record
+func (s *Spool) write(seq uint64, data []byte) error {
+	tmp := s.path(seq, tempExt)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create spool record: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to sync spool record: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to close spool record: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(seq, recordExt)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to commit spool record: %w", err)
+	}
+	return nil
+}
+
+// Peek returns up to n of the oldest records, oldest first. Records that
+// can't be read are dropped.
+func (s *Spool) Peek(n int) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.updateMetrics()
+
+	// Forget the records removed from the head of the queue
+	for len(s.queue) > 0 {
+		if _, ok := s.sizes[s.queue[0]]; ok {
+			break
+		}
+		s.queue = s.queue[1:]
+	}
+
+	records := make([]Record, 0, n)
+	for _, seq := range s.queue {
+		if len(records) == n {
+			break
+		}
+		if _, ok := s.sizes[seq]; !ok {
+			continue
+		}
+		data, err := os.ReadFile(s.path(seq, recordExt))
+		if err != nil {
+			log.Printf("Dropping unreadable spool record %d: %v", seq, err)
+			spoolDropped.WithLabelValues(s.name, "read_error").Inc()
+			s.remove(seq)
+			continue
+		}
+		records = append(records, Record{Seq: seq, Data: data})
+	}
+	return records
+}
+
+// Remove deletes delivered records
+func (s *Spool) Remove(records ...Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastErr error
+	for _, record := range records {
+		if err := s.remove(record.Seq); err != nil {
+			lastErr = err
+		}
+	}
+	s.updateMetrics()
+	return lastErr
+}
+
+func (s *Spool) remove(seq uint64) error {
+	size, ok := s.sizes[seq]
+	if !ok {
+		return nil
+	}
+	delete(s.sizes, seq)
+	s.size -= size
+	if err := os.Remove(s.path(seq, recordExt)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Forgotten anyway, the record is sent again after a restart
+		return fmt.Errorf("failed to remove spool record %d: %w", seq, err)
+	}
+	return nil
+}
+
+// Ready is signaled when records were appended
+func (s *Spool) Ready() <-chan struct{} {
+	return s.ready
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) updateMetrics() {
+	spoolDepth.WithLabelValues(s.name).Set(float64(len(s.sizes)))
+	spoolBytes.WithLabelValues(s.name).Set(float64(s.size))
+}
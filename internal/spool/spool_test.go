@@ -0,0 +1,198 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func open(t *testing.T, dir string, maxBytes int64) *Spool {
+	t.Helper()
+	s, err := Open(t.Name(), dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func appendAll(t *testing.T, s *Spool, messages ...string) {
+	t.Helper()
+	for _, message := range messages {
+		if err := s.Append([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// peekData returns the data of up to n of the oldest records
+func peekData(s *Spool, n int) []string {
+	var data []string
+	for _, record := range s.Peek(n) {
+		data = append(data, string(record.Data))
+	}
+	return data
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 0)
+	appendAll(t, s, "a", "b", "c")
+	records := s.Peek(3)
+	if err := s.Remove(records[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := open(t, dir, 0)
+	if got, want := peekData(reopened, 10), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reopened records = %v, want %v", got, want)
+	}
+	if reopened.size != 2 {
+		t.Errorf("size = %d, want 2", reopened.size)
+	}
+
+	// Sequence numbers continue after the highest one left on disk, the
+	// removed one is not reused
+	appendAll(t, reopened, "d")
+	got := reopened.Peek(10)
+	if seq := got[len(got)-1].Seq; seq != records[1].Seq+1 {
+		t.Errorf("sequence number after reopen = %d, want %d", seq, records[1].Seq+1)
+	}
+	if data := peekData(reopened, 10); !reflect.DeepEqual(data, []string{"a", "b", "d"}) {
+		t.Errorf("records = %v, want [a b d]", data)
+	}
+}
+
+func TestReopenOrder(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 0)
+	var want []string
+	for i := 0; i < 25; i++ {
+		want = append(want, strconv.Itoa(i))
+	}
+	appendAll(t, s, want...)
+
+	// Sequence numbers are sorted as numbers, not by the order of the files
+	if got := peekData(open(t, dir, 0), 100); !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened records = %v, want %v", got, want)
+	}
+}
+
+func TestOpenRemovesTemp(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 0)
+	appendAll(t, s, "a")
+
+	tmp := s.path(2, tempExt)
+	if err := os.WriteFile(tmp, []byte("partial"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "unrelated"), []byte("x"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := open(t, dir, 0)
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("partial record still exists: %v", err)
+	}
+	if got := peekData(reopened, 10); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("records = %v, want [a]", got)
+	}
+	if reopened.next != 2 {
+		t.Errorf("next = %d, want 2", reopened.next)
+	}
+}
+
+func TestAppendFull(t *testing.T) {
+	s := open(t, t.TempDir(), 5)
+	dropped := spoolDropped.WithLabelValues(s.name, "full")
+
+	if err := s.Append([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	// The first message fits, the next two are dropped
+	if err := s.Append([]byte("d"), []byte("ef"), []byte("g")); err != ErrFull {
+		t.Fatalf("Append() = %v, want ErrFull", err)
+	}
+	if got := testutil.ToFloat64(dropped); got != 2 {
+		t.Errorf("dropped = %v, want 2", got)
+	}
+	if got := peekData(s, 10); !reflect.DeepEqual(got, []string{"abc", "d"}) {
+		t.Errorf("records = %v, want [abc d]", got)
+	}
+
+	// Removing records makes room again
+	if err := s.Remove(s.Peek(1)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append([]byte("ef")); err != nil {
+		t.Errorf("Append() after remove = %v", err)
+	}
+	if got := testutil.ToFloat64(dropped); got != 2 {
+		t.Errorf("dropped = %v, want 2", got)
+	}
+}
+
+func TestPeekRemove(t *testing.T) {
+	s := open(t, t.TempDir(), 0)
+	appendAll(t, s, "a", "b", "c", "d", "e")
+
+	records := s.Peek(5)
+	// Removed in the middle, e.g. the messages Kafka accepted out of a batch
+	if err := s.Remove(records[1], records[3]); err != nil {
+		t.Fatal(err)
+	}
+	if got := peekData(s, 2); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Peek(2) = %v, want [a c]", got)
+	}
+	if got := peekData(s, 10); !reflect.DeepEqual(got, []string{"a", "c", "e"}) {
+		t.Errorf("Peek(10) = %v, want [a c e]", got)
+	}
+
+	// Removing twice changes nothing
+	if err := s.Remove(records[1]); err != nil {
+		t.Fatal(err)
+	}
+	if s.size != 3 {
+		t.Errorf("size = %d, want 3", s.size)
+	}
+
+	if err := s.Remove(records[0], records[2]); err != nil {
+		t.Fatal(err)
+	}
+	if got := peekData(s, 10); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Errorf("Peek(10) = %v, want [e]", got)
+	}
+	if len(s.queue) != 1 {
+		t.Errorf("queue keeps %d sequence numbers, want 1", len(s.queue))
+	}
+
+	if err := s.Remove(records[4]); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Peek(10); len(got) != 0 {
+		t.Errorf("Peek(10) of an empty spool = %v", got)
+	}
+	if entries, _ := os.ReadDir(s.dir); len(entries) != 0 {
+		t.Errorf("%d files left in the spool directory", len(entries))
+	}
+}
+
+func TestPeekDropsUnreadable(t *testing.T) {
+	s := open(t, t.TempDir(), 0)
+	appendAll(t, s, "a", "b", "c")
+	records := s.Peek(3)
+	if err := os.Remove(s.path(records[1].Seq, recordExt)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := peekData(s, 10); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Peek(10) = %v, want [a c]", got)
+	}
+	if got := testutil.ToFloat64(spoolDropped.WithLabelValues(s.name, "read_error")); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+}
@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/go-redis/redis/v8"
+
 	"github.com/ab-testing-service/internal/models"
 	"github.com/ab-testing-service/internal/proxy"
 )
@@ -46,6 +49,10 @@ func (s *Storage) InvalidateProxyCache(ctx context.Context, proxyID string) erro
 
 func (s *Storage) GetProxyConfig(ctx context.Context, proxyID string) (proxy.Config, error) {
 	data, err := s.Redis.Get(ctx, fmt.Sprintf("proxy:%s", proxyID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// Cache was invalidated by an update, fallback to PostgreSQL
+		return s.LoadProxyConfig(ctx, proxyID)
+	}
 	if err != nil {
 		return proxy.Config{}, err
 	}
@@ -83,12 +90,22 @@ func (s *Storage) GetProxy(ctx context.Context, id string) (*models.Proxy, error
 	}
 
 	proxyModel := models.Proxy{
-		ID:        p.ID,
-		Mode:      models.ProxyMode(p.Mode),
-		Condition: &conditionJSON,
-		Tags:      p.Tags,
-		CreatedAt: p.CreatedAt.Time,
-		UpdatedAt: p.UpdatedAt.Time,
+		ID:                   p.ID,
+		Mode:                 models.ProxyMode(p.Mode),
+		Condition:            &conditionJSON,
+		Tags:                 p.Tags,
+		SavingCookiesFlg:     p.SavingCookiesFlg,
+		QueryForwardingFlg:   p.QueryForwardingFlg,
+		CookiesForwardingFlg: p.CookiesForwardingFlg,
+		State:                models.ProxyState(p.State),
+		CreatedAt:            p.CreatedAt.Time,
+		UpdatedAt:            p.UpdatedAt.Time,
+	}
+
+	if len(p.Settings) > 0 {
+		if err := json.Unmarshal(p.Settings, &proxyModel.Settings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal settings: %w", err)
+		}
 	}
 
 	// Get listen URLs
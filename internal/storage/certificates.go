@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+var ErrCertificateNotFound = errors.New("certificate not found")
+
+// GetCertificates returns the certificates uploaded through the API
+func (s *Storage) GetCertificates(ctx context.Context) ([]models.Certificate, error) {
+	rows, err := s.q.GetCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := make([]models.Certificate, 0, len(rows))
+	for _, row := range rows {
+		certificates = append(certificates, models.Certificate{
+			Host:         row.Host,
+			CertPEM:      row.CertPem,
+			EncryptedKey: row.EncryptedKey,
+			NotAfter:     row.NotAfter.Time,
+			CreatedBy:    row.CreatedBy,
+			CreatedAt:    row.CreatedAt.Time,
+			UpdatedAt:    row.UpdatedAt.Time,
+		})
+	}
+	return certificates, nil
+}
+
+// SaveCertificate creates or replaces the certificate of a host, its key must
+// be encrypted already
+func (s *Storage) SaveCertificate(ctx context.Context, cert *models.Certificate) error {
+	return s.q.UpsertCertificate(ctx, &UpsertCertificateParams{
+		Host:         cert.Host,
+		CertPem:      cert.CertPEM,
+		EncryptedKey: cert.EncryptedKey,
+		NotAfter:     pgtype.Timestamptz{Time: cert.NotAfter, Valid: true},
+		CreatedBy:    cert.CreatedBy,
+	})
+}
+
+func (s *Storage) DeleteCertificate(ctx context.Context, host string) error {
+	deleted, err := s.q.DeleteCertificate(ctx, host)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrCertificateNotFound
+	}
+	return nil
+}
@@ -21,6 +21,10 @@ func (s *Storage) CreateProxy(ctx context.Context, proxy *models.Proxy) error {
 			proxy.ID = uuid.New().String()
 		}
 
+		if proxy.State == "" {
+			proxy.State = models.ProxyStateDraft
+		}
+
 		// Marshal condition if present
 		var conditionJSON []byte = nil
 		if proxy.Condition != nil {
@@ -31,18 +35,26 @@ func (s *Storage) CreateProxy(ctx context.Context, proxy *models.Proxy) error {
 			conditionJSON = bytes
 		}
 
+		settingsJSON, err := json.Marshal(proxy.Settings)
+		if err != nil {
+			return fmt.Errorf("failed to marshal settings: %w", err)
+		}
+
 		// Create proxy record
 		now := time.Now()
 		err = repo.CreateProxy(ctx, &CreateProxyParams{
-			ID:                 proxy.ID,
-			Name:               &proxy.Name,
-			Mode:               string(proxy.Mode),
-			Condition:          conditionJSON,
-			Tags:               proxy.Tags,
-			SavingCookiesFlg:   proxy.SavingCookiesFlg,
-			QueryForwardingFlg: proxy.QueryForwardingFlg,
-			CreatedAt:          pgtype.Timestamptz{Time: now},
-			UpdatedAt:          pgtype.Timestamptz{Time: now},
+			ID:                   proxy.ID,
+			Name:                 &proxy.Name,
+			Mode:                 string(proxy.Mode),
+			Condition:            conditionJSON,
+			Tags:                 proxy.Tags,
+			SavingCookiesFlg:     proxy.SavingCookiesFlg,
+			QueryForwardingFlg:   proxy.QueryForwardingFlg,
+			CookiesForwardingFlg: proxy.CookiesForwardingFlg,
+			Settings:             settingsJSON,
+			State:                string(proxy.State),
+			CreatedAt:            pgtype.Timestamptz{Time: now},
+			UpdatedAt:            pgtype.Timestamptz{Time: now},
 		})
 
 		if err != nil {
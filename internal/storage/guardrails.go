@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// maxGuardrailTrips bounds the trips kept in the proxy settings, the change
+// history keeps all of them
+const maxGuardrailTrips = 100
+
+var (
+	ErrTripNotFound = errors.New("guardrail trip not found")
+	ErrTripReverted = errors.New("guardrail trip already reverted")
+)
+
+func (s *Storage) UpdateProxyGuardrails(ctx context.Context, proxyID string, guardrails []models.Guardrail,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "guardrails", currentProxy.Settings.Guardrails, guardrails,
+		models.ChangeTypeGuardrailsUpdate, createdBy)
+}
+
+// TripGuardrail rolls back a target that tripped a guardrail and records the
+// trip. It returns nil when the guardrail no longer exists, was re-armed after
+// the evaluation started or already has an open trip for the target, e.g.
+// recorded by another instance.
+func (s *Storage) TripGuardrail(ctx context.Context, proxyID string, guardrail models.Guardrail, targetID string,
+	reason string, value float64) (*models.GuardrailTrip, error) {
+	var trip *models.GuardrailTrip
+
+	err := s.withLockedProxy(ctx, proxyID, func(lp *lockedProxy) error {
+		current := findGuardrail(lp.Settings.Guardrails, guardrail.ID)
+		if current == nil || !current.ArmedAt.Equal(guardrail.ArmedAt) {
+			return nil
+		}
+		for _, t := range lp.Settings.GuardrailTrips {
+			if t.GuardrailID == guardrail.ID && t.TargetID == targetID && t.RevertedAt == nil {
+				return nil
+			}
+		}
+
+		previous := lp.Targets
+		targets := current.Action.Rollback(previous, targetID, lp.Settings.ControlTarget(previous))
+		if err := lp.setTargets(ctx, targets); err != nil {
+			return err
+		}
+
+		trip = &models.GuardrailTrip{
+			ID:              uuid.New().String(),
+			GuardrailID:     guardrail.ID,
+			TargetID:        targetID,
+			Action:          current.Action,
+			Reason:          reason,
+			Value:           value,
+			TrippedAt:       time.Now(),
+			PreviousTargets: previous,
+		}
+		trips := append(lp.Settings.GuardrailTrips, *trip)
+		if len(trips) > maxGuardrailTrips {
+			trips = trips[len(trips)-maxGuardrailTrips:]
+		}
+		if err := lp.setSetting(ctx, "guardrail_trips", trips); err != nil {
+			return err
+		}
+
+		// Tripped by the supervisor, there is no user
+		return lp.recordChange(ctx, models.ChangeTypeGuardrailTrip,
+			map[string]interface{}{"targets": previous},
+			map[string]interface{}{"targets": targets, "guardrail_trip": trip},
+			nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trip, nil
+}
+
+// RevertGuardrailTrip restores the weights and states the targets had before
+// the trip and re-arms the guardrail. Targets added or removed since are left
+// as they are.
+func (s *Storage) RevertGuardrailTrip(ctx context.Context, proxyID, tripID string, createdBy *string) (*models.GuardrailTrip, error) {
+	var reverted *models.GuardrailTrip
+
+	err := s.withLockedProxy(ctx, proxyID, func(lp *lockedProxy) error {
+		trips := append([]models.GuardrailTrip(nil), lp.Settings.GuardrailTrips...)
+		var trip *models.GuardrailTrip
+		for i := range trips {
+			if trips[i].ID == tripID {
+				trip = &trips[i]
+			}
+		}
+		if trip == nil {
+			return ErrTripNotFound
+		}
+		if trip.RevertedAt != nil {
+			return ErrTripReverted
+		}
+
+		restore := make(map[string]models.Target, len(trip.PreviousTargets))
+		for _, t := range trip.PreviousTargets {
+			restore[t.ID] = t
+		}
+		previous := lp.Targets
+		targets := make([]models.Target, len(previous))
+		for i, t := range previous {
+			if before, ok := restore[t.ID]; ok {
+				t.Weight, t.IsActive = before.Weight, before.IsActive
+			}
+			targets[i] = t
+		}
+		if err := lp.setTargets(ctx, targets); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		trip.RevertedAt, trip.RevertedBy = &now, createdBy
+		if err := lp.setSetting(ctx, "guardrail_trips", trips); err != nil {
+			return err
+		}
+
+		guardrails := append([]models.Guardrail(nil), lp.Settings.Guardrails...)
+		if g := findGuardrail(guardrails, trip.GuardrailID); g != nil {
+			g.ArmedAt = now
+			if err := lp.setSetting(ctx, "guardrails", guardrails); err != nil {
+				return err
+			}
+		}
+
+		reverted = trip
+		return lp.recordChange(ctx, models.ChangeTypeGuardrailRevert,
+			map[string]interface{}{"targets": previous},
+			map[string]interface{}{"targets": targets, "guardrail_trip": trip},
+			createdBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+func findGuardrail(guardrails []models.Guardrail, id string) *models.Guardrail {
+	for i := range guardrails {
+		if guardrails[i].ID == id {
+			return &guardrails[i]
+		}
+	}
+	return nil
+}
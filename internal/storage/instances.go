@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+const instanceKeyPrefix = "instance:"
+
+// SaveInstance registers a service instance until ttl passes without another
+// save
+func (s *Storage) SaveInstance(ctx context.Context, instance *models.Instance, ttl time.Duration) error {
+	instance.ExpiresAt = instance.Heartbeat.Add(ttl)
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance: %w", err)
+	}
+	return s.Redis.Set(ctx, instanceKeyPrefix+instance.ID, data, ttl).Err()
+}
+
+// GetInstances returns the registered service instances, oldest first
+func (s *Storage) GetInstances(ctx context.Context) ([]models.Instance, error) {
+	var keys []string
+	iter := s.Redis.Scan(ctx, 0, instanceKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	instances := make([]models.Instance, 0, len(keys))
+	for _, key := range keys {
+		data, err := s.Redis.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue // Expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var instance models.Instance
+		if err := json.Unmarshal(data, &instance); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal instance %s: %w", key, err)
+		}
+		instances = append(instances, instance)
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].StartedAt.Before(instances[j].StartedAt)
+	})
+	return instances, nil
+}
+
+// DeleteInstance unregisters a service instance
+func (s *Storage) DeleteInstance(ctx context.Context, id string) error {
+	return s.Redis.Del(ctx, instanceKeyPrefix+id).Err()
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid lifecycle transition")
+	ErrTargetNotFound    = errors.New("target not found")
+)
+
+// TransitionProxyState takes a lifecycle action on a proxy and returns the new
+// state and lifecycle settings. targetID is the fallback target of pause, the
+// control target when empty, and the winner of complete, which is required.
+//
+// The proxy row is locked while the current state is checked, so that two
+// concurrent transitions can't both apply.
+func (s *Storage) TransitionProxyState(ctx context.Context, proxyID string, action models.LifecycleAction,
+	targetID string, createdBy *string) (models.ProxyState, *models.Lifecycle, error) {
+	var state models.ProxyState
+	var lifecycle *models.Lifecycle
+
+	err := s.withLockedProxy(ctx, proxyID, func(lp *lockedProxy) error {
+		var err error
+		lifecycle, err = lp.transition(ctx, action, targetID, createdBy)
+		state = lp.State
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return state, lifecycle, nil
+}
+
+// transition takes a lifecycle action on the locked proxy, records it in the
+// proxy history and returns the new lifecycle settings
+func (lp *lockedProxy) transition(ctx context.Context, action models.LifecycleAction,
+	targetID string, createdBy *string) (*models.Lifecycle, error) {
+	previous := lp.State
+	next, err := action.Transition(previous)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransition, err)
+	}
+
+	var lifecycle models.Lifecycle
+	if lp.Settings.Lifecycle != nil {
+		lifecycle = *lp.Settings.Lifecycle
+	}
+	now := time.Now()
+	switch action {
+	case models.LifecycleActionStart:
+		lifecycle.StartedAt = now
+	case models.LifecycleActionPause:
+		if targetID == "" {
+			targetID = lp.Settings.ControlTarget(lp.Targets)
+		}
+		if !hasTarget(lp.Targets, targetID) {
+			return nil, fmt.Errorf("%w: fallback target %q", ErrTargetNotFound, targetID)
+		}
+		lifecycle.FallbackTargetID = targetID
+	case models.LifecycleActionComplete:
+		if targetID == "" {
+			return nil, fmt.Errorf("%w: winner target is required", ErrTargetNotFound)
+		}
+		if !hasTarget(lp.Targets, targetID) {
+			return nil, fmt.Errorf("%w: winner target %q", ErrTargetNotFound, targetID)
+		}
+		lifecycle.WinnerTargetID = targetID
+		lifecycle.CompletedAt = now
+	}
+
+	if err := lp.setState(ctx, next); err != nil {
+		return nil, err
+	}
+	if err := lp.setSetting(ctx, "lifecycle", &lifecycle); err != nil {
+		return nil, err
+	}
+
+	err = lp.recordChange(ctx, models.ChangeTypeStateUpdate,
+		map[string]interface{}{"state": previous, "lifecycle": lp.Settings.Lifecycle},
+		map[string]interface{}{"state": next, "action": action, "lifecycle": &lifecycle},
+		createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	lp.Settings.Lifecycle = &lifecycle
+	return &lifecycle, nil
+}
+
+func hasTarget(targets []models.Target, id string) bool {
+	for _, t := range targets {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// lockedProxy is the state of a proxy read in a transaction holding the lock of
+// the proxy row. Changes made through it are committed together.
+type lockedProxy struct {
+	q        *Queries
+	proxyID  string
+	State    models.ProxyState
+	Settings models.ProxySettings
+	Targets  []models.Target
+	changed  bool
+}
+
+// withLockedProxy runs fn in a transaction holding the lock of the proxy row, so
+// that concurrent read-modify-write changes of the proxy, e.g. made by several
+// service instances, are applied one after the other. The proxy cache is
+// invalidated when fn made changes.
+func (s *Storage) withLockedProxy(ctx context.Context, proxyID string, fn func(lp *lockedProxy) error) error {
+	changed := false
+
+	// Begin transaction
+	err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		q := New(tx)
+
+		row, err := q.GetProxyForUpdate(ctx, proxyID)
+		if err != nil {
+			return fmt.Errorf("failed to lock proxy: %w", err)
+		}
+		lp := &lockedProxy{q: q, proxyID: proxyID, State: models.ProxyState(row.State)}
+		if len(row.Settings) > 0 {
+			if err := json.Unmarshal(row.Settings, &lp.Settings); err != nil {
+				return fmt.Errorf("failed to unmarshal settings: %w", err)
+			}
+		}
+
+		rows, err := q.GetTargetsByProxyID(ctx, proxyID)
+		if err != nil {
+			return fmt.Errorf("failed to get targets: %w", err)
+		}
+		for _, row := range rows {
+			lp.Targets = append(lp.Targets, models.Target{
+				ID:       row.ID,
+				ProxyID:  proxyID,
+				URL:      row.Url,
+				Weight:   row.Weight,
+				IsActive: row.IsActive,
+			})
+		}
+
+		if err := fn(lp); err != nil {
+			return err
+		}
+		changed = lp.changed
+		return nil
+	})
+
+	if err != nil || !changed {
+		return err
+	}
+
+	// Invalidate cache
+	return s.InvalidateProxyCache(ctx, proxyID)
+}
+
+func (lp *lockedProxy) setTargets(ctx context.Context, targets []models.Target) error {
+	if err := replaceTargets(ctx, lp.q, lp.proxyID, targets); err != nil {
+		return err
+	}
+	lp.Targets = targets
+	lp.changed = true
+	return nil
+}
+
+func (lp *lockedProxy) setState(ctx context.Context, state models.ProxyState) error {
+	err := lp.q.UpdateProxyState(ctx, &UpdateProxyStateParams{
+		State: string(state),
+		ID:    lp.proxyID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update state: %w", err)
+	}
+	lp.State = state
+	lp.changed = true
+	return nil
+}
+
+func (lp *lockedProxy) setSetting(ctx context.Context, key string, value interface{}) error {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s settings: %w", key, err)
+	}
+
+	err = lp.q.UpdateProxySetting(ctx, &UpdateProxySettingParams{
+		Key:   key,
+		Value: valueJSON,
+		ID:    lp.proxyID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update %s settings: %w", key, err)
+	}
+	lp.changed = true
+	return nil
+}
+
+func (lp *lockedProxy) recordChange(ctx context.Context, changeType models.ChangeType,
+	previousState, newState interface{}, createdBy *string) error {
+	previousStateJSON, err := json.Marshal(previousState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal previous state: %w", err)
+	}
+	newStateJSON, err := json.Marshal(newState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new state: %w", err)
+	}
+
+	err = lp.q.CreateProxyChange(ctx, &CreateProxyChangeParams{
+		ID:            uuid.New().String(),
+		ProxyID:       lp.proxyID,
+		ChangeType:    string(changeType),
+		PreviousState: previousStateJSON,
+		NewState:      newStateJSON,
+		CreatedAt:     pgtype.Timestamptz{Time: time.Now()},
+		CreatedBy:     createdBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create proxy change record: %w", err)
+	}
+	return nil
+}
@@ -8,6 +8,16 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Certificate struct {
+	Host         string
+	CertPem      string
+	EncryptedKey []byte
+	NotAfter     pgtype.Timestamptz
+	CreatedBy    *string
+	CreatedAt    pgtype.Timestamptz
+	UpdatedAt    pgtype.Timestamptz
+}
+
 type ProxyChange struct {
 	ID            string
 	ProxyID       string
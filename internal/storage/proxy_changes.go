@@ -49,6 +49,34 @@ func (s *Storage) GetProxyChanges(ctx context.Context, proxyID string, limit, of
 	return changes, nil
 }
 
+// RecordProxyChange adds an entry to the proxy history for a change that is
+// not a config update, e.g. a target health transition
+func (s *Storage) RecordProxyChange(ctx context.Context, proxyID string, changeType models.ChangeType,
+	previousState, newState interface{}, createdBy *string) error {
+	previousStateJSON, err := json.Marshal(previousState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal previous state: %w", err)
+	}
+	newStateJSON, err := json.Marshal(newState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new state: %w", err)
+	}
+
+	err = s.q.CreateProxyChange(ctx, &CreateProxyChangeParams{
+		ID:            uuid.New().String(),
+		ProxyID:       proxyID,
+		ChangeType:    string(changeType),
+		PreviousState: previousStateJSON,
+		NewState:      newStateJSON,
+		CreatedAt:     pgtype.Timestamptz{Time: time.Now()},
+		CreatedBy:     createdBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create proxy change record: %w", err)
+	}
+	return nil
+}
+
 func (s *Storage) UpdateProxyURL(ctx context.Context, proxyID string, listenURL string, pathKey *string, createdBy *string) error {
 	// Verify proxy exists
 	_, err := s.GetProxy(ctx, proxyID)
@@ -286,25 +314,24 @@ func (s *Storage) UpdateProxyWithTargetsAndCondition(ctx context.Context, proxyI
 	err = pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
 		q := New(tx)
 
-		// Delete existing targets
-		err = q.DeleteTargetByProxyID(ctx, proxyID)
-		if err != nil {
-			return fmt.Errorf("failed to delete existing targets: %w", err)
+		if err = replaceTargets(ctx, q, proxyID, targets); err != nil {
+			return err
 		}
 
-		// Create new targets
-		for _, target := range targets {
-			err = q.CreateTarget(ctx, &CreateTargetParams{
-				ID:       target.ID,
-				ProxyID:  proxyID,
-				Url:      target.URL,
-				Weight:   target.Weight,
-				IsActive: target.IsActive,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to create target: %w", err)
+		// An empty condition removes the targeting rules
+		var conditionJSON []byte
+		if condition != nil && (len(condition.Rules) > 0 || condition.Type != "") {
+			if conditionJSON, err = json.Marshal(condition); err != nil {
+				return fmt.Errorf("failed to marshal condition: %w", err)
 			}
 		}
+		err = q.UpdateProxyCondition(ctx, &UpdateProxyConditionParams{
+			Condition: conditionJSON,
+			ID:        proxyID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update proxy condition: %w", err)
+		}
 
 		// Create change record
 		err = q.CreateProxyChange(ctx, &CreateProxyChangeParams{
@@ -331,6 +358,30 @@ func (s *Storage) UpdateProxyWithTargetsAndCondition(ctx context.Context, proxyI
 	return s.InvalidateProxyCache(ctx, proxyID)
 }
 
+// replaceTargets replaces all targets of a proxy within a transaction
+func replaceTargets(ctx context.Context, q *Queries, proxyID string, targets []models.Target) error {
+	// Delete existing targets
+	err := q.DeleteTargetByProxyID(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete existing targets: %w", err)
+	}
+
+	// Create new targets
+	for _, target := range targets {
+		err = q.CreateTarget(ctx, &CreateTargetParams{
+			ID:       target.ID,
+			ProxyID:  proxyID,
+			Url:      target.URL,
+			Weight:   target.Weight,
+			IsActive: target.IsActive,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create target: %w", err)
+		}
+	}
+	return nil
+}
+
 func (s *Storage) AddProxyListenURL(ctx context.Context, proxyID string, listenURL string, pathKey *string, createdBy *string) error {
 	// Get current proxy state
 	currentProxy, err := s.GetProxy(ctx, proxyID)
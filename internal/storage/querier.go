@@ -15,26 +15,38 @@ type Querier interface {
 	CreateTarget(ctx context.Context, arg *CreateTargetParams) error
 	CreateUser(ctx context.Context, arg *CreateUserParams) error
 	CreateVisit(ctx context.Context, arg *CreateVisitParams) error
+	DeleteCertificate(ctx context.Context, host string) (int64, error)
+	DeleteProxy(ctx context.Context, id string) (int64, error)
 	DeleteProxyListenURL(ctx context.Context, id string) error
+	DeleteProxyListenURLs(ctx context.Context, proxyID string) error
 	DeleteTargetByProxyID(ctx context.Context, proxyID string) error
 	GetAllTags(ctx context.Context) ([]string, error)
+	GetCertificates(ctx context.Context) ([]*Certificate, error)
+	GetConversionStats(ctx context.Context, arg *GetConversionStatsParams) ([]*GetConversionStatsRow, error)
 	GetProxies(ctx context.Context) ([]*GetProxiesRow, error)
 	GetProxiesByTags(ctx context.Context, tags []string) ([]*GetProxiesByTagsRow, error)
 	GetProxy(ctx context.Context, id string) (*GetProxyRow, error)
 	GetProxyChangesByProxyID(ctx context.Context, arg *GetProxyChangesByProxyIDParams) ([]*ProxyChange, error)
+	GetProxyForUpdate(ctx context.Context, id string) (*GetProxyForUpdateRow, error)
 	GetProxyListenURLs(ctx context.Context, proxyID string) ([]*ProxyListenUrl, error)
 	GetProxyTags(ctx context.Context, id string) ([]string, error)
+	GetProxyVersions(ctx context.Context) ([]*GetProxyVersionsRow, error)
 	GetStats(ctx context.Context, arg *GetStatsParams) (*GetStatsRow, error)
 	GetTargetStats(ctx context.Context, arg *GetTargetStatsParams) ([]*GetTargetStatsRow, error)
+	GetTargetTotals(ctx context.Context, arg *GetTargetTotalsParams) ([]*GetTargetTotalsRow, error)
+	GetTargetUserSketches(ctx context.Context, arg *GetTargetUserSketchesParams) ([]*GetTargetUserSketchesRow, error)
 	GetTargetsByProxyID(ctx context.Context, proxyID string) ([]*GetTargetsByProxyIDRow, error)
-	GetUniqueUsersCount(ctx context.Context, arg *GetUniqueUsersCountParams) (int64, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserSketches(ctx context.Context, arg *GetUserSketchesParams) ([]*GetUserSketchesRow, error)
 	UpdateProxyCondition(ctx context.Context, arg *UpdateProxyConditionParams) error
 	UpdateProxyCookiesForwarding(ctx context.Context, arg *UpdateProxyCookiesForwardingParams) error
 	UpdateProxyListenURL(ctx context.Context, arg *UpdateProxyListenURLParams) error
 	UpdateProxyQueryForwarding(ctx context.Context, arg *UpdateProxyQueryForwardingParams) error
 	UpdateProxySavingCookies(ctx context.Context, arg *UpdateProxySavingCookiesParams) error
+	UpdateProxySetting(ctx context.Context, arg *UpdateProxySettingParams) error
+	UpdateProxyState(ctx context.Context, arg *UpdateProxyStateParams) error
 	UpdateProxyTags(ctx context.Context, arg *UpdateProxyTagsParams) error
+	UpsertCertificate(ctx context.Context, arg *UpsertCertificateParams) error
 	UserExists(ctx context.Context, email string) (bool, error)
 }
 
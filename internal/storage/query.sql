@@ -11,13 +11,15 @@ INSERT INTO users (id, email, password_hash, created_at, updated_at)
 VALUES ($1, $2, $3, $4, $5);
 
 -- name: GetProxy :one
-SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.created_at, p.updated_at
+SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.settings, p.state, p.created_at, p.updated_at
 FROM proxies p
-WHERE p.id = $1;
+WHERE p.id = $1
+  AND p.deleted_at IS NULL;
 
 -- name: GetProxies :many
-SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg
+SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.settings, p.state
 FROM proxies p
+WHERE p.deleted_at IS NULL
 ORDER BY p.created_at DESC;
 
 -- name: UpdateProxyCondition :exec
@@ -50,16 +52,37 @@ SET cookies_forwarding_flg = $1,
     updated_at = NOW()
 WHERE id = $2;
 
+-- name: GetProxyForUpdate :one
+SELECT state, settings
+FROM proxies
+WHERE id = $1
+  AND deleted_at IS NULL
+FOR UPDATE;
+
+-- name: UpdateProxyState :exec
+UPDATE proxies
+SET state      = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: UpdateProxySetting :exec
+UPDATE proxies
+SET settings   = jsonb_set(settings, ARRAY[@key::text], @value::jsonb),
+    updated_at = NOW()
+WHERE id = @id;
+
 -- name: GetAllTags :many
 SELECT DISTINCT UNNEST(tags)::text as tags
 FROM proxies
 WHERE tags IS NOT NULL
+  AND deleted_at IS NULL
 ORDER BY 1;
 
 -- name: GetProxyTags :one
 SELECT tags
 FROM proxies
-WHERE id = $1;
+WHERE id = $1
+  AND deleted_at IS NULL;
 
 -- name: GetProxiesByTags :many
 SELECT DISTINCT p.id,
@@ -68,10 +91,12 @@ SELECT DISTINCT p.id,
                 p.condition,
                 p.tags,
                 p.saving_cookies_flg,
+                p.state,
                 p.created_at,
                 p.updated_at
 FROM proxies p
 WHERE tags @> $1
+  AND p.deleted_at IS NULL
 ORDER BY p.created_at DESC;
 
 -- name: GetTargetsByProxyID :many
@@ -80,8 +105,8 @@ FROM targets
 WHERE proxy_id = $1;
 
 -- name: CreateProxy :exec
-INSERT INTO proxies (id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg, cookies_forwarding_flg, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+INSERT INTO proxies (id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg, cookies_forwarding_flg, settings, state, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
 
 -- name: CreateTarget :exec
 INSERT INTO targets (id, proxy_id, url, weight, is_active)
@@ -114,24 +139,39 @@ FROM proxy_stats
 WHERE timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
           AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS');
 
--- name: GetUniqueUsersCount :one
-SELECT COUNT(DISTINCT users)
-FROM proxy_stats,
-     LATERAL jsonb_array_elements_text(unique_users) AS users
-WHERE timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
-          AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS');
-
 -- name: GetTargetStats :many
 SELECT target_id,
        timestamp,
        request_count as requests,
        error_count   as errors,
-       jsonb_array_length(unique_users) as users_count
+       unique_users,
+       users_sketch
 FROM proxy_stats
 WHERE proxy_id = $1
   AND timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
     AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS');
 
+-- name: GetConversionStats :many
+SELECT target_id,
+       goal_id,
+       COALESCE(SUM(conversions), 0)::int    as conversions,
+       COALESCE(SUM(revenue), 0)::float8     as revenue
+FROM proxy_conversions
+WHERE proxy_id = $1
+  AND timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+GROUP BY target_id, goal_id;
+
+-- name: GetTargetTotals :many
+SELECT s.target_id,
+       COALESCE(SUM(s.request_count), 0)::bigint as requests,
+       COALESCE(SUM(s.error_count), 0)::bigint   as errors
+FROM proxy_stats s
+WHERE s.proxy_id = $1
+  AND s.timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+GROUP BY s.proxy_id, s.target_id;
+
 -- name: GetProxyListenURLs :many
 SELECT id, proxy_id, listen_url, path_key, created_at, updated_at
 FROM proxy_listen_urls
@@ -151,3 +191,52 @@ WHERE id = $3;
 -- name: DeleteProxyListenURL :exec
 DELETE FROM proxy_listen_urls
 WHERE id = $1;
+
+-- name: GetCertificates :many
+SELECT host, cert_pem, encrypted_key, not_after, created_by, created_at, updated_at
+FROM certificates
+ORDER BY host;
+
+-- name: UpsertCertificate :exec
+INSERT INTO certificates (host, cert_pem, encrypted_key, not_after, created_by, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+ON CONFLICT (host) DO UPDATE
+    SET cert_pem      = EXCLUDED.cert_pem,
+        encrypted_key = EXCLUDED.encrypted_key,
+        not_after     = EXCLUDED.not_after,
+        created_by    = EXCLUDED.created_by,
+        updated_at    = NOW();
+
+-- name: DeleteCertificate :execrows
+DELETE FROM certificates
+WHERE host = $1;
+
+-- name: GetProxyVersions :many
+SELECT id, config_version
+FROM proxies
+WHERE deleted_at IS NULL;
+
+-- name: DeleteProxy :one
+UPDATE proxies
+SET deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+  AND deleted_at IS NULL
+RETURNING config_version;
+
+-- name: DeleteProxyListenURLs :exec
+DELETE FROM proxy_listen_urls
+WHERE proxy_id = $1;
+
+-- name: GetUserSketches :many
+SELECT unique_users, users_sketch
+FROM proxy_stats
+WHERE timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+          AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS');
+
+-- name: GetTargetUserSketches :many
+SELECT target_id, unique_users, users_sketch
+FROM proxy_stats
+WHERE proxy_id = $1
+  AND timestamp BETWEEN to_timestamp(@from_time::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp(@to_time::text, 'YYYY-MM-DD HH24:MI:SS.MS');
@@ -12,8 +12,8 @@ import (
 )
 
 const createProxy = `-- name: CreateProxy :exec
-INSERT INTO proxies (id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg, cookies_forwarding_flg, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+INSERT INTO proxies (id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg, cookies_forwarding_flg, settings, state, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 `
 
 type CreateProxyParams struct {
@@ -25,6 +25,8 @@ type CreateProxyParams struct {
 	SavingCookiesFlg     bool
 	QueryForwardingFlg   bool
 	CookiesForwardingFlg bool
+	Settings             []byte
+	State                string
 	CreatedAt            pgtype.Timestamptz
 	UpdatedAt            pgtype.Timestamptz
 }
@@ -39,6 +41,8 @@ func (q *Queries) CreateProxy(ctx context.Context, arg *CreateProxyParams) error
 		arg.SavingCookiesFlg,
 		arg.QueryForwardingFlg,
 		arg.CookiesForwardingFlg,
+		arg.Settings,
+		arg.State,
 		arg.CreatedAt,
 		arg.UpdatedAt,
 	)
@@ -177,6 +181,35 @@ func (q *Queries) CreateVisit(ctx context.Context, arg *CreateVisitParams) error
 	return err
 }
 
+const deleteCertificate = `-- name: DeleteCertificate :execrows
+DELETE FROM certificates
+WHERE host = $1
+`
+
+func (q *Queries) DeleteCertificate(ctx context.Context, host string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCertificate, host)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteProxy = `-- name: DeleteProxy :one
+UPDATE proxies
+SET deleted_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+  AND deleted_at IS NULL
+RETURNING config_version
+`
+
+func (q *Queries) DeleteProxy(ctx context.Context, id string) (int64, error) {
+	row := q.db.QueryRow(ctx, deleteProxy, id)
+	var config_version int64
+	err := row.Scan(&config_version)
+	return config_version, err
+}
+
 const deleteProxyListenURL = `-- name: DeleteProxyListenURL :exec
 DELETE FROM proxy_listen_urls
 WHERE id = $1
@@ -187,6 +220,16 @@ func (q *Queries) DeleteProxyListenURL(ctx context.Context, id string) error {
 	return err
 }
 
+const deleteProxyListenURLs = `-- name: DeleteProxyListenURLs :exec
+DELETE FROM proxy_listen_urls
+WHERE proxy_id = $1
+`
+
+func (q *Queries) DeleteProxyListenURLs(ctx context.Context, proxyID string) error {
+	_, err := q.db.Exec(ctx, deleteProxyListenURLs, proxyID)
+	return err
+}
+
 const deleteTargetByProxyID = `-- name: DeleteTargetByProxyID :exec
 DELETE
 FROM targets
@@ -202,6 +245,7 @@ const getAllTags = `-- name: GetAllTags :many
 SELECT DISTINCT UNNEST(tags)::text as tags
 FROM proxies
 WHERE tags IS NOT NULL
+  AND deleted_at IS NULL
 ORDER BY 1
 `
 
@@ -225,9 +269,94 @@ func (q *Queries) GetAllTags(ctx context.Context) ([]string, error) {
 	return items, nil
 }
 
+const getCertificates = `-- name: GetCertificates :many
+SELECT host, cert_pem, encrypted_key, not_after, created_by, created_at, updated_at
+FROM certificates
+ORDER BY host
+`
+
+func (q *Queries) GetCertificates(ctx context.Context) ([]*Certificate, error) {
+	rows, err := q.db.Query(ctx, getCertificates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*Certificate
+	for rows.Next() {
+		var i Certificate
+		if err := rows.Scan(
+			&i.Host,
+			&i.CertPem,
+			&i.EncryptedKey,
+			&i.NotAfter,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getConversionStats = `-- name: GetConversionStats :many
+SELECT target_id,
+       goal_id,
+       COALESCE(SUM(conversions), 0)::int    as conversions,
+       COALESCE(SUM(revenue), 0)::float8     as revenue
+FROM proxy_conversions
+WHERE proxy_id = $1
+  AND timestamp BETWEEN to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp($3::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+GROUP BY target_id, goal_id
+`
+
+type GetConversionStatsParams struct {
+	ProxyID  string
+	FromTime string
+	ToTime   string
+}
+
+type GetConversionStatsRow struct {
+	TargetID    string
+	GoalID      string
+	Conversions int32
+	Revenue     float64
+}
+
+func (q *Queries) GetConversionStats(ctx context.Context, arg *GetConversionStatsParams) ([]*GetConversionStatsRow, error) {
+	rows, err := q.db.Query(ctx, getConversionStats, arg.ProxyID, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetConversionStatsRow
+	for rows.Next() {
+		var i GetConversionStatsRow
+		if err := rows.Scan(
+			&i.TargetID,
+			&i.GoalID,
+			&i.Conversions,
+			&i.Revenue,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getProxies = `-- name: GetProxies :many
-SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg
+SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.settings, p.state
 FROM proxies p
+WHERE p.deleted_at IS NULL
 ORDER BY p.created_at DESC
 `
 
@@ -240,6 +369,8 @@ type GetProxiesRow struct {
 	SavingCookiesFlg     bool
 	QueryForwardingFlg   bool
 	CookiesForwardingFlg bool
+	Settings             []byte
+	State                string
 }
 
 func (q *Queries) GetProxies(ctx context.Context) ([]*GetProxiesRow, error) {
@@ -260,6 +391,8 @@ func (q *Queries) GetProxies(ctx context.Context) ([]*GetProxiesRow, error) {
 			&i.SavingCookiesFlg,
 			&i.QueryForwardingFlg,
 			&i.CookiesForwardingFlg,
+			&i.Settings,
+			&i.State,
 		); err != nil {
 			return nil, err
 		}
@@ -278,10 +411,12 @@ SELECT DISTINCT p.id,
                 p.condition,
                 p.tags,
                 p.saving_cookies_flg,
+                p.state,
                 p.created_at,
                 p.updated_at
 FROM proxies p
 WHERE tags @> $1
+  AND p.deleted_at IS NULL
 ORDER BY p.created_at DESC
 `
 
@@ -292,6 +427,7 @@ type GetProxiesByTagsRow struct {
 	Condition        []byte
 	Tags             []string
 	SavingCookiesFlg bool
+	State            string
 	CreatedAt        pgtype.Timestamptz
 	UpdatedAt        pgtype.Timestamptz
 }
@@ -312,6 +448,7 @@ func (q *Queries) GetProxiesByTags(ctx context.Context, tags []string) ([]*GetPr
 			&i.Condition,
 			&i.Tags,
 			&i.SavingCookiesFlg,
+			&i.State,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -326,9 +463,10 @@ func (q *Queries) GetProxiesByTags(ctx context.Context, tags []string) ([]*GetPr
 }
 
 const getProxy = `-- name: GetProxy :one
-SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.created_at, p.updated_at
+SELECT p.id, p.name, p.mode, p.condition, p.tags, p.saving_cookies_flg, p.query_forwarding_flg, p.cookies_forwarding_flg, p.settings, p.state, p.created_at, p.updated_at
 FROM proxies p
 WHERE p.id = $1
+  AND p.deleted_at IS NULL
 `
 
 type GetProxyRow struct {
@@ -340,6 +478,8 @@ type GetProxyRow struct {
 	SavingCookiesFlg     bool
 	QueryForwardingFlg   bool
 	CookiesForwardingFlg bool
+	Settings             []byte
+	State                string
 	CreatedAt            pgtype.Timestamptz
 	UpdatedAt            pgtype.Timestamptz
 }
@@ -356,6 +496,8 @@ func (q *Queries) GetProxy(ctx context.Context, id string) (*GetProxyRow, error)
 		&i.SavingCookiesFlg,
 		&i.QueryForwardingFlg,
 		&i.CookiesForwardingFlg,
+		&i.Settings,
+		&i.State,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -404,6 +546,26 @@ func (q *Queries) GetProxyChangesByProxyID(ctx context.Context, arg *GetProxyCha
 	return items, nil
 }
 
+const getProxyForUpdate = `-- name: GetProxyForUpdate :one
+SELECT state, settings
+FROM proxies
+WHERE id = $1
+  AND deleted_at IS NULL
+FOR UPDATE
+`
+
+type GetProxyForUpdateRow struct {
+	State    string
+	Settings []byte
+}
+
+func (q *Queries) GetProxyForUpdate(ctx context.Context, id string) (*GetProxyForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getProxyForUpdate, id)
+	var i GetProxyForUpdateRow
+	err := row.Scan(&i.State, &i.Settings)
+	return &i, err
+}
+
 const getProxyListenURLs = `-- name: GetProxyListenURLs :many
 SELECT id, proxy_id, listen_url, path_key, created_at, updated_at
 FROM proxy_listen_urls
@@ -441,6 +603,7 @@ const getProxyTags = `-- name: GetProxyTags :one
 SELECT tags
 FROM proxies
 WHERE id = $1
+  AND deleted_at IS NULL
 `
 
 func (q *Queries) GetProxyTags(ctx context.Context, id string) ([]string, error) {
@@ -450,6 +613,37 @@ func (q *Queries) GetProxyTags(ctx context.Context, id string) ([]string, error)
 	return tags, err
 }
 
+const getProxyVersions = `-- name: GetProxyVersions :many
+SELECT id, config_version
+FROM proxies
+WHERE deleted_at IS NULL
+`
+
+type GetProxyVersionsRow struct {
+	ID            string
+	ConfigVersion int64
+}
+
+func (q *Queries) GetProxyVersions(ctx context.Context) ([]*GetProxyVersionsRow, error) {
+	rows, err := q.db.Query(ctx, getProxyVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetProxyVersionsRow
+	for rows.Next() {
+		var i GetProxyVersionsRow
+		if err := rows.Scan(&i.ID, &i.ConfigVersion); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getStats = `-- name: GetStats :one
 SELECT COALESCE(SUM(request_count), 0)::int as requests,
        COALESCE(SUM(error_count), 0)::int   as errors
@@ -480,7 +674,8 @@ SELECT target_id,
        timestamp,
        request_count as requests,
        error_count   as errors,
-       jsonb_array_length(unique_users) as users_count
+       unique_users,
+       users_sketch
 FROM proxy_stats
 WHERE proxy_id = $1
   AND timestamp BETWEEN to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
@@ -494,11 +689,12 @@ type GetTargetStatsParams struct {
 }
 
 type GetTargetStatsRow struct {
-	TargetID   string
-	Timestamp  pgtype.Timestamp
-	Requests   int32
-	Errors     int32
-	UsersCount int32
+	TargetID    string
+	Timestamp   pgtype.Timestamp
+	Requests    int32
+	Errors      int32
+	UniqueUsers []byte
+	UsersSketch []byte
 }
 
 func (q *Queries) GetTargetStats(ctx context.Context, arg *GetTargetStatsParams) ([]*GetTargetStatsRow, error) {
@@ -515,7 +711,8 @@ func (q *Queries) GetTargetStats(ctx context.Context, arg *GetTargetStatsParams)
 			&i.Timestamp,
 			&i.Requests,
 			&i.Errors,
-			&i.UsersCount,
+			&i.UniqueUsers,
+			&i.UsersSketch,
 		); err != nil {
 			return nil, err
 		}
@@ -527,6 +724,89 @@ func (q *Queries) GetTargetStats(ctx context.Context, arg *GetTargetStatsParams)
 	return items, nil
 }
 
+const getTargetTotals = `-- name: GetTargetTotals :many
+SELECT s.target_id,
+       COALESCE(SUM(s.request_count), 0)::bigint as requests,
+       COALESCE(SUM(s.error_count), 0)::bigint   as errors
+FROM proxy_stats s
+WHERE s.proxy_id = $1
+  AND s.timestamp BETWEEN to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp($3::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+GROUP BY s.proxy_id, s.target_id
+`
+
+type GetTargetTotalsParams struct {
+	ProxyID  string
+	FromTime string
+	ToTime   string
+}
+
+type GetTargetTotalsRow struct {
+	TargetID string
+	Requests int64
+	Errors   int64
+}
+
+func (q *Queries) GetTargetTotals(ctx context.Context, arg *GetTargetTotalsParams) ([]*GetTargetTotalsRow, error) {
+	rows, err := q.db.Query(ctx, getTargetTotals, arg.ProxyID, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetTargetTotalsRow
+	for rows.Next() {
+		var i GetTargetTotalsRow
+		if err := rows.Scan(&i.TargetID, &i.Requests, &i.Errors); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTargetUserSketches = `-- name: GetTargetUserSketches :many
+SELECT target_id, unique_users, users_sketch
+FROM proxy_stats
+WHERE proxy_id = $1
+  AND timestamp BETWEEN to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+    AND to_timestamp($3::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+`
+
+type GetTargetUserSketchesParams struct {
+	ProxyID  string
+	FromTime string
+	ToTime   string
+}
+
+type GetTargetUserSketchesRow struct {
+	TargetID    string
+	UniqueUsers []byte
+	UsersSketch []byte
+}
+
+func (q *Queries) GetTargetUserSketches(ctx context.Context, arg *GetTargetUserSketchesParams) ([]*GetTargetUserSketchesRow, error) {
+	rows, err := q.db.Query(ctx, getTargetUserSketches, arg.ProxyID, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetTargetUserSketchesRow
+	for rows.Next() {
+		var i GetTargetUserSketchesRow
+		if err := rows.Scan(&i.TargetID, &i.UniqueUsers, &i.UsersSketch); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getTargetsByProxyID = `-- name: GetTargetsByProxyID :many
 SELECT id, url, weight, is_active
 FROM targets
@@ -565,26 +845,6 @@ func (q *Queries) GetTargetsByProxyID(ctx context.Context, proxyID string) ([]*G
 	return items, nil
 }
 
-const getUniqueUsersCount = `-- name: GetUniqueUsersCount :one
-SELECT COUNT(DISTINCT users)
-FROM proxy_stats,
-     LATERAL jsonb_array_elements_text(unique_users) AS users
-WHERE timestamp BETWEEN to_timestamp($1::text, 'YYYY-MM-DD HH24:MI:SS.MS')
-          AND to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
-`
-
-type GetUniqueUsersCountParams struct {
-	FromTime string
-	ToTime   string
-}
-
-func (q *Queries) GetUniqueUsersCount(ctx context.Context, arg *GetUniqueUsersCountParams) (int64, error) {
-	row := q.db.QueryRow(ctx, getUniqueUsersCount, arg.FromTime, arg.ToTime)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
-}
-
 const getUserByEmail = `-- name: GetUserByEmail :one
 SELECT id, email, password_hash, created_at, updated_at
 FROM users
@@ -604,6 +864,43 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (*User, erro
 	return &i, err
 }
 
+const getUserSketches = `-- name: GetUserSketches :many
+SELECT unique_users, users_sketch
+FROM proxy_stats
+WHERE timestamp BETWEEN to_timestamp($1::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+          AND to_timestamp($2::text, 'YYYY-MM-DD HH24:MI:SS.MS')
+`
+
+type GetUserSketchesParams struct {
+	FromTime string
+	ToTime   string
+}
+
+type GetUserSketchesRow struct {
+	UniqueUsers []byte
+	UsersSketch []byte
+}
+
+func (q *Queries) GetUserSketches(ctx context.Context, arg *GetUserSketchesParams) ([]*GetUserSketchesRow, error) {
+	rows, err := q.db.Query(ctx, getUserSketches, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*GetUserSketchesRow
+	for rows.Next() {
+		var i GetUserSketchesRow
+		if err := rows.Scan(&i.UniqueUsers, &i.UsersSketch); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const updateProxyCondition = `-- name: UpdateProxyCondition :exec
 UPDATE proxies
 SET condition  = $1,
@@ -691,6 +988,41 @@ func (q *Queries) UpdateProxySavingCookies(ctx context.Context, arg *UpdateProxy
 	return err
 }
 
+const updateProxySetting = `-- name: UpdateProxySetting :exec
+UPDATE proxies
+SET settings   = jsonb_set(settings, ARRAY[$1::text], $2::jsonb),
+    updated_at = NOW()
+WHERE id = $3
+`
+
+type UpdateProxySettingParams struct {
+	Key   string
+	Value []byte
+	ID    string
+}
+
+func (q *Queries) UpdateProxySetting(ctx context.Context, arg *UpdateProxySettingParams) error {
+	_, err := q.db.Exec(ctx, updateProxySetting, arg.Key, arg.Value, arg.ID)
+	return err
+}
+
+const updateProxyState = `-- name: UpdateProxyState :exec
+UPDATE proxies
+SET state      = $1,
+    updated_at = NOW()
+WHERE id = $2
+`
+
+type UpdateProxyStateParams struct {
+	State string
+	ID    string
+}
+
+func (q *Queries) UpdateProxyState(ctx context.Context, arg *UpdateProxyStateParams) error {
+	_, err := q.db.Exec(ctx, updateProxyState, arg.State, arg.ID)
+	return err
+}
+
 const updateProxyTags = `-- name: UpdateProxyTags :exec
 UPDATE proxies
 SET tags       = $1,
@@ -708,6 +1040,36 @@ func (q *Queries) UpdateProxyTags(ctx context.Context, arg *UpdateProxyTagsParam
 	return err
 }
 
+const upsertCertificate = `-- name: UpsertCertificate :exec
+INSERT INTO certificates (host, cert_pem, encrypted_key, not_after, created_by, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+ON CONFLICT (host) DO UPDATE
+    SET cert_pem      = EXCLUDED.cert_pem,
+        encrypted_key = EXCLUDED.encrypted_key,
+        not_after     = EXCLUDED.not_after,
+        created_by    = EXCLUDED.created_by,
+        updated_at    = NOW()
+`
+
+type UpsertCertificateParams struct {
+	Host         string
+	CertPem      string
+	EncryptedKey []byte
+	NotAfter     pgtype.Timestamptz
+	CreatedBy    *string
+}
+
+func (q *Queries) UpsertCertificate(ctx context.Context, arg *UpsertCertificateParams) error {
+	_, err := q.db.Exec(ctx, upsertCertificate,
+		arg.Host,
+		arg.CertPem,
+		arg.EncryptedKey,
+		arg.NotAfter,
+		arg.CreatedBy,
+	)
+	return err
+}
+
 const userExists = `-- name: UserExists :one
 SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)
 `
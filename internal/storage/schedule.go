@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+func (s *Storage) UpdateProxySchedule(ctx context.Context, proxyID string, schedule *models.Schedule, createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "schedule", currentProxy.Settings.Schedule, schedule,
+		models.ChangeTypeScheduleUpdate, createdBy)
+}
+
+// ApplyDueScheduleStep applies the step of the proxy schedule due at the given
+// time, if any, and returns it. The targets are replaced and the change recorded
+// as a targets update, like a change made through the API. Draft proxies are
+// started once the start time of their schedule has come, like with the start
+// action, proxies in the other states wait until they run again.
+//
+// The proxy row is locked for the duration of the transaction, so when several
+// instances run the scheduler a step is applied once: the others find it applied
+// and get nil.
+func (s *Storage) ApplyDueScheduleStep(ctx context.Context, proxyID string, now time.Time) (*models.ScheduleStep, error) {
+	var applied *models.ScheduleStep
+
+	err := s.withLockedProxy(ctx, proxyID, func(lp *lockedProxy) error {
+		schedule := lp.Settings.Schedule
+		if schedule == nil {
+			return nil
+		}
+		index, ok := schedule.DueStep(now)
+		if !ok {
+			return nil // Nothing due or already applied by another instance
+		}
+
+		if schedule.StartsAt(lp.State, now) {
+			// Started by the scheduler, there is no user
+			if _, err := lp.transition(ctx, models.LifecycleActionStart, "", nil); err != nil {
+				return err
+			}
+		}
+		if lp.State != models.ProxyStateRunning {
+			return nil
+		}
+		step := schedule.Steps()[index]
+
+		previous := lp.Targets
+		targets := step.Apply(previous)
+		if err := lp.setTargets(ctx, targets); err != nil {
+			return err
+		}
+
+		updated := *schedule
+		updated.Applied = index + 1
+		if err := lp.setSetting(ctx, "schedule", &updated); err != nil {
+			return err
+		}
+
+		// Applied by the scheduler, there is no user
+		err := lp.recordChange(ctx, models.ChangeTypeTargetsUpdate,
+			map[string]interface{}{"targets": previous},
+			map[string]interface{}{"targets": targets, "schedule_step": step},
+			nil)
+		if err != nil {
+			return err
+		}
+
+		applied = &step
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
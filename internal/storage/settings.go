@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// updateProxySetting replaces one key of the proxy settings document and
+// records the change in the proxy history
+func (s *Storage) updateProxySetting(ctx context.Context, proxyID string, key string,
+	previous, value interface{}, changeType models.ChangeType, createdBy *string) error {
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s settings: %w", key, err)
+	}
+
+	// Prepare previous and new states
+	previousStateJSON, err := json.Marshal(map[string]interface{}{key: previous})
+	if err != nil {
+		return fmt.Errorf("failed to marshal previous state: %w", err)
+	}
+	newStateJSON, err := json.Marshal(map[string]interface{}{key: value})
+	if err != nil {
+		return fmt.Errorf("failed to marshal new state: %w", err)
+	}
+
+	// Begin transaction
+	err = pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		q := New(tx)
+
+		err = q.UpdateProxySetting(ctx, &UpdateProxySettingParams{
+			Key:   key,
+			Value: valueJSON,
+			ID:    proxyID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update %s settings: %w", key, err)
+		}
+
+		// Create change record
+		err = q.CreateProxyChange(ctx, &CreateProxyChangeParams{
+			ID:            uuid.New().String(),
+			ProxyID:       proxyID,
+			ChangeType:    string(changeType),
+			PreviousState: previousStateJSON,
+			NewState:      newStateJSON,
+			CreatedAt:     pgtype.Timestamptz{Time: time.Now()},
+			CreatedBy:     createdBy,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create proxy change record: %w", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	return s.InvalidateProxyCache(ctx, proxyID)
+}
+
+func (s *Storage) UpdateProxyBucketing(ctx context.Context, proxyID string, bucketing *models.BucketingSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "bucketing", currentProxy.Settings.Bucketing, bucketing,
+		models.ChangeTypeBucketingUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyStickyCookie(ctx context.Context, proxyID string, stickyCookie *models.StickyCookieSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "sticky_cookie", currentProxy.Settings.StickyCookie, stickyCookie,
+		models.ChangeTypeCookiesUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyGoals(ctx context.Context, proxyID string, goals []models.Goal, createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "goals", currentProxy.Settings.Goals, goals,
+		models.ChangeTypeGoalsUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyControl(ctx context.Context, proxyID string, controlTargetID string, createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "control_target_id", currentProxy.Settings.ControlTargetID, controlTargetID,
+		models.ChangeTypeControlUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyHealthCheck(ctx context.Context, proxyID string, healthCheck *models.HealthCheckSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "health_check", currentProxy.Settings.HealthCheck, healthCheck,
+		models.ChangeTypeHealthCheckUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyRedirect(ctx context.Context, proxyID string, redirect *models.RedirectSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "redirect", currentProxy.Settings.Redirect, redirect,
+		models.ChangeTypeRedirectUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyExclusion(ctx context.Context, proxyID string, exclusion *models.ExclusionSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "exclusion", currentProxy.Settings.Exclusion, exclusion,
+		models.ChangeTypeExclusionUpdate, createdBy)
+}
+
+func (s *Storage) UpdateProxyAllocation(ctx context.Context, proxyID string, allocation *models.AllocationSettings,
+	createdBy *string) error {
+	// Get current proxy state
+	currentProxy, err := s.GetProxy(ctx, proxyID)
+	if err != nil {
+		return fmt.Errorf("failed to get current proxy state: %w", err)
+	}
+
+	return s.updateProxySetting(ctx, proxyID, "allocation", currentProxy.Settings.Allocation, allocation,
+		models.ChangeTypeAllocationUpdate, createdBy)
+}
@@ -2,7 +2,11 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"time"
+
+	"github.com/ab-testing-service/internal/hll"
 )
 
 func (s *Storage) GetStats(ctx context.Context, start time.Time, end time.Time) (totalRequests, totalErrors int64, err error) {
@@ -17,24 +21,70 @@ func (s *Storage) GetStats(ctx context.Context, start time.Time, end time.Time)
 	return int64(result.Requests), int64(result.Errors), nil
 }
 
+// GetUniqueUsersCount estimates the number of distinct users over the time
+// range by merging the user sketches of the statistics in it
 func (s *Storage) GetUniqueUsersCount(ctx context.Context, start time.Time, end time.Time) (uniqueUsers int64, err error) {
-	count, err := s.q.GetUniqueUsersCount(ctx, &GetUniqueUsersCountParams{
+	rows, err := s.q.GetUserSketches(ctx, &GetUserSketchesParams{
 		FromTime: start.Format("2006-01-02 15:04:05.000"),
 		ToTime:   end.Format("2006-01-02 15:04:05.000"),
 	})
 	if err != nil {
 		return 0, err
 	}
-	return count, nil
+
+	users := hll.New()
+	for _, row := range rows {
+		sketch, err := usersSketch(row.UniqueUsers, row.UsersSketch)
+		if err != nil {
+			return 0, err
+		}
+		users.Merge(sketch)
+	}
+	return users.Count(), nil
+}
+
+// usersSketch returns the users of a statistics row as a sketch. Rows written
+// before sketches were introduced list the user IDs instead.
+func usersSketch(uniqueUsers, sketchData []byte) (*hll.Sketch, error) {
+	sketch := hll.New()
+	if len(sketchData) > 0 {
+		if err := sketch.UnmarshalBinary(sketchData); err != nil {
+			return nil, fmt.Errorf("failed to decode users sketch: %w", err)
+		}
+		return sketch, nil
+	}
+	if len(uniqueUsers) == 0 {
+		return sketch, nil
+	}
+
+	var userIDs []string
+	if err := json.Unmarshal(uniqueUsers, &userIDs); err != nil {
+		return nil, fmt.Errorf("failed to decode unique users: %w", err)
+	}
+	for _, userID := range userIDs {
+		sketch.Add(userID)
+	}
+	return sketch, nil
 }
 
 type ProxyStats struct {
 	TargetStats      map[string][]TargetStats
+	Conversions      map[string]map[string]*ConversionStats // target ID -> goal ID -> conversions
 	TotalRequests    int64
 	TotalErrors      int64
 	TotalUniqueUsers int64
 }
 
+// ConversionStats are the conversions of a target on a goal. Rate is the
+// number of conversions per unique user of the target, conversions being
+// counted once per visitor within the dedup window of the goal.
+type ConversionStats struct {
+	GoalName    string  `json:"goal_name,omitempty"`
+	Conversions int64   `json:"conversions"`
+	Revenue     float64 `json:"revenue"`
+	Rate        float64 `json:"rate"`
+}
+
 type TargetStats struct {
 	Requests   int32  `json:"requests"`
 	Errors     int32  `json:"errors"`
@@ -54,26 +104,143 @@ func (s *Storage) GetTargetStats(ctx context.Context, start time.Time, end time.
 
 	targetStats := make(map[string][]TargetStats)
 
-	var totalRequests, totalErrors, totalUniqueUsers int32
+	var totalRequests, totalErrors int32
+	// Users of all buckets, so that returning users are counted once
+	targetUsers := make(map[string]*hll.Sketch)
+	totalUsers := hll.New()
 
 	for _, t := range stats {
+		users, err := usersSketch(t.UniqueUsers, t.UsersSketch)
+		if err != nil {
+			return nil, err
+		}
 
 		targetStats[t.TargetID] = append(targetStats[t.TargetID], TargetStats{
 			Requests:   t.Requests,
 			Errors:     t.Errors,
-			UsersCount: t.UsersCount,
+			UsersCount: int32(users.Count()),
 			Timestamp:  t.Timestamp.Time.Format("2006-01-02 15:04:05.000"),
 		})
 
+		if targetUsers[t.TargetID] == nil {
+			targetUsers[t.TargetID] = hll.New()
+		}
+		targetUsers[t.TargetID].Merge(users)
 		totalRequests += t.Requests
 		totalErrors += t.Errors
-		totalUniqueUsers += t.UsersCount
+		totalUsers.Merge(users)
+	}
+
+	conversionRows, err := s.q.GetConversionStats(ctx, &GetConversionStatsParams{
+		ProxyID:  proxyID,
+		FromTime: start.Format("2006-01-02 15:04:05.000"),
+		ToTime:   end.Format("2006-01-02 15:04:05.000"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conversions := make(map[string]map[string]*ConversionStats)
+	for _, c := range conversionRows {
+		if conversions[c.TargetID] == nil {
+			conversions[c.TargetID] = make(map[string]*ConversionStats)
+		}
+		conversionStats := &ConversionStats{
+			Conversions: int64(c.Conversions),
+			Revenue:     c.Revenue,
+		}
+		if users := targetUsers[c.TargetID].Count(); users > 0 {
+			conversionStats.Rate = float64(c.Conversions) / float64(users)
+		}
+		conversions[c.TargetID][c.GoalID] = conversionStats
 	}
 
 	return &ProxyStats{
 		TargetStats:      targetStats,
+		Conversions:      conversions,
 		TotalRequests:    int64(totalRequests),
 		TotalErrors:      int64(totalErrors),
-		TotalUniqueUsers: int64(totalUniqueUsers),
+		TotalUniqueUsers: totalUsers.Count(),
 	}, nil
 }
+
+// TargetTotals are the counters of a target summed over a time range
+type TargetTotals struct {
+	Requests    int64
+	Errors      int64
+	Users       int64
+	Conversions map[string]*ConversionStats // key is goal ID
+}
+
+// GetTargetTotals returns the counters of every target of a proxy with
+// statistics in the time range, users are counted once per target by merging
+// the user sketches of the target
+func (s *Storage) GetTargetTotals(ctx context.Context, start time.Time, end time.Time, proxyID string) (map[string]*TargetTotals, error) {
+	fromTime := start.Format("2006-01-02 15:04:05.000")
+	toTime := end.Format("2006-01-02 15:04:05.000")
+
+	rows, err := s.q.GetTargetTotals(ctx, &GetTargetTotalsParams{
+		ProxyID:  proxyID,
+		FromTime: fromTime,
+		ToTime:   toTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]*TargetTotals, len(rows))
+	for _, row := range rows {
+		totals[row.TargetID] = &TargetTotals{
+			Requests:    row.Requests,
+			Errors:      row.Errors,
+			Conversions: make(map[string]*ConversionStats),
+		}
+	}
+
+	sketchRows, err := s.q.GetTargetUserSketches(ctx, &GetTargetUserSketchesParams{
+		ProxyID:  proxyID,
+		FromTime: fromTime,
+		ToTime:   toTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]*hll.Sketch)
+	for _, row := range sketchRows {
+		sketch, err := usersSketch(row.UniqueUsers, row.UsersSketch)
+		if err != nil {
+			return nil, err
+		}
+		if users[row.TargetID] == nil {
+			users[row.TargetID] = hll.New()
+		}
+		users[row.TargetID].Merge(sketch)
+	}
+	for targetID, sketch := range users {
+		if totals[targetID] != nil {
+			totals[targetID].Users = sketch.Count()
+		}
+	}
+
+	conversionRows, err := s.q.GetConversionStats(ctx, &GetConversionStatsParams{
+		ProxyID:  proxyID,
+		FromTime: fromTime,
+		ToTime:   toTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range conversionRows {
+		if totals[c.TargetID] == nil {
+			totals[c.TargetID] = &TargetTotals{Conversions: make(map[string]*ConversionStats)}
+		}
+		totals[c.TargetID].Conversions[c.GoalID] = &ConversionStats{
+			Conversions: int64(c.Conversions),
+			Revenue:     c.Revenue,
+		}
+	}
+
+	return totals, nil
+}
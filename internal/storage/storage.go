@@ -3,12 +3,14 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/ab-testing-service/internal/models"
@@ -39,19 +41,25 @@ func (s *Storage) SaveVisit(ctx context.Context, visit *models.Visit) error {
 	visit.CreatedAt = time.Now()
 
 	_, err := s.db.Exec(ctx,
-		`INSERT INTO visits (id, proxy_id, target_id, user_id, rid, rrid, ruid, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		`INSERT INTO visits (id, proxy_id, target_id, user_id, rid, rrid, ruid, rule,
+		                    method, host, path, ip, user_agent, referer, language, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
 		visit.ID, visit.ProxyID, visit.TargetID, visit.UserID,
-		visit.RID, visit.RRID, visit.RUID, visit.CreatedAt,
+		visit.RID, visit.RRID, visit.RUID, visit.Rule,
+		visit.Method, visit.Host, visit.Path, visit.IP,
+		visit.UserAgent, visit.Referer, visit.Language, visit.CreatedAt,
 	)
 	return err
 }
 
+const proxyConfigColumns = `id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg,
+		cookies_forwarding_flg, settings, state, config_version`
+
 func (s *Storage) GetProxies(ctx context.Context) ([]proxy.Config, error) {
 	var proxies []proxy.Config
 	rows, err := s.db.Query(ctx,
-		`SELECT id, name, mode, condition, tags, saving_cookies_flg, query_forwarding_flg
-		FROM proxies ORDER BY created_at DESC`,
+		`SELECT `+proxyConfigColumns+`
+		FROM proxies WHERE deleted_at IS NULL ORDER BY created_at DESC`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query proxies: %w", err)
@@ -63,75 +71,133 @@ func (s *Storage) GetProxies(ctx context.Context) ([]proxy.Config, error) {
 	}
 
 	for rows.Next() {
-		// failed to scan proxy: can't scan into dest[1]: cannot scan NULL into *string
-		var p models.Proxy
-		var conditionJSON []byte
-		var name string
-		if err := rows.Scan(&p.ID, &name, &p.Mode, &conditionJSON, &p.Tags, &p.SavingCookiesFlg, &p.QueryForwardingFlg); err != nil {
-			return nil, fmt.Errorf("failed to scan proxy: %w", err)
-		}
-		if len(conditionJSON) > 0 {
-			p.Condition = &models.RouteCondition{}
-			if err := json.Unmarshal(conditionJSON, p.Condition); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal condition: %w", err)
-			}
+		config, err := scanProxyConfig(rows)
+		if err != nil {
+			return nil, err
 		}
+		proxies = append(proxies, config)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate proxies: %w", err)
+	}
+	rows.Close()
 
-		config := proxy.Config{
-			ID:                   p.ID,
-			Name:                 name,
-			Mode:                 p.Mode,
-			Tags:                 p.Tags,
-			SavingCookiesFlg:     p.SavingCookiesFlg,
-			QueryForwardingFlg:   p.QueryForwardingFlg,
-			CookiesForwardingFlg: p.CookiesForwardingFlg,
+	// Fetch ListenURLs from proxy_listen_urls table
+	for i := range proxies {
+		if err := s.loadListenURLs(ctx, &proxies[i]); err != nil {
+			return nil, err
 		}
+	}
+	return proxies, nil
+}
 
-		// Fetch ListenURLs from proxy_listen_urls table
-		listenURLs, err := s.q.GetProxyListenURLs(ctx, p.ID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get listen URLs for proxy %s: %w", p.ID, err)
-		}
+// LoadProxyConfig builds the proxy configuration from PostgreSQL, including
+// listen URLs and targets. It returns ErrProxyNotFound for deleted proxies.
+func (s *Storage) LoadProxyConfig(ctx context.Context, proxyID string) (proxy.Config, error) {
+	row := s.db.QueryRow(ctx,
+		`SELECT `+proxyConfigColumns+`
+		FROM proxies WHERE id = $1 AND deleted_at IS NULL`,
+		proxyID,
+	)
+	config, err := scanProxyConfig(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return proxy.Config{}, ErrProxyNotFound
+	}
+	if err != nil {
+		return proxy.Config{}, err
+	}
 
-		// Map ListenURLs to the models.Proxy and proxy.Config structures
-		for _, listenURL := range listenURLs {
-			// Add to models.Proxy
-			modelListenURL := models.ListenURL{
-				ID:        listenURL.ID,
-				ProxyID:   listenURL.ProxyID,
-				ListenURL: listenURL.ListenUrl,
-				PathKey:   listenURL.PathKey,
-			}
-			p.ListenURLs = append(p.ListenURLs, modelListenURL)
-
-			// Add to proxy.Config
-			configListenURL := proxy.ListenURL{
-				ID:        listenURL.ID,
-				ListenURL: listenURL.ListenUrl,
-				PathKey:   listenURL.PathKey,
-			}
-			config.ListenURLs = append(config.ListenURLs, configListenURL)
-		}
+	if err := s.loadListenURLs(ctx, &config); err != nil {
+		return proxy.Config{}, err
+	}
 
-		condition, err := convertCondition(p.Condition)
-		if err != nil {
-			// Error handling
-			log.Printf("Failed to convert condition for proxy %s: %v", p.ID, err)
-			// Possible options:
-			// 1. Skip this proxy
-			//continue
-			// 2. Return error
-			//return nil, fmt.Errorf("failed to process proxy %s: %w", p.ID, err)
-			// 3. Return nil and continue processing other proxies
-			//config.Condition = nil
-		}
+	targets, err := s.q.GetTargetsByProxyID(ctx, proxyID)
+	if err != nil {
+		return proxy.Config{}, fmt.Errorf("failed to get targets for proxy %s: %w", proxyID, err)
+	}
+	for _, t := range targets {
+		config.Targets = append(config.Targets, proxy.Target{
+			ID:       t.ID,
+			URL:      t.Url,
+			Weight:   t.Weight,
+			IsActive: t.IsActive,
+		})
+	}
 
-		if condition != nil {
-			config.Condition = condition
+	return config, nil
+}
+
+func scanProxyConfig(row pgx.Row) (proxy.Config, error) {
+	// failed to scan proxy: can't scan into dest[1]: cannot scan NULL into *string
+	var p models.Proxy
+	var conditionJSON, settingsJSON []byte
+	var name *string
+	var version int64
+	if err := row.Scan(&p.ID, &name, &p.Mode, &conditionJSON, &p.Tags, &p.SavingCookiesFlg, &p.QueryForwardingFlg,
+		&p.CookiesForwardingFlg, &settingsJSON, &p.State, &version); err != nil {
+		return proxy.Config{}, fmt.Errorf("failed to scan proxy: %w", err)
+	}
+	if len(conditionJSON) > 0 {
+		p.Condition = &models.RouteCondition{}
+		if err := json.Unmarshal(conditionJSON, p.Condition); err != nil {
+			return proxy.Config{}, fmt.Errorf("failed to unmarshal condition: %w", err)
 		}
-		proxies = append(proxies, config)
 	}
-	return proxies, nil
+	if len(settingsJSON) > 0 {
+		if err := json.Unmarshal(settingsJSON, &p.Settings); err != nil {
+			return proxy.Config{}, fmt.Errorf("failed to unmarshal settings: %w", err)
+		}
+	}
+	if name != nil {
+		p.Name = *name
+	}
+
+	config := proxy.Config{
+		ID:                   p.ID,
+		Name:                 p.Name,
+		Mode:                 p.Mode,
+		Tags:                 p.Tags,
+		SavingCookiesFlg:     p.SavingCookiesFlg,
+		QueryForwardingFlg:   p.QueryForwardingFlg,
+		CookiesForwardingFlg: p.CookiesForwardingFlg,
+		Settings:             p.Settings,
+		State:                p.State,
+		Version:              version,
+	}
+
+	condition, err := convertCondition(p.Condition)
+	if err != nil {
+		// Error handling
+		log.Printf("Failed to convert condition for proxy %s: %v", p.ID, err)
+		// Possible options:
+		// 1. Skip this proxy
+		//continue
+		// 2. Return error
+		//return nil, fmt.Errorf("failed to process proxy %s: %w", p.ID, err)
+		// 3. Return nil and continue processing other proxies
+		//config.Condition = nil
+	}
+
+	if condition != nil {
+		config.Condition = condition
+	}
+	return config, nil
+}
+
+func (s *Storage) loadListenURLs(ctx context.Context, config *proxy.Config) error {
+	listenURLs, err := s.q.GetProxyListenURLs(ctx, config.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get listen URLs for proxy %s: %w", config.ID, err)
+	}
+
+	for _, listenURL := range listenURLs {
+		config.ListenURLs = append(config.ListenURLs, proxy.ListenURL{
+			ID:        listenURL.ID,
+			ListenURL: listenURL.ListenUrl,
+			PathKey:   listenURL.PathKey,
+		})
+	}
+	return nil
 }
 
 // Безопасное приведение типов с обработкой ошибок
@@ -140,6 +206,11 @@ func convertCondition(rc *models.RouteCondition) (*proxy.Condition, error) {
 		return nil, nil // Если входной параметр nil, возвращаем nil без ошибки
 	}
 
+	// Targeting rules are checked when saved
+	if !rc.IsLegacy() {
+		return &proxy.Condition{Rules: rc.Rules}, nil
+	}
+
 	// Проверяем поля на корректность
 	if !rc.Type.IsValid() {
 		return nil, fmt.Errorf("invalid condition type: %v", rc.Type)
@@ -56,6 +56,7 @@ func (s *Storage) GetProxiesByTags(ctx context.Context, tags []string) ([]*model
 			Condition:        conditionJSON,
 			Tags:             item.Tags,
 			SavingCookiesFlg: item.SavingCookiesFlg,
+			State:            models.ProxyState(item.State),
 			CreatedAt:        item.CreatedAt.Time,
 			UpdatedAt:        item.UpdatedAt.Time,
 		}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+// MigrateLegacyConditions saves the single conditions of proxies created before
+// targeting rules as rules, see models.RouteCondition.TargetingRules. Running
+// it again, e.g. from another instance, changes nothing. Proxies not migrated
+// yet keep working, their conditions are converted when they are loaded.
+func (s *Storage) MigrateLegacyConditions(ctx context.Context) error {
+	configs, err := s.GetProxies(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, cfg := range configs {
+		if cfg.Condition == nil || len(cfg.Condition.Rules) > 0 || cfg.Condition.Type == "" {
+			continue
+		}
+
+		targets, err := s.q.GetTargetsByProxyID(ctx, cfg.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get targets for proxy %s: %w", cfg.ID, err)
+		}
+		targetIDs := make([]string, len(targets))
+		for i, t := range targets {
+			targetIDs[i] = t.ID
+		}
+
+		legacy := models.RouteCondition{
+			Type:      cfg.Condition.Type,
+			ParamName: cfg.Condition.ParamName,
+			Values:    cfg.Condition.Values,
+			Default:   cfg.Condition.Default,
+			Expr:      cfg.Condition.Expr,
+		}
+		condition := &models.RouteCondition{Rules: legacy.TargetingRules(targetIDs)}
+		if err := s.UpdateProxyCondition(ctx, cfg.ID, condition, nil); err != nil {
+			return fmt.Errorf("failed to migrate condition of proxy %s: %w", cfg.ID, err)
+		}
+		log.Printf("Migrated condition of proxy %s to %d targeting rules", cfg.ID, len(condition.Rules))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+var ErrProxyNotFound = errors.New("proxy not found")
+
+// GetProxyVersions returns the config version of every proxy by ID. Deleted
+// proxies are left out, so that the instances remove them.
+func (s *Storage) GetProxyVersions(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.q.GetProxyVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		versions[row.ID] = row.ConfigVersion
+	}
+	return versions, nil
+}
+
+// DeleteProxy deletes a proxy and returns its new config version. The proxy
+// is kept as a tombstone, with its history, statistics, conversions and
+// visits, and left out of the proxies and versions read by the instances. Its
+// listen URLs are removed so that other proxies can use them.
+func (s *Storage) DeleteProxy(ctx context.Context, id string, createdBy *string) (int64, error) {
+	var version int64
+	err := s.withLockedProxy(ctx, id, func(lp *lockedProxy) error {
+		listenURLs, err := lp.q.GetProxyListenURLs(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get listen URLs: %w", err)
+		}
+		if err := lp.q.DeleteProxyListenURLs(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete listen URLs: %w", err)
+		}
+
+		version, err = lp.q.DeleteProxy(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete proxy: %w", err)
+		}
+		lp.changed = true
+
+		previousState := map[string]interface{}{
+			"state":       lp.State,
+			"listen_urls": listenURLs,
+		}
+		newState := map[string]interface{}{
+			"deleted_at": time.Now(),
+		}
+		return lp.recordChange(ctx, models.ChangeTypeProxyDelete, previousState, newState, createdBy)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrProxyNotFound
+	}
+	return version, err
+}
@@ -1,31 +1,38 @@
 package supervisor
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"sync/atomic"
 
 	"github.com/ab-testing-service/internal/proxy"
 )
 
+// VirtualHostHandler routes the requests of the listeners to the proxies with
+// the last published routing snapshot
 type VirtualHostHandler struct {
-	proxies     map[string]*proxy.Proxy // host -> proxy mapping
-	pathProxies map[string]*proxy.Proxy // path -> proxy mapping
+	snapshot atomic.Pointer[routingSnapshot]
+}
+
+func newVirtualHostHandler() *VirtualHostHandler {
+	vh := &VirtualHostHandler{}
+	vh.snapshot.Store(newRoutingSnapshot())
+	return vh
 }
 
 func (vh *VirtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := vh.snapshot.Load()
+
 	// First try path-based routing
-	path := r.URL.Path
 	log.Printf("host: %s, path request: %s\n", r.Host, r.URL.Path)
-	for pathKey, p := range vh.pathProxies {
-		if strings.HasPrefix(path, "/"+pathKey) {
-			// Remove the path key from the request path before proxying
-			r.URL.Path = strings.TrimPrefix(path, "/"+pathKey)
-			log.Printf("path: %s, key: %s, path request: %s\n", path, pathKey, r.URL.Path)
-			p.ServeHTTP(w, r)
-			return
-		}
+	if route, rest := snapshot.routes.match(r.URL.Path); route != nil {
+		// Remove the path key from the request path before proxying
+		r.URL.Path, r.URL.RawPath = rest, ""
+		log.Printf("route: %s, path request: %s\n", route, r.URL.Path)
+		route.proxy.ServeHTTP(w, r)
+		return
 	}
 
 	// If no path match, try host-based routing
@@ -35,73 +42,67 @@ func (vh *VirtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	}
 
 	log.Printf("method: %s, host request: %s\n", r.Method, r.Host)
-	host := strings.Split(r.Host, ":")[0]
-	if p, ok := vh.proxies[host]; ok {
-		p.ServeHTTP(w, r)
+	scheme, host, port := requestAddress(r)
+	if route := snapshot.hosts.match(scheme, host, port, r.URL.Path); route != nil {
+		route.proxy.ServeHTTP(w, r)
 	} else {
 		http.Error(w, fmt.Sprintf("host %s not found", host), http.StatusNotFound)
 	}
 }
 
-func (s *Supervisor) CreateProxy(cfg proxy.Config) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	// Initialize virtual handler if needed
-	if s.virtualHandler == nil {
-		s.virtualHandler = &VirtualHostHandler{
-			proxies:     make(map[string]*proxy.Proxy),
-			pathProxies: make(map[string]*proxy.Proxy),
+// listenRoutes returns the path routes and the host routes of listen URLs,
+// listen URLs with a path key are routed by path
+func listenRoutes(proxyID string, listenURLs []proxy.ListenURL) ([]*Route, []*hostRoute, error) {
+	var routes []*Route
+	var hosts []*hostRoute
+	for _, listenURL := range listenURLs {
+		if listenURL.PathKey != nil {
+			route, err := ParseRoute(*listenURL.PathKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			route.ProxyID = proxyID
+			routes = append(routes, &route)
+			continue
+		}
+		addr, err := proxy.ParseListenURL(listenURL.ListenURL)
+		if err != nil {
+			return nil, nil, err
 		}
+		hosts = append(hosts, &hostRoute{ListenAddress: addr, ProxyID: proxyID})
 	}
+	return routes, hosts, nil
+}
 
-	p, err := proxy.NewProxy(cfg)
+// CheckRoutes reports the conflicts of listen URLs with the routes of the
+// other running proxies, so that they are known before the listen URLs are
+// saved. proxyID is empty for new proxies.
+func (s *Supervisor) CheckRoutes(proxyID string, listenURLs []proxy.ListenURL) ([]RouteConflict, error) {
+	routes, hosts, err := listenRoutes(proxyID, listenURLs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	instance := &ProxyInstance{
-		Proxy: p,
+	snapshot := s.virtualHandler.snapshot.Load()
+	var conflicts []RouteConflict
+	for _, route := range routes {
+		conflicts = append(conflicts, snapshot.routes.conflicts(route)...)
 	}
-	s.proxies[cfg.ID] = instance
-
-	// Handle each listen URL
-	for _, listenURL := range cfg.ListenURLs {
-		if listenURL.PathKey != nil {
-			// Handle path-based routing
-			if _, exists := s.virtualHandler.pathProxies[*listenURL.PathKey]; exists {
-				return fmt.Errorf("proxy with path key %s already exists", *listenURL.PathKey)
-			}
-			s.virtualHandler.pathProxies[*listenURL.PathKey] = p
-		} else {
-			// Handle host-based routing
-			host := strings.Split(listenURL.ListenURL, ":")[0]
-			if host == "" {
-				return fmt.Errorf("invalid listen URL: %s", listenURL.ListenURL)
-			}
-			if _, exists := s.virtualHandler.proxies[host]; exists {
-				return fmt.Errorf("proxy with host %s already exists", host)
-			}
-			s.virtualHandler.proxies[host] = p
-		}
+	for _, host := range hosts {
+		conflicts = append(conflicts, snapshot.hosts.conflicts(host)...)
 	}
+	return conflicts, nil
+}
 
-	instance.Started = true
-
-	// Start the server if not already running
-	if s.server == nil {
-		s.server = &http.Server{
-			Addr:    ":80", // Default port, should be configurable
-			Handler: s.virtualHandler,
-		}
-
-		go func() {
-			if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				// Log error but don't stop the server
-				log.Printf("main HTTP server error: %v\n", err)
-			}
-		}()
+// CreateProxy starts a new proxy and broadcasts it to the other instances
+func (s *Supervisor) CreateProxy(ctx context.Context, cfg proxy.Config) error {
+	if _, err := s.applyConfig(cfg); err != nil {
+		return err
 	}
 
+	// Publish change to other instances
+	if err := s.pubsub.PublishChange(ctx, proxy.OperationCreate, cfg.ID, cfg.Version); err != nil {
+		log.Printf("Failed to publish create of proxy %s: %v", cfg.ID, err)
+	}
 	return nil
 }
@@ -0,0 +1,163 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/notify"
+	"github.com/ab-testing-service/internal/proxy"
+	"github.com/ab-testing-service/internal/storage"
+)
+
+const guardrailTick = 30 * time.Second
+
+// runGuardrails evaluates the proxy guardrails until the context is canceled
+func (s *Supervisor) runGuardrails(ctx context.Context) {
+	ticker := time.NewTicker(guardrailTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, cfg := range s.guardedProxies() {
+				s.checkGuardrails(ctx, cfg)
+			}
+		}
+	}
+}
+
+// guardedProxies returns the configs of the proxies with guardrails
+func (s *Supervisor) guardedProxies() []proxy.Config {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var configs []proxy.Config
+	for _, instance := range s.proxies {
+		if instance.Proxy == nil || instance.Proxy.State() != models.ProxyStateRunning {
+			continue
+		}
+		if len(instance.Proxy.Config.Settings.Guardrails) > 0 {
+			configs = append(configs, instance.Proxy.Config)
+		}
+	}
+	return configs
+}
+
+// checkGuardrails evaluates the guardrails of a proxy on the statistics every
+// instance reported, and rolls back the targets that trip them
+func (s *Supervisor) checkGuardrails(ctx context.Context, cfg proxy.Config) {
+	now := time.Now()
+	settings := cfg.Settings
+	controlID := settings.ControlTarget(configTargetModels(cfg.Targets))
+
+	open := make(map[string]bool)
+	for _, trip := range settings.GuardrailTrips {
+		if trip.RevertedAt == nil {
+			open[trip.GuardrailID+"|"+trip.TargetID] = true
+		}
+	}
+
+	for _, guardrail := range settings.Guardrails {
+		window := time.Duration(guardrail.Window) * time.Minute
+		start := now.Add(-window)
+		if guardrail.ArmedAt.After(start) {
+			if guardrail.Metric == models.GuardrailMetricTraffic {
+				continue // Not armed for a whole window yet
+			}
+			start = guardrail.ArmedAt
+		}
+
+		totals, err := s.storage.GetTargetTotals(ctx, start, now, cfg.ID)
+		if err != nil {
+			log.Printf("Failed to get statistics of proxy %s for guardrails: %v", cfg.ID, err)
+			return
+		}
+
+		for _, target := range cfg.Targets {
+			if target.ID == controlID || !target.IsActive || target.Weight <= 0 || open[guardrail.ID+"|"+target.ID] {
+				continue
+			}
+
+			reason, value, tripped := evaluateGuardrail(guardrail, totals[target.ID])
+			if !tripped {
+				continue
+			}
+			if s.tripGuardrail(ctx, cfg, guardrail, target, reason, value) {
+				// The targets changed, the next round evaluates the new config
+				return
+			}
+		}
+	}
+}
+
+// evaluateGuardrail returns the reason the target trips the guardrail and the observed value.
+// Errors are the failed and 5xx responses of proxy mode, error_rate guardrails
+// are rejected on proxies redirecting their visitors.
+func evaluateGuardrail(guardrail models.Guardrail, totals *storage.TargetTotals) (string, float64, bool) {
+	var requests, errors int64
+	if totals != nil {
+		requests, errors = totals.Requests, totals.Errors
+	}
+
+	switch guardrail.Metric {
+	case models.GuardrailMetricErrorRate:
+		if requests == 0 || requests < guardrail.MinRequests {
+			return "", 0, false
+		}
+		rate := float64(errors) / float64(requests) * 100
+		if rate > guardrail.Threshold {
+			return fmt.Sprintf("error rate %.2f%% exceeds %.2f%% over %d minutes (%d errors out of %d requests)",
+				rate, guardrail.Threshold, guardrail.Window, errors, requests), rate, true
+		}
+	case models.GuardrailMetricTraffic:
+		if float64(requests) < guardrail.Threshold {
+			return fmt.Sprintf("%d requests over %d minutes, below %.0f",
+				requests, guardrail.Window, guardrail.Threshold), float64(requests), true
+		}
+	}
+	return "", 0, false
+}
+
+// tripGuardrail applies the rollback, reloads and broadcasts the proxy and
+// sends a notification. It reports whether the rollback was applied by this call.
+func (s *Supervisor) tripGuardrail(ctx context.Context, cfg proxy.Config, guardrail models.Guardrail,
+	target proxy.Target, reason string, value float64) bool {
+	reason = fmt.Sprintf("guardrail %s: target %s: %s", guardrail.Name, target.URL, reason)
+
+	trip, err := s.storage.TripGuardrail(ctx, cfg.ID, guardrail, target.ID, reason, value)
+	if err != nil {
+		log.Printf("Failed to roll back target %s of proxy %s: %v", target.ID, cfg.ID, err)
+		return false
+	}
+	if trip == nil {
+		return false // Already rolled back by another instance
+	}
+
+	updated, err := s.storage.GetProxyConfig(ctx, cfg.ID)
+	if err != nil {
+		log.Printf("Failed to get proxy config %s: %v", cfg.ID, err)
+	} else if err := s.UpdateProxy(ctx, updated); err != nil {
+		log.Printf("Failed to update proxy %s: %v", cfg.ID, err)
+	}
+
+	s.notifier.Notify(ctx, notify.Event{
+		Type:    "guardrail_trip",
+		ProxyID: cfg.ID,
+		Text:    fmt.Sprintf("Proxy %s rolled back (%s), %s", cfg.Name, guardrail.Action, reason),
+		Details: trip,
+	})
+	return true
+}
+
+func configTargetModels(targets []proxy.Target) []models.Target {
+	result := make([]models.Target, len(targets))
+	for i, t := range targets {
+		result[i] = models.Target{ID: t.ID, URL: t.URL, Weight: t.Weight, IsActive: t.IsActive}
+	}
+	return result
+}
@@ -0,0 +1,200 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+const (
+	healthCheckTick = time.Second
+	// healthStateTTL bounds how long the last recorded health of a target is
+	// remembered in Redis to record a transition only once across instances
+	healthStateTTL = 24 * time.Hour
+)
+
+type healthTask struct {
+	proxyID string
+	target  proxy.Target
+	check   *proxy.HealthCheck
+}
+
+// healthChecker schedules the health checks of the active targets of the
+// proxies that enable them, one check per target at a time
+type healthChecker struct {
+	client    *http.Client
+	mutex     sync.Mutex
+	nextCheck map[string]time.Time  // target ID -> time of the next check
+	running   map[string]bool       // target ID -> check in flight
+	checked   map[string]healthTask // target ID -> last scheduled check
+}
+
+func newHealthChecker() *healthChecker {
+	return &healthChecker{
+		client:    proxy.NewHealthCheckClient(),
+		nextCheck: make(map[string]time.Time),
+		running:   make(map[string]bool),
+		checked:   make(map[string]healthTask),
+	}
+}
+
+// runHealthChecks checks the targets until the context is canceled
+func (s *Supervisor) runHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, task := range s.health.due(s.runtime.Health, s.healthTasks()) {
+				go s.checkTarget(ctx, task)
+			}
+		}
+	}
+}
+
+// healthTasks lists the targets to check
+func (s *Supervisor) healthTasks() []healthTask {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var tasks []healthTask
+	for id, instance := range s.proxies {
+		if !instance.Started || instance.Proxy == nil {
+			continue
+		}
+		// Draft and archived proxies serve no traffic
+		if state := instance.Proxy.State(); state == models.ProxyStateDraft || state == models.ProxyStateArchived {
+			continue
+		}
+		check := proxy.NewHealthCheck(instance.Proxy.Config.Settings.HealthCheck)
+		if check == nil {
+			continue
+		}
+		for _, target := range instance.Proxy.Config.Targets {
+			if target.IsActive {
+				tasks = append(tasks, healthTask{proxyID: id, target: target, check: check})
+			}
+		}
+	}
+	return tasks
+}
+
+// due returns the tasks whose check is due and marks them running. Targets no
+// longer checked are forgotten, they become healthy again.
+func (hc *healthChecker) due(registry *proxy.HealthRegistry, tasks []healthTask) []healthTask {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	now := time.Now()
+	current := make(map[string]bool, len(tasks))
+	var due []healthTask
+	for _, task := range tasks {
+		id := task.target.ID
+		current[id] = true
+		hc.checked[id] = task
+
+		if hc.running[id] || now.Before(hc.nextCheck[id]) {
+			continue
+		}
+		hc.running[id] = true
+		hc.nextCheck[id] = now.Add(task.check.Interval)
+		due = append(due, task)
+	}
+
+	for id, task := range hc.checked {
+		if !current[id] {
+			registry.Forget(task.proxyID, task.target)
+			delete(hc.checked, id)
+			delete(hc.nextCheck, id)
+		}
+	}
+	return due
+}
+
+// finish records the result of a check, unless the target was forgotten while
+// it was running
+func (hc *healthChecker) finish(registry *proxy.HealthRegistry, task healthTask, checkErr error) (proxy.TargetHealth, bool, bool) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	delete(hc.running, task.target.ID)
+	if _, ok := hc.checked[task.target.ID]; !ok {
+		return proxy.TargetHealth{}, false, false
+	}
+	state, changed := registry.Report(task.proxyID, task.target, task.check, checkErr)
+	return state, changed, true
+}
+
+func (s *Supervisor) checkTarget(ctx context.Context, task healthTask) {
+	checkErr := task.check.Check(ctx, s.health.client, task.target)
+	if ctx.Err() != nil {
+		return
+	}
+
+	state, changed, ok := s.health.finish(s.runtime.Health, task, checkErr)
+	if !ok || !changed {
+		return
+	}
+
+	if state.Healthy {
+		log.Printf("Target %s (%s) of proxy %s is healthy again", task.target.ID, task.target.URL, task.proxyID)
+	} else {
+		log.Printf("Target %s (%s) of proxy %s is unhealthy: %s", task.target.ID, task.target.URL, task.proxyID, state.LastError)
+	}
+
+	if err := s.recordHealthTransition(ctx, task, state); err != nil {
+		log.Printf("Failed to record health transition of target %s: %v", task.target.ID, err)
+	}
+}
+
+// recordHealthTransition adds the transition to the proxy history. Every
+// instance checks the targets, the last recorded state is kept in Redis so that
+// a transition is recorded by the first instance that sees it.
+func (s *Supervisor) recordHealthTransition(ctx context.Context, task healthTask, state proxy.TargetHealth) error {
+	key := fmt.Sprintf("ab:health:%s:%s", task.proxyID, task.target.ID)
+	value := healthStateValue(state.Healthy)
+
+	previous, err := s.storage.Redis.GetSet(ctx, key, value).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to save health state: %w", err)
+	}
+	s.storage.Redis.Expire(ctx, key, healthStateTTL)
+	if previous == value || (previous == "" && state.Healthy) {
+		// Already recorded by another instance, or healthy from the start
+		return nil
+	}
+
+	previousState := map[string]interface{}{
+		"target_id": task.target.ID,
+		"url":       task.target.URL,
+		"healthy":   !state.Healthy,
+	}
+	newState := map[string]interface{}{
+		"target_id":             task.target.ID,
+		"url":                   task.target.URL,
+		"healthy":               state.Healthy,
+		"consecutive_failures":  state.ConsecutiveFailures,
+		"consecutive_successes": state.ConsecutiveSuccesses,
+		"last_error":            state.LastError,
+	}
+	return s.storage.RecordProxyChange(ctx, task.proxyID, models.ChangeTypeTargetHealth, previousState, newState, nil)
+}
+
+func healthStateValue(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
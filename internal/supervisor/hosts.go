@@ -0,0 +1,143 @@
+package supervisor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// hostRoute routes the requests of a listen address to a proxy
+type hostRoute struct {
+	proxy.ListenAddress
+	ProxyID string
+
+	proxy *proxy.Proxy
+}
+
+// matches reports whether the route serves a request, the host is matched by
+// hostTable
+func (r *hostRoute) matches(scheme, port, requestPath string) bool {
+	return (r.Scheme == "" || r.Scheme == scheme) &&
+		(r.Port == "" || r.Port == port) &&
+		(r.Path == "" || requestPath == r.Path || strings.HasPrefix(requestPath, r.Path+"/"))
+}
+
+// hostTable holds the listen addresses of the running proxies. A request goes
+// to the most specific listen host matching its host, see proxy.HostPatterns,
+// and under that host to the longest matching path, then to the listen
+// address with a port, then with a scheme.
+type hostTable struct {
+	routes map[string][]*hostRoute // by listen host, most specific first
+}
+
+func newHostTable() *hostTable {
+	return &hostTable{routes: make(map[string][]*hostRoute)}
+}
+
+// requestAddress returns the scheme, host and port a request was sent to, the
+// port defaults to the one of the scheme
+func requestAddress(r *http.Request) (string, string, string) {
+	scheme, port := "http", "80"
+	if r.TLS != nil {
+		scheme, port = "https", "443"
+	}
+	host, p, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	} else if p != "" {
+		port = p
+	}
+	return scheme, strings.Trim(host, "[]"), port
+}
+
+// match returns the route of a request, nil when no route matches
+func (t *hostTable) match(scheme, host, port, requestPath string) *hostRoute {
+	for _, pattern := range proxy.HostPatterns(host) {
+		for _, route := range t.routes[pattern] {
+			if route.matches(scheme, port, requestPath) {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the route with the same listen address
+func (t *hostTable) lookup(addr proxy.ListenAddress) *hostRoute {
+	for _, route := range t.routes[addr.Host] {
+		if route.ListenAddress == addr {
+			return route
+		}
+	}
+	return nil
+}
+
+// add registers a route, it fails when another proxy has the same listen
+// address
+func (t *hostTable) add(route *hostRoute) error {
+	if other := t.lookup(route.ListenAddress); other != nil {
+		if other.ProxyID != route.ProxyID {
+			return fmt.Errorf("proxy with listen URL %s already exists", route.ListenAddress)
+		}
+		return nil
+	}
+
+	routes := append(t.routes[route.Host], route)
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if len(a.Path) != len(b.Path) {
+			return len(a.Path) > len(b.Path)
+		}
+		if (a.Port != "") != (b.Port != "") {
+			return a.Port != ""
+		}
+		return a.Scheme != "" && b.Scheme == ""
+	})
+	t.routes[route.Host] = routes
+	return nil
+}
+
+// conflicts returns the conflicts of a route with the routes of the other
+// proxies on the same listen host. Listen hosts of different specificity don't
+// conflict, the most specific one wins.
+func (t *hostTable) conflicts(route *hostRoute) []RouteConflict {
+	var conflicts []RouteConflict
+	for _, other := range t.routes[route.Host] {
+		if other.ProxyID == route.ProxyID {
+			continue
+		}
+		conflict := RouteConflict{
+			Route:   route.ListenAddress.String(),
+			With:    other.ListenAddress.String(),
+			ProxyID: other.ProxyID,
+		}
+		switch {
+		case other.ListenAddress == route.ListenAddress:
+			conflict.Ambiguous = true
+			conflict.Reason = "same listen URL"
+		case addressesOverlap(route.ListenAddress, other.ListenAddress):
+			conflict.Reason = "overlapping listen URLs, the most specific one wins"
+		default:
+			continue
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// addressesOverlap reports whether two listen addresses of the same host can
+// match the same request
+func addressesOverlap(a, b proxy.ListenAddress) bool {
+	if a.Scheme != "" && b.Scheme != "" && a.Scheme != b.Scheme {
+		return false
+	}
+	if a.Port != "" && b.Port != "" && a.Port != b.Port {
+		return false
+	}
+	return a.Path == b.Path || a.Path == "" || b.Path == "" ||
+		isPathPrefix(a.Path, b.Path) || isPathPrefix(b.Path, a.Path)
+}
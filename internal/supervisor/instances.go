@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+const defaultHeartbeatInterval = 10 * time.Second
+
+// InstanceStatus is a registered service instance with the proxies it runs in
+// another version than PostgreSQL, as of its last heartbeat
+type InstanceStatus struct {
+	models.Instance
+	Current  bool     `json:"current"` // the instance answering the request
+	Drift    bool     `json:"drift"`
+	Outdated []string `json:"outdated,omitempty"` // proxies running another config version
+	Missing  []string `json:"missing,omitempty"`  // proxies not running
+	Deleted  []string `json:"deleted,omitempty"`  // deleted proxies still running
+}
+
+// heartbeatTiming returns the interval between heartbeats and how long an
+// instance stays registered without one
+func (s *Supervisor) heartbeatTiming() (time.Duration, time.Duration) {
+	interval := time.Duration(s.config.Instances.HeartbeatInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	ttl := time.Duration(s.config.Instances.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = 3 * interval
+	}
+	return interval, ttl
+}
+
+// runHeartbeat keeps this instance registered with the config versions it
+// runs until the context is canceled
+func (s *Supervisor) runHeartbeat(ctx context.Context) {
+	interval, ttl := s.heartbeatTiming()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.heartbeat(ctx, ttl)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.heartbeat(ctx, ttl)
+		}
+	}
+}
+
+func (s *Supervisor) heartbeat(ctx context.Context, ttl time.Duration) {
+	instance := s.instance
+	instance.Heartbeat = time.Now()
+	instance.ConfigVersions = s.appliedVersions()
+	if err := s.storage.SaveInstance(ctx, &instance, ttl); err != nil {
+		log.Printf("Failed to register instance %s: %v", instance.ID, err)
+	}
+}
+
+// Instances returns the live service instances with their drift from the
+// proxy configs in PostgreSQL. The config versions of this instance are the
+// ones running now, the other instances report theirs with every heartbeat.
+func (s *Supervisor) Instances(ctx context.Context) ([]InstanceStatus, error) {
+	instances, err := s.storage.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := s.storage.GetProxyVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]InstanceStatus, 0, len(instances))
+	for _, instance := range instances {
+		status := InstanceStatus{Instance: instance, Current: instance.ID == s.instance.ID}
+		if status.Current {
+			status.ConfigVersions = s.appliedVersions()
+		}
+
+		for id, version := range desired {
+			running, ok := status.ConfigVersions[id]
+			switch {
+			case !ok:
+				status.Missing = append(status.Missing, id)
+			case running != version:
+				status.Outdated = append(status.Outdated, id)
+			}
+		}
+		for id := range status.ConfigVersions {
+			if _, ok := desired[id]; !ok {
+				status.Deleted = append(status.Deleted, id)
+			}
+		}
+		sort.Strings(status.Missing)
+		sort.Strings(status.Outdated)
+		sort.Strings(status.Deleted)
+		status.Drift = len(status.Missing)+len(status.Outdated)+len(status.Deleted) > 0
+
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -8,10 +8,10 @@ import (
 	"github.com/ab-testing-service/internal/proxy"
 )
 
+// GetProxy returns a running proxy. It reads the routing snapshot, so it never
+// waits for changes in progress.
 func (s *Supervisor) GetProxy(id string) *proxy.Proxy {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return s.proxies[id].Proxy
+	return s.virtualHandler.snapshot.Load().proxies[id]
 }
 
 func (s *Supervisor) ListProxies(ctx context.Context, sortBy string, sortDesc bool) []proxy.Config {
@@ -25,15 +25,9 @@ func (s *Supervisor) ListProxies(ctx context.Context, sortBy string, sortDesc bo
 			log.Printf("Failed to get tags for proxy %s: %v", id, err)
 			continue
 		}
-		configs = append(configs, proxy.Config{
-			ID:         id,
-			Name:       p.Proxy.Name,
-			ListenURLs: p.Proxy.ListenURLs,
-			Mode:       p.Proxy.Mode,
-			Targets:    p.Proxy.Targets,
-			Condition:  p.Proxy.Config.Condition,
-			Tags:       tags,
-		})
+		cfg := p.Proxy.Config
+		cfg.Tags = tags
+		configs = append(configs, cfg)
 	}
 
 	// Sort the configs based on the sortBy parameter
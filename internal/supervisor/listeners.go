@@ -0,0 +1,82 @@
+package supervisor
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ab-testing-service/internal/config"
+)
+
+// defaultListener serves the proxies when no listener is configured
+var defaultListener = config.Listener{Address: ":80"}
+
+// startListeners starts serving the proxies on the configured listeners
+func (s *Supervisor) startListeners() {
+	listeners := s.config.Listeners
+	if len(listeners) == 0 {
+		listeners = []config.Listener{defaultListener}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, l := range listeners {
+		server := &http.Server{
+			Addr:              l.Address,
+			Handler:           s.virtualHandler,
+			ReadTimeout:       seconds(l.ReadTimeout),
+			ReadHeaderTimeout: seconds(l.ReadHeaderTimeout),
+			WriteTimeout:      seconds(l.WriteTimeout),
+			IdleTimeout:       seconds(l.IdleTimeout),
+			MaxHeaderBytes:    l.MaxHeaderBytes,
+		}
+		if l.RedirectHTTPS {
+			server.Handler = redirectHTTPS(l.RedirectPort)
+		}
+		if l.TLS {
+			server.TLSConfig = &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: s.certs.GetCertificate,
+			}
+		}
+		s.servers = append(s.servers, server)
+
+		go func(l config.Listener) {
+			log.Printf("Serving proxies on %s (tls: %t, redirect to https: %t)", l.Address, l.TLS, l.RedirectHTTPS)
+			var err error
+			if l.TLS {
+				// Certificates come from the TLS config
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Proxy listener %s error: %v", l.Address, err)
+			}
+		}(l)
+	}
+}
+
+// redirectHTTPS redirects requests to the same URL over https
+func redirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, host, _ := requestAddress(r)
+		if port != 0 && port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
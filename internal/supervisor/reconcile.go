@@ -0,0 +1,83 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ab-testing-service/internal/storage"
+)
+
+const defaultReconcileInterval = 30 * time.Second
+
+// runReconciler converges the running proxies with PostgreSQL until the
+// context is canceled, catching up the changes whose messages were lost
+func (s *Supervisor) runReconciler(ctx context.Context) {
+	interval := time.Duration(s.config.Proxy.ReconcileInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reconcile(ctx)
+		}
+	}
+}
+
+// reconcile compares the config versions of the running proxies with the
+// versions in PostgreSQL: deleted proxies are stopped, missing ones started
+// and outdated ones reloaded
+func (s *Supervisor) reconcile(ctx context.Context) {
+	// Taken before the versions in PostgreSQL, so that proxies created in
+	// between are not taken for deleted ones
+	applied := s.appliedVersions()
+
+	versions, err := s.storage.GetProxyVersions(ctx)
+	if err != nil {
+		log.Printf("Failed to get proxy versions: %v", err)
+		return
+	}
+
+	// Deleted proxies are tombstones left out of the versions
+	for id := range applied {
+		if _, ok := versions[id]; ok {
+			continue
+		}
+		if err := s.removeProxy(ctx, id); err != nil {
+			log.Printf("Failed to remove deleted proxy %s: %v", id, err)
+			continue
+		}
+		log.Printf("Reconciled proxy %s: deleted", id)
+	}
+
+	for id, version := range versions {
+		if current, ok := applied[id]; ok && current >= version {
+			continue
+		}
+
+		cfg, err := s.storage.LoadProxyConfig(ctx, id)
+		if errors.Is(err, storage.ErrProxyNotFound) {
+			continue // Deleted meanwhile, removed on the next run
+		}
+		if err != nil {
+			log.Printf("Failed to load proxy config %s: %v", id, err)
+			continue
+		}
+		changed, err := s.applyConfig(cfg)
+		if err != nil {
+			log.Printf("Failed to apply proxy config %s: %v", id, err)
+			continue
+		}
+		if changed {
+			log.Printf("Reconciled proxy %s: version %d", id, cfg.Version)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// RouteKind tells how a path route matches request paths
+type RouteKind string
+
+const (
+	RoutePrefix RouteKind = "prefix" // the path starts with the route, on a segment boundary
+	RouteExact  RouteKind = "exact"  // the path is the route, with or without a trailing slash
+	RouteRegex  RouteKind = "regex"  // the first segments of the path match the regular expression
+)
+
+// Route is the path route of a path key. Path keys are prefixes, "=" makes
+// them exact and "~" regular expressions: "shop/promo" routes "/shop/promo/42",
+// "=checkout" only "/checkout" and "~v[0-9]+" routes "/v2/items".
+//
+// Requests are routed by the exact route of their path, then by their longest
+// prefix route, then by the first matching regular expression in lexical
+// order. The matched part of the path is removed before proxying.
+type Route struct {
+	Kind    RouteKind `json:"kind"`
+	Pattern string    `json:"pattern"` // path with a leading slash, or the regular expression
+	ProxyID string    `json:"proxy_id,omitempty"`
+
+	re    *regexp.Regexp
+	proxy *proxy.Proxy
+}
+
+// ParseRoute returns the route of a path key
+func ParseRoute(pathKey string) (Route, error) {
+	switch {
+	case strings.HasPrefix(pathKey, "~"):
+		pattern := strings.TrimPrefix(pathKey, "~")
+		if pattern == "" {
+			return Route{}, errors.New("path key regular expression is empty")
+		}
+		// Anchored at the start of the path, without its leading slash
+		re, err := regexp.Compile("^(?:" + pattern + ")")
+		if err != nil {
+			return Route{}, fmt.Errorf("invalid path key regular expression: %w", err)
+		}
+		return Route{Kind: RouteRegex, Pattern: pattern, re: re}, nil
+	case strings.HasPrefix(pathKey, "="):
+		p, err := cleanRoutePath(strings.TrimPrefix(pathKey, "="))
+		if err != nil {
+			return Route{}, err
+		}
+		return Route{Kind: RouteExact, Pattern: p}, nil
+	default:
+		p, err := cleanRoutePath(pathKey)
+		if err != nil {
+			return Route{}, err
+		}
+		return Route{Kind: RoutePrefix, Pattern: p}, nil
+	}
+}
+
+func cleanRoutePath(key string) (string, error) {
+	if strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid path key %q", key)
+	}
+	p := path.Clean("/" + key)
+	if p == "/" {
+		return "", errors.New("path key is empty")
+	}
+	return p, nil
+}
+
+// String returns the path key of the route
+func (r *Route) String() string {
+	switch r.Kind {
+	case RouteExact:
+		return "=" + strings.TrimPrefix(r.Pattern, "/")
+	case RouteRegex:
+		return "~" + r.Pattern
+	}
+	return strings.TrimPrefix(r.Pattern, "/")
+}
+
+// match returns the length of the path part matched by a regex route, or -1.
+// The match ends on a segment boundary.
+func (r *Route) match(requestPath string) int {
+	trimmed := strings.TrimPrefix(requestPath, "/")
+	loc := r.re.FindStringIndex(trimmed)
+	if loc == nil || loc[1] == 0 {
+		return -1
+	}
+	if loc[1] < len(trimmed) && trimmed[loc[1]] != '/' {
+		return -1
+	}
+	return len(requestPath) - len(trimmed) + loc[1]
+}
+
+// routeTable holds the path routes of the running proxies
+type routeTable struct {
+	exact    map[string]*Route
+	prefixes map[string]*Route
+	regexes  []*Route // sorted by pattern
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		exact:    make(map[string]*Route),
+		prefixes: make(map[string]*Route),
+	}
+}
+
+// match returns the route of a request path and the path left after the
+// route, nil when no route matches
+func (t *routeTable) match(requestPath string) (*Route, string) {
+	trimmed := requestPath
+	if len(trimmed) > 1 {
+		trimmed = strings.TrimSuffix(trimmed, "/")
+	}
+
+	if route, ok := t.exact[trimmed]; ok {
+		return route, "/"
+	}
+
+	// Longest prefix first, cutting the path one segment at a time
+	for candidate := trimmed; candidate != ""; candidate = candidate[:strings.LastIndex(candidate, "/")] {
+		if route, ok := t.prefixes[candidate]; ok {
+			return route, restOfPath(requestPath, len(candidate))
+		}
+	}
+
+	for _, route := range t.regexes {
+		if n := route.match(requestPath); n >= 0 {
+			return route, restOfPath(requestPath, n)
+		}
+	}
+	return nil, ""
+}
+
+func restOfPath(requestPath string, matched int) string {
+	rest := requestPath[matched:]
+	if rest == "" {
+		return "/"
+	}
+	return rest
+}
+
+// lookup returns the route with the same kind and pattern
+func (t *routeTable) lookup(route *Route) *Route {
+	switch route.Kind {
+	case RouteExact:
+		return t.exact[route.Pattern]
+	case RoutePrefix:
+		return t.prefixes[route.Pattern]
+	}
+	for _, other := range t.regexes {
+		if other.Pattern == route.Pattern {
+			return other
+		}
+	}
+	return nil
+}
+
+// add registers a route, it fails when another proxy has the same route
+func (t *routeTable) add(route *Route) error {
+	if other := t.lookup(route); other != nil {
+		if other.ProxyID != route.ProxyID {
+			return fmt.Errorf("path key %s is already used by proxy %s", route, other.ProxyID)
+		}
+		return nil
+	}
+
+	switch route.Kind {
+	case RouteExact:
+		t.exact[route.Pattern] = route
+	case RoutePrefix:
+		t.prefixes[route.Pattern] = route
+	default:
+		t.regexes = append(t.regexes, route)
+		sort.Slice(t.regexes, func(i, j int) bool {
+			return t.regexes[i].Pattern < t.regexes[j].Pattern
+		})
+	}
+	return nil
+}
+
+// RouteConflict is a route of a proxy overlapping the route of another proxy.
+// Ambiguous routes can't both be served, overlapping routes are served by the
+// precedence of the routing table, see Route.
+type RouteConflict struct {
+	Route     string `json:"route"`
+	With      string `json:"with"`
+	ProxyID   string `json:"proxy_id"` // the proxy of With
+	Ambiguous bool   `json:"ambiguous"`
+	Reason    string `json:"reason"`
+}
+
+// conflicts returns the conflicts of a route with the routes of the other
+// proxies
+func (t *routeTable) conflicts(route *Route) []RouteConflict {
+	var conflicts []RouteConflict
+	check := func(other *Route) {
+		if other.ProxyID == route.ProxyID {
+			return
+		}
+		ambiguous, reason := overlap(route, other)
+		if reason == "" {
+			return
+		}
+		conflicts = append(conflicts, RouteConflict{
+			Route:     route.String(),
+			With:      other.String(),
+			ProxyID:   other.ProxyID,
+			Ambiguous: ambiguous,
+			Reason:    reason,
+		})
+	}
+
+	for _, other := range t.exact {
+		check(other)
+	}
+	for _, other := range t.prefixes {
+		check(other)
+	}
+	for _, other := range t.regexes {
+		check(other)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].With < conflicts[j].With
+	})
+	return conflicts
+}
+
+// overlap tells whether two routes are ambiguous or overlap and why, the
+// reason is empty when they are independent
+func overlap(a, b *Route) (bool, string) {
+	if a.Kind == b.Kind && a.Pattern == b.Pattern {
+		return true, "same route"
+	}
+
+	// Regular expressions are only compared to the paths of the other routes
+	if a.Kind == RouteRegex && b.Kind == RouteRegex {
+		return false, ""
+	}
+	if a.Kind == RouteRegex || b.Kind == RouteRegex {
+		re, other := a, b
+		if b.Kind == RouteRegex {
+			re, other = b, a
+		}
+		if re.match(other.Pattern) >= 0 {
+			return false, fmt.Sprintf("regular expression matches %s, which routes by %s first", other.Pattern, other.Kind)
+		}
+		return false, ""
+	}
+
+	if a.Kind == RouteExact && b.Kind == RouteExact {
+		return false, ""
+	}
+	if a.Kind == RoutePrefix && b.Kind == RoutePrefix {
+		if isPathPrefix(a.Pattern, b.Pattern) || isPathPrefix(b.Pattern, a.Pattern) {
+			return false, "nested prefixes, the longest prefix wins"
+		}
+		return false, ""
+	}
+
+	exact, prefix := a, b
+	if b.Kind == RouteExact {
+		exact, prefix = b, a
+	}
+	if exact.Pattern == prefix.Pattern || isPathPrefix(prefix.Pattern, exact.Pattern) {
+		return false, fmt.Sprintf("exact route %s takes precedence over the prefix", exact.Pattern)
+	}
+	return false, ""
+}
+
+// isPathPrefix reports whether prefix is a segment prefix of p
+func isPathPrefix(prefix, p string) bool {
+	return strings.HasPrefix(p, prefix) && len(p) > len(prefix) && p[len(prefix)] == '/'
+}
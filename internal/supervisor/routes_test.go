@@ -0,0 +1,162 @@
+package supervisor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// supervisorWithRoutes returns a supervisor routing the listen URLs of a
+// running proxy
+func supervisorWithRoutes(t *testing.T, proxyID string, listenURLs []proxy.ListenURL) *Supervisor {
+	t.Helper()
+	routes, hosts, err := listenRoutes(proxyID, listenURLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := newRoutingSnapshot()
+	for _, route := range routes {
+		if err := snapshot.routes.add(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, host := range hosts {
+		if err := snapshot.hosts.add(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Supervisor{virtualHandler: newVirtualHostHandler()}
+	s.virtualHandler.snapshot.Store(snapshot)
+	return s
+}
+
+func pathKey(key string) proxy.ListenURL {
+	return proxy.ListenURL{PathKey: &key}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	s := supervisorWithRoutes(t, "running", []proxy.ListenURL{
+		pathKey("shop"),
+		pathKey("=checkout"),
+		pathKey("~v[0-9]+"),
+		{ListenURL: "http://example.com"},
+		{ListenURL: "https://app.example.com/admin"},
+	})
+
+	// Conflicts by route of the running proxy, true when ambiguous
+	tests := []struct {
+		name      string
+		proxyID   string
+		listenURL proxy.ListenURL
+		want      map[string]bool
+	}{
+		{name: "same prefix", listenURL: pathKey("shop"), want: map[string]bool{"shop": true}},
+		{name: "nested prefix", listenURL: pathKey("shop/promo"), want: map[string]bool{"shop": false}},
+		{name: "enclosing prefix", listenURL: pathKey("sh"), want: nil},
+		{name: "exact under prefix", listenURL: pathKey("=shop/cart"), want: map[string]bool{"shop": false}},
+		{name: "same exact", listenURL: pathKey("=checkout"), want: map[string]bool{"=checkout": true}},
+		{name: "prefix of exact", listenURL: pathKey("checkout"), want: map[string]bool{"=checkout": false}},
+		{name: "same regex", listenURL: pathKey("~v[0-9]+"), want: map[string]bool{"~v[0-9]+": true}},
+		{name: "prefix matched by regex", listenURL: pathKey("v2"), want: map[string]bool{"~v[0-9]+": false}},
+		{name: "regex matching prefix", listenURL: pathKey("~sh[a-z]p"), want: map[string]bool{"shop": false}},
+		{name: "other regex", listenURL: pathKey("~api/v[0-9]+"), want: nil},
+		{name: "independent prefix", listenURL: pathKey("blog"), want: nil},
+		{name: "same proxy", proxyID: "running", listenURL: pathKey("shop"), want: nil},
+		{name: "same host", listenURL: proxy.ListenURL{ListenURL: "http://example.com"},
+			want: map[string]bool{"http://example.com": true}},
+		{name: "host path", listenURL: proxy.ListenURL{ListenURL: "http://example.com/api"},
+			want: map[string]bool{"http://example.com": false}},
+		{name: "nested host path", listenURL: proxy.ListenURL{ListenURL: "https://app.example.com/admin/users"},
+			want: map[string]bool{"https://app.example.com/admin": false}},
+		{name: "other scheme", listenURL: proxy.ListenURL{ListenURL: "http://app.example.com/admin"}, want: nil},
+		{name: "other host", listenURL: proxy.ListenURL{ListenURL: "http://other.example.com"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflicts, err := s.CheckRoutes(tt.proxyID, []proxy.ListenURL{tt.listenURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]bool
+			for _, conflict := range conflicts {
+				if conflict.ProxyID != "running" {
+					t.Errorf("conflict with proxy %q, want running", conflict.ProxyID)
+				}
+				if conflict.Reason == "" {
+					t.Errorf("conflict with %s has no reason", conflict.With)
+				}
+				if got == nil {
+					got = make(map[string]bool)
+				}
+				got[conflict.With] = conflict.Ambiguous
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conflicts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRoutesInvalid(t *testing.T) {
+	s := supervisorWithRoutes(t, "running", nil)
+	for _, key := range []string{"", "/", "~", "~(", "../admin", "=a/../b"} {
+		if _, err := s.CheckRoutes("", []proxy.ListenURL{pathKey(key)}); err == nil {
+			t.Errorf("CheckRoutes(%q) succeeded, want an error", key)
+		}
+	}
+}
+
+func TestRouteTableMatch(t *testing.T) {
+	table := newRouteTable()
+	for _, key := range []string{"shop", "shop/promo", "=shop/cart", "=checkout", "~v[0-9]+", "~[a-z]+/items"} {
+		route, err := ParseRoute(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		route.ProxyID = key
+		if err := table.add(&route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path  string
+		route string // path key of the route, empty when none matches
+		rest  string
+	}{
+		{path: "/shop", route: "shop", rest: "/"},
+		{path: "/shop/42", route: "shop", rest: "/42"},
+		{path: "/shop/promo/42", route: "shop/promo", rest: "/42"},
+		{path: "/shop/cart", route: "=shop/cart", rest: "/"},
+		{path: "/shop/cart/", route: "=shop/cart", rest: "/"},
+		{path: "/shop/cart/1", route: "shop", rest: "/cart/1"},
+		{path: "/shopping", route: ""},
+		{path: "/checkout", route: "=checkout", rest: "/"},
+		{path: "/checkout/done", route: ""},
+		{path: "/v2/items", route: "~v[0-9]+", rest: "/items"},
+		{path: "/v2x", route: ""},
+		{path: "/blog/items/1", route: "~[a-z]+/items", rest: "/1"},
+		{path: "/", route: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			route, rest := table.match(tt.path)
+			switch {
+			case route == nil && tt.route != "":
+				t.Fatalf("no route, want %s", tt.route)
+			case route == nil:
+				return
+			case route.ProxyID != tt.route:
+				t.Fatalf("route %s, want %s", route.ProxyID, tt.route)
+			case rest != tt.rest:
+				t.Errorf("rest %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
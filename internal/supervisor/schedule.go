@@ -0,0 +1,80 @@
+package supervisor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ab-testing-service/internal/models"
+)
+
+const scheduleTick = 5 * time.Second
+
+// runSchedules applies the due steps of the proxy schedules until the context
+// is canceled
+func (s *Supervisor) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, proxyID := range s.dueSchedules(time.Now()) {
+				s.applyScheduleStep(ctx, proxyID)
+			}
+		}
+	}
+}
+
+// dueSchedules returns the IDs of the proxies with a schedule step due
+// according to their running config
+func (s *Supervisor) dueSchedules(now time.Time) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var due []string
+	for id, instance := range s.proxies {
+		if instance.Proxy == nil {
+			continue
+		}
+		schedule := instance.Proxy.Config.Settings.Schedule
+		if schedule == nil {
+			continue
+		}
+		// Schedules wait while the proxy is not running, drafts are started
+		// by their schedule
+		state := instance.Proxy.State()
+		if state != models.ProxyStateRunning && !schedule.StartsAt(state, now) {
+			continue
+		}
+		if _, ok := schedule.DueStep(now); ok {
+			due = append(due, id)
+		}
+	}
+	return due
+}
+
+// applyScheduleStep applies the due step in storage, then reloads the proxy
+// and broadcasts the change like an update made through the API. When another
+// instance applied the step first, the proxy is only reloaded.
+func (s *Supervisor) applyScheduleStep(ctx context.Context, proxyID string) {
+	step, err := s.storage.ApplyDueScheduleStep(ctx, proxyID, time.Now())
+	if err != nil {
+		log.Printf("Failed to apply schedule of proxy %s: %v", proxyID, err)
+		return
+	}
+	if step != nil {
+		log.Printf("Applied schedule step %s of proxy %s: %v", step.Name, proxyID, step.Weights)
+	}
+
+	cfg, err := s.storage.GetProxyConfig(ctx, proxyID)
+	if err != nil {
+		log.Printf("Failed to get proxy config %s: %v", proxyID, err)
+		return
+	}
+	if err := s.UpdateProxy(ctx, cfg); err != nil {
+		log.Printf("Failed to update proxy %s: %v", proxyID, err)
+	}
+}
@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ab-testing-service/internal/proxy"
+)
+
+// routingSnapshot is the routing table of the running proxies. It is never
+// changed once published: every change builds a new snapshot, so requests read
+// it without locking and never see a change half applied. Requests routed by a
+// previous snapshot finish on the proxies it holds.
+type routingSnapshot struct {
+	hosts   *hostTable              // listen addresses, see hostTable
+	routes  *routeTable             // path routes, see Route
+	proxies map[string]*proxy.Proxy // by ID
+}
+
+func newRoutingSnapshot() *routingSnapshot {
+	return &routingSnapshot{
+		hosts:   newHostTable(),
+		routes:  newRouteTable(),
+		proxies: make(map[string]*proxy.Proxy),
+	}
+}
+
+// buildSnapshot returns the routing table of proxies. It fails when two proxies
+// have the same listen address or path route.
+func buildSnapshot(proxies map[string]*proxy.Proxy) (*routingSnapshot, error) {
+	ids := make([]string, 0, len(proxies))
+	for id := range proxies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	snapshot := newRoutingSnapshot()
+	for _, id := range ids {
+		if err := snapshot.add(proxies[id]); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// add routes the listen URLs of a proxy, only while the snapshot is built
+func (rs *routingSnapshot) add(p *proxy.Proxy) error {
+	routes, hosts, err := listenRoutes(p.ID, p.Config.ListenURLs)
+	if err != nil {
+		return fmt.Errorf("proxy %s: %w", p.ID, err)
+	}
+	for _, route := range routes {
+		route.proxy = p
+		if err := rs.routes.add(route); err != nil {
+			return err
+		}
+	}
+	for _, host := range hosts {
+		host.proxy = p
+		if err := rs.hosts.add(host); err != nil {
+			return err
+		}
+	}
+	rs.proxies[p.ID] = p
+	return nil
+}
+
+// routeProxy publishes the routing table of the running proxies with p in place
+// of the proxy with the same ID, without it when p is nil. Nothing is changed
+// when the routes of p conflict with the routes of another proxy. Must be
+// called with s.mutex held.
+func (s *Supervisor) routeProxy(id string, p *proxy.Proxy) error {
+	proxies := make(map[string]*proxy.Proxy, len(s.proxies)+1)
+	for proxyID, instance := range s.proxies {
+		if instance.Proxy != nil {
+			proxies[proxyID] = instance.Proxy
+		}
+	}
+	if p != nil {
+		proxies[id] = p
+	} else {
+		delete(proxies, id)
+	}
+
+	snapshot, err := buildSnapshot(proxies)
+	if err != nil {
+		return err
+	}
+	s.virtualHandler.snapshot.Store(snapshot)
+	return nil
+}
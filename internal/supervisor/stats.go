@@ -3,16 +3,54 @@ package supervisor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+const (
+	defaultStatsSpoolDir      = "spool/stats"
+	defaultStatsSpoolMaxBytes = 100 << 20
+
+	statsBatchSize = 100
+	statsRetryMin  = time.Second
+	statsRetryMax  = time.Minute
+)
+
+// collectStatistics moves the statistics of the running proxies to the spool,
+// runStatsSender sends them to Kafka. Without a spool they are sent right away
+// and lost when Kafka fails.
 func (s *Supervisor) collectStatistics(ctx context.Context) {
+	messages := s.statisticsMessages()
+	if len(messages) == 0 {
+		return
+	}
+
+	if s.statsSpool == nil {
+		msgs := make([]kafka.Message, len(messages))
+		for i, msg := range messages {
+			msgs[i] = kafka.Message{Value: msg}
+		}
+		if err := s.statsWriter.WriteMessages(ctx, msgs...); err != nil {
+			log.Printf("Error writing %d statistics messages: %v", len(msgs), err)
+		}
+		return
+	}
+
+	if err := s.statsSpool.Append(messages...); err != nil {
+		log.Printf("Error spooling statistics: %v", err)
+	}
+}
+
+// statisticsMessages flushes the statistics of the running proxies into
+// Kafka messages, one per target. The lock is only held while flushing.
+func (s *Supervisor) statisticsMessages() [][]byte {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	var messages [][]byte
 	for _, instance := range s.proxies {
 		if !instance.Started {
 			continue
@@ -24,14 +62,15 @@ func (s *Supervisor) collectStatistics(ctx context.Context) {
 			continue
 		}
 
-		// Get current stats
-		currentStats := stats.GetStats()
+		// Take the stats collected since the previous run
+		currentStats := stats.Flush()
 
 		for targetID, targetStats := range currentStats {
-			// Преобразуем map[string]struct{} в []string для JSON
-			uniqueUsers := make([]string, 0, len(targetStats.UniqueUsers))
-			for userID := range targetStats.UniqueUsers {
-				uniqueUsers = append(uniqueUsers, userID)
+			// Distinct users of the bucket, merged with the other buckets when queried
+			usersSketch, err := targetStats.Users.MarshalBinary()
+			if err != nil {
+				log.Printf("Error marshaling users sketch: %v", err)
+				continue
 			}
 
 			statsMsg := map[string]interface{}{
@@ -40,29 +79,89 @@ func (s *Supervisor) collectStatistics(ctx context.Context) {
 				"timestamp":     time.Now().Unix(),
 				"request_count": targetStats.RequestCount,
 				"error_count":   targetStats.ErrorCount,
-				"unique_users":  uniqueUsers,
+				"users_sketch":  usersSketch,
+				"conversions":   targetStats.Conversions,
 			}
 
 			msgBytes, err := json.Marshal(statsMsg)
-			log.Printf("Statistics message: %v", statsMsg)
+			log.Printf("Statistics message: proxy %s, target %s, %d requests, %d errors, about %d users",
+				instance.Proxy.Config.ID, targetID, targetStats.RequestCount, targetStats.ErrorCount,
+				targetStats.Users.Count())
 			if err != nil {
 				log.Printf("Error marshaling message: %v", err)
 				continue
 			}
+			messages = append(messages, msgBytes)
+		}
+	}
+	return messages
+}
 
-			err = s.kafkaWriter.WriteMessages(ctx,
-				kafka.Message{
-					Value: msgBytes,
-				},
-			)
-			if err != nil {
-				log.Printf("Error writing message: %v", err)
+// runStatsSender sends the spooled statistics to Kafka in order until the
+// context is canceled, retrying with exponential backoff while Kafka fails.
+// Statistics left in the spool are sent after a restart.
+func (s *Supervisor) runStatsSender(ctx context.Context) {
+	var retry time.Duration // zero once the spool was sent
+	for {
+		if retry > 0 {
+			timer := time.NewTimer(retry)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.statsSpool.Ready():
 			}
 		}
 
-		// Reset stats after successful sending
-		stats.Reset()
+		if err := s.sendSpooledStatistics(ctx); err != nil {
+			retry = min(max(2*retry, statsRetryMin), statsRetryMax)
+			log.Printf("Error writing statistics, retrying in %s: %v", retry, err)
+			continue
+		}
+		retry = 0
 	}
+}
+
+// sendSpooledStatistics sends batches of spooled statistics until the spool is
+// empty or Kafka fails
+func (s *Supervisor) sendSpooledStatistics(ctx context.Context) error {
+	for {
+		records := s.statsSpool.Peek(statsBatchSize)
+		if len(records) == 0 {
+			return nil
+		}
 
-	//log.Printf("Statistics collected")
+		msgs := make([]kafka.Message, len(records))
+		for i, record := range records {
+			msgs[i] = kafka.Message{Value: record.Data}
+		}
+
+		sent := records
+		err := s.statsWriter.WriteMessages(ctx, msgs...)
+		if err != nil {
+			// Keep the messages that failed, the others were written
+			sent = nil
+			var writeErrors kafka.WriteErrors
+			if errors.As(err, &writeErrors) {
+				for i, writeErr := range writeErrors {
+					if writeErr == nil {
+						sent = append(sent, records[i])
+					}
+				}
+			}
+		}
+
+		if removeErr := s.statsSpool.Remove(sent...); removeErr != nil {
+			log.Printf("Error removing sent statistics from the spool: %v", removeErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
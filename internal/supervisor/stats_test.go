@@ -0,0 +1,75 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ab-testing-service/internal/spool"
+)
+
+// failingWriter fails the messages whose value is in failed, once
+type failingWriter struct {
+	failed  map[string]bool
+	written []string
+}
+
+func (w *failingWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	var writeErrors kafka.WriteErrors
+	for _, msg := range msgs {
+		if w.failed[string(msg.Value)] {
+			delete(w.failed, string(msg.Value))
+			writeErrors = append(writeErrors, errors.New("leader not available"))
+			continue
+		}
+		writeErrors = append(writeErrors, nil)
+		w.written = append(w.written, string(msg.Value))
+	}
+	if writeErrors.Count() > 0 {
+		return writeErrors
+	}
+	return nil
+}
+
+func TestSendSpooledStatisticsPartialFailure(t *testing.T) {
+	statsSpool, err := spool.Open(t.Name(), t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"a", "b", "c", "d"} {
+		if err := statsSpool.Append([]byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writer := &failingWriter{failed: map[string]bool{"b": true, "d": true}}
+	s := &Supervisor{statsWriter: writer, statsSpool: statsSpool}
+
+	var writeErrors kafka.WriteErrors
+	if err := s.sendSpooledStatistics(context.Background()); !errors.As(err, &writeErrors) {
+		t.Fatalf("sendSpooledStatistics() = %v, want the write errors", err)
+	}
+
+	// Only the failed messages are kept, in order
+	var left []string
+	for _, record := range statsSpool.Peek(10) {
+		left = append(left, string(record.Data))
+	}
+	if want := []string{"b", "d"}; !reflect.DeepEqual(left, want) {
+		t.Fatalf("spooled after the failure = %v, want %v", left, want)
+	}
+
+	// The retry sends them once
+	if err := s.sendSpooledStatistics(context.Background()); err != nil {
+		t.Fatalf("sendSpooledStatistics() retry = %v", err)
+	}
+	if want := []string{"a", "c", "b", "d"}; !reflect.DeepEqual(writer.written, want) {
+		t.Errorf("written = %v, want %v", writer.written, want)
+	}
+	if records := statsSpool.Peek(10); len(records) != 0 {
+		t.Errorf("%d records left in the spool", len(records))
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 
+	"github.com/ab-testing-service/internal/certs"
 	"github.com/ab-testing-service/internal/config"
+	"github.com/ab-testing-service/internal/geoip"
+	"github.com/ab-testing-service/internal/models"
+	"github.com/ab-testing-service/internal/notify"
 	"github.com/ab-testing-service/internal/proxy"
+	"github.com/ab-testing-service/internal/spool"
 	"github.com/ab-testing-service/internal/storage"
 )
 
@@ -24,16 +30,24 @@ type Supervisor struct {
 	config         *config.Config
 	storage        *storage.Storage
 	kafkaWriter    *kafka.Writer
+	statsWriter    proxy.MessageWriter // the Kafka writer, replaced in tests
 	mutex          sync.RWMutex
 	pubsub         *proxy.RedisPubSub
-	server         *http.Server
+	servers        []*http.Server
 	virtualHandler *VirtualHostHandler
+	certs          *certs.Store
+	runtime        *proxy.Runtime
+	health         *healthChecker
+	notifier       *notify.Notifier
+	instance       models.Instance // registration of this instance, see runHeartbeat
+	statsSpool     *spool.Spool    // statistics waiting for Kafka, nil when the spool can't be opened
 }
 
 type Config struct {
 	Config      *config.Config
 	Storage     *storage.Storage
 	KafkaWriter *kafka.Writer
+	Version     string // build version, reported in the instance registry
 }
 
 func NewSupervisor(cfg Config) *Supervisor {
@@ -42,10 +56,71 @@ func NewSupervisor(cfg Config) *Supervisor {
 		config:      cfg.Config,
 		storage:     cfg.Storage,
 		kafkaWriter: cfg.KafkaWriter,
+		statsWriter: cfg.KafkaWriter,
+		health:      newHealthChecker(),
+		notifier:    notify.New(cfg.Config.Notifications.WebhookURL),
+
+		virtualHandler: newVirtualHostHandler(),
+	}
+
+	tlsCfg := cfg.Config.TLS
+	s.certs = certs.New(tlsCfg.Certificates, tlsCfg.EncryptionKey, time.Duration(tlsCfg.ReloadInterval)*time.Second,
+		cfg.Storage.GetCertificates)
+
+	signingKey := cfg.Config.Proxy.CookieSecret
+	if signingKey == "" {
+		signingKey = cfg.Config.JWT.Secret
+	}
+	s.runtime = &proxy.Runtime{
+		SigningKey:  []byte(signingKey),
+		Assignments: proxy.NewAssignmentStore(cfg.Storage.Redis),
+		Exposures:   proxy.NewExposureLog(cfg.KafkaWriter),
+		Health:      proxy.NewHealthRegistry(),
 	}
 
-	// Initialize Redis pub/sub with update callback
-	s.pubsub = proxy.NewRedisPubSub(cfg.Storage.Redis, s.handleProxyUpdate)
+	exclusions, err := proxy.NewExclusionRules(cfg.Config.Exclusion)
+	if err != nil {
+		log.Printf("Failed to compile global exclusion rules: %v", err)
+	}
+	s.runtime.Exclusions = exclusions
+
+	if path := cfg.Config.GeoIP.Database; path != "" {
+		interval := time.Duration(cfg.Config.GeoIP.ReloadInterval) * time.Second
+		geo, err := geoip.Open(path, interval)
+		if err != nil {
+			log.Printf("Failed to load GeoIP database %s, geo conditions match nothing until it loads: %v", path, err)
+		}
+		s.runtime.Geo = geo
+	}
+
+	spoolDir := cfg.Config.Stats.SpoolDir
+	if spoolDir == "" {
+		spoolDir = defaultStatsSpoolDir
+	}
+	spoolMaxBytes := cfg.Config.Stats.SpoolMaxBytes
+	if spoolMaxBytes <= 0 {
+		spoolMaxBytes = defaultStatsSpoolMaxBytes
+	}
+	statsSpool, err := spool.Open("stats", spoolDir, spoolMaxBytes)
+	if err != nil {
+		log.Printf("Failed to open statistics spool %s, statistics are lost while Kafka fails: %v", spoolDir, err)
+	} else {
+		s.statsSpool = statsSpool
+	}
+
+	// Initialize Redis pub/sub with change callback
+	s.pubsub = proxy.NewRedisPubSub(cfg.Storage.Redis, s.handleProxyChange)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to get hostname: %v", err)
+	}
+	s.instance = models.Instance{
+		ID:        s.pubsub.InstanceID(),
+		Hostname:  hostname,
+		Version:   cfg.Version,
+		StartedAt: time.Now(),
+	}
 
 	return s
 }
@@ -56,6 +131,31 @@ func (s *Supervisor) Start(ctx context.Context) {
 		log.Printf("Failed to start Redis subscriber: %v", err)
 	}
 
+	// Start saving visitor assignments for conversion attribution
+	go s.runtime.Assignments.Run(ctx)
+
+	// Start shipping exposure events
+	go s.runtime.Exposures.Run(ctx)
+
+	// Start reloading the GeoIP database when its file changes
+	go s.runtime.Geo.Watch(ctx)
+
+	// Load the TLS certificates and reload them when they change
+	if err := s.certs.Reload(ctx); err != nil {
+		log.Printf("Failed to load TLS certificates: %v", err)
+	}
+	go s.certs.Watch(ctx)
+
+	// Start serving the proxies, they are routed as they are loaded
+	s.startListeners()
+
+	// Save conditions created before targeting rules as rules. A failure only
+	// delays it to the next start: proxies still convert legacy conditions to
+	// rules when they are loaded, see proxy.compileRules.
+	if err := s.storage.MigrateLegacyConditions(ctx); err != nil {
+		log.Printf("Failed to migrate legacy conditions: %v", err)
+	}
+
 	// Load existing proxies configs from cached Postgres
 	configs, err := s.storage.GetProxies(ctx)
 	if err != nil {
@@ -80,11 +180,31 @@ func (s *Supervisor) Start(ctx context.Context) {
 		if err := s.storage.SaveProxyConfig(ctx, cfg); err != nil {
 			log.Printf("Failed to save proxy config %s: %v", cfg.ID, err)
 		}
-		if err := s.CreateProxy(cfg); err != nil {
+		if _, err := s.applyConfig(cfg); err != nil {
 			log.Printf("Failed to create proxy %s: %v", cfg.ID, err)
 		}
 	}
 
+	// Start converging with the proxy changes of the other instances
+	go s.runReconciler(ctx)
+
+	// Start registering this instance with the config versions it runs
+	go s.runHeartbeat(ctx)
+
+	// Start checking the health of the targets
+	go s.runHealthChecks(ctx)
+
+	// Start applying proxy schedules
+	go s.runSchedules(ctx)
+
+	// Start evaluating guardrails
+	go s.runGuardrails(ctx)
+
+	// Start sending the spooled statistics, left by a previous run too
+	if s.statsSpool != nil {
+		go s.runStatsSender(ctx)
+	}
+
 	// Start statistics collection
 	go func() {
 		log.Printf("Starting statistics collection")
@@ -102,19 +222,32 @@ func (s *Supervisor) Start(ctx context.Context) {
 }
 
 func (s *Supervisor) Shutdown(ctx context.Context) error {
+	// Spool the last statistics, they are sent after the restart
+	s.collectStatistics(ctx)
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	var lastErr error
 
-	// Shutdown the main server if it exists
-	if s.server != nil {
-		if err := s.server.Shutdown(ctx); err != nil {
-			log.Printf("Error shutting down main server: %v", err)
+	// Shutdown the proxy listeners
+	for _, server := range s.servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down proxy listener %s: %v", server.Addr, err)
 			lastErr = err
 		}
 	}
 
+	// Unregister this instance right away instead of waiting for its expiry
+	if err := s.storage.DeleteInstance(ctx, s.instance.ID); err != nil {
+		log.Printf("Failed to unregister instance %s: %v", s.instance.ID, err)
+	}
+
 	s.kafkaWriter.Close()
 	return lastErr
 }
+
+// Certificates returns the TLS certificates of the listeners
+func (s *Supervisor) Certificates() *certs.Store {
+	return s.certs
+}
@@ -2,27 +2,36 @@ package supervisor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/ab-testing-service/internal/proxy"
+	"github.com/ab-testing-service/internal/storage"
 )
 
-func (s *Supervisor) DeleteProxy(ctx context.Context, id string) error {
+// DeleteProxy stops a deleted proxy and broadcasts the delete, at the config
+// version of the tombstone, to the other instances
+func (s *Supervisor) DeleteProxy(ctx context.Context, id string, version int64) error {
+	if err := s.removeProxy(ctx, id); err != nil {
+		return err
+	}
+
+	// Publish change to other instances
+	if err := s.pubsub.PublishChange(ctx, proxy.OperationDelete, id, version); err != nil {
+		log.Printf("Failed to publish delete of proxy %s: %v", id, err)
+	}
+	return nil
+}
+
+// removeProxy stops a proxy on this instance
+func (s *Supervisor) removeProxy(ctx context.Context, id string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Get the instance before deleting
-	instance, exists := s.proxies[id]
-	if exists && instance.Proxy != nil {
-		// Remove from virtual host handler
-		for _, item := range instance.Proxy.Config.ListenURLs {
-			host := strings.Split(item.ListenURL, ":")[0]
-			if s.virtualHandler != nil {
-				delete(s.virtualHandler.proxies, host)
-			}
-		}
+	// Remove from virtual host handler
+	if err := s.routeProxy(id, nil); err != nil {
+		return fmt.Errorf("failed to unroute proxy: %w", err)
 	}
 
 	// Remove from proxies map
@@ -31,64 +40,88 @@ func (s *Supervisor) DeleteProxy(ctx context.Context, id string) error {
 	return s.storage.InvalidateProxyCache(ctx, id)
 }
 
-// handleProxyUpdate is called when a proxy settings change notification is received
-func (s *Supervisor) handleProxyUpdate(ctx context.Context, proxyID string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// handleProxyChange is called when a proxy change notification of another
+// instance is received. Configs are loaded from PostgreSQL, messages older
+// than the running config are ignored.
+func (s *Supervisor) handleProxyChange(ctx context.Context, msg proxy.SettingsChangeMessage) error {
+	if msg.Operation == proxy.OperationDelete {
+		return s.removeProxy(ctx, msg.ProxyID)
+	}
 
-	// Get the latest config from storage
-	cfg, err := s.storage.GetProxyConfig(ctx, proxyID)
+	if version, ok := s.appliedVersions()[msg.ProxyID]; ok && msg.Version > 0 && msg.Version <= version {
+		return nil
+	}
+
+	cfg, err := s.storage.LoadProxyConfig(ctx, msg.ProxyID)
+	if errors.Is(err, storage.ErrProxyNotFound) {
+		// Deleted since the message was sent
+		return s.removeProxy(ctx, msg.ProxyID)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get proxy config: %w", err)
 	}
 
-	return s.UpdateProxy(ctx, cfg)
+	_, err = s.applyConfig(cfg)
+	return err
 }
 
 func (s *Supervisor) UpdateProxy(ctx context.Context, cfg proxy.Config) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	instance, exists := s.proxies[cfg.ID]
+	s.mutex.RLock()
+	_, exists := s.proxies[cfg.ID]
+	s.mutex.RUnlock()
 	if !exists {
 		return fmt.Errorf("proxy %s not found", cfg.ID)
 	}
 
-	// Get old and new hosts
-	oldHosts := make(map[string]bool)
-	if instance.Proxy != nil {
-		for _, lu := range instance.Proxy.Config.ListenURLs {
-			host := strings.Split(lu.ListenURL, ":")[0]
-			oldHosts[host] = true
-		}
+	applied, err := s.applyConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return nil // A more recent config is running already
 	}
 
-	newHosts := make(map[string]bool)
-	for _, lu := range cfg.ListenURLs {
-		host := strings.Split(lu.ListenURL, ":")[0]
-		newHosts[host] = true
+	if err := s.storage.InvalidateProxyCache(ctx, cfg.ID); err != nil {
+		return fmt.Errorf("failed to invalidate proxy cache: %w", err)
 	}
 
-	// Create new proxy with updated config
-	newProxy, err := proxy.NewProxy(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create new proxy: %w", err)
+	if err := s.storage.SaveProxyConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("failed to update proxy config: %w", err)
 	}
 
-	// Update virtual host handler
-	if s.virtualHandler != nil {
-		// Removing old hosts that are not in the new config
-		for oldHost := range oldHosts {
-			if !newHosts[oldHost] {
-				delete(s.virtualHandler.proxies, oldHost)
-			}
-		}
+	// Publish change to other instances
+	if err := s.pubsub.PublishChange(ctx, proxy.OperationUpdate, cfg.ID, cfg.Version); err != nil {
+		log.Printf("Failed to publish settings change: %v", err)
+	}
 
-		// Adding new hosts
-		for _, lu := range cfg.ListenURLs {
-			host := strings.Split(lu.ListenURL, ":")[0]
-			s.virtualHandler.proxies[host] = newProxy
-		}
+	return nil
+}
+
+// applyConfig runs a proxy config on this instance, in place of the running
+// config of the proxy. Configs with a version not above the running one are
+// skipped, it reports whether cfg was applied.
+func (s *Supervisor) applyConfig(cfg proxy.Config) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	instance, exists := s.proxies[cfg.ID]
+	if exists && instance.Proxy != nil && cfg.Version > 0 && cfg.Version <= instance.Proxy.Config.Version {
+		return false, nil
+	}
+
+	// Create new proxy with the config
+	newProxy, err := proxy.NewProxy(cfg, s.runtime)
+	if err != nil {
+		return false, fmt.Errorf("failed to create new proxy: %w", err)
+	}
+	if exists && instance.Proxy != nil {
+		newProxy.InheritStats(instance.Proxy)
+	}
+
+	// Update virtual host handler, replacing the hosts and path routes of the
+	// old proxy. Requests already routed to the old proxy finish on it.
+	if err := s.routeProxy(cfg.ID, newProxy); err != nil {
+		return false, fmt.Errorf("failed to route proxy: %w", err)
 	}
 
 	// Update the instance
@@ -97,18 +130,19 @@ func (s *Supervisor) UpdateProxy(ctx context.Context, cfg proxy.Config) error {
 		Started: true,
 	}
 
-	if err := s.storage.InvalidateProxyCache(ctx, cfg.ID); err != nil {
-		return fmt.Errorf("failed to invalidate proxy cache: %w", err)
-	}
+	return true, nil
+}
 
-	if err := s.storage.SaveProxyConfig(ctx, cfg); err != nil {
-		return fmt.Errorf("failed to update proxy config: %w", err)
-	}
+// appliedVersions returns the config versions of the running proxies by ID
+func (s *Supervisor) appliedVersions() map[string]int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	// Publish change to other instances
-	if err := s.pubsub.PublishSettingsChange(ctx, cfg.ID); err != nil {
-		log.Printf("Failed to publish settings change: %v", err)
+	versions := make(map[string]int64, len(s.proxies))
+	for id, instance := range s.proxies {
+		if instance.Proxy != nil {
+			versions[id] = instance.Proxy.Config.Version
+		}
 	}
-
-	return nil
+	return versions
 }
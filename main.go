@@ -20,6 +20,9 @@ import (
 	"github.com/ab-testing-service/internal/supervisor"
 )
 
+// version is the build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	cfg, err := config.Load("config/config.yaml")
 	if err != nil {
@@ -68,6 +71,7 @@ func main() {
 		Config:      cfg,
 		Storage:     store,
 		KafkaWriter: kw,
+		Version:     version,
 	})
 
 	// Create and start HTTP server
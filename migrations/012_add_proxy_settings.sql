@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Per-proxy feature settings (bucketing, cookies, ...) stored as a single document
+ALTER TABLE proxies
+    ADD COLUMN settings JSONB NOT NULL DEFAULT '{}'::jsonb;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE proxies
+    DROP COLUMN settings;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Conversions per target and goal, aggregated like proxy_stats
+CREATE TABLE proxy_conversions
+(
+    id          SERIAL PRIMARY KEY,
+    proxy_id    VARCHAR(255)     NOT NULL,
+    target_id   VARCHAR(255)     NOT NULL,
+    goal_id     VARCHAR(255)     NOT NULL,
+    timestamp   TIMESTAMP        NOT NULL,
+    conversions INTEGER          NOT NULL DEFAULT 0,
+    revenue     DOUBLE PRECISION NOT NULL DEFAULT 0,
+    FOREIGN KEY (proxy_id) REFERENCES proxies (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_proxy_conversions_proxy_id ON proxy_conversions (proxy_id);
+CREATE INDEX idx_proxy_conversions_timestamp ON proxy_conversions (timestamp);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE proxy_conversions;
+-- +goose StatementEnd
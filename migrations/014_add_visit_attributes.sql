@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Visits are exposure events: they outlive targets, and targets are recreated on update
+ALTER TABLE visits
+    DROP CONSTRAINT IF EXISTS fk_target,
+    DROP CONSTRAINT IF EXISTS visits_target_id_fkey,
+    DROP CONSTRAINT IF EXISTS visits_proxy_id_fkey;
+
+ALTER TABLE visits
+    ADD COLUMN rule       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN method     VARCHAR(16)  NOT NULL DEFAULT '',
+    ADD COLUMN host       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN path       TEXT         NOT NULL DEFAULT '',
+    ADD COLUMN ip         VARCHAR(64)  NOT NULL DEFAULT '',
+    ADD COLUMN user_agent TEXT         NOT NULL DEFAULT '',
+    ADD COLUMN referer    TEXT         NOT NULL DEFAULT '',
+    ADD COLUMN language   VARCHAR(64)  NOT NULL DEFAULT '';
+
+CREATE INDEX idx_visits_created_at ON visits (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_visits_created_at;
+
+ALTER TABLE visits
+    DROP COLUMN rule,
+    DROP COLUMN method,
+    DROP COLUMN host,
+    DROP COLUMN path,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent,
+    DROP COLUMN referer,
+    DROP COLUMN language;
+
+-- Visits of targets and proxies deleted since then would fail the checks, the
+-- constraints only apply to new rows
+ALTER TABLE visits
+    ADD CONSTRAINT visits_proxy_id_fkey FOREIGN KEY (proxy_id) REFERENCES proxies (id) NOT VALID,
+    ADD CONSTRAINT visits_target_id_fkey FOREIGN KEY (target_id) REFERENCES targets (id) NOT VALID,
+    ADD CONSTRAINT fk_target FOREIGN KEY (target_id) REFERENCES targets (id) ON DELETE CASCADE NOT VALID;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Existing proxies are live already, new proxies are created as drafts by the API
+ALTER TABLE proxies
+    ADD COLUMN state VARCHAR(20) NOT NULL DEFAULT 'running';
+
+CREATE INDEX idx_proxies_state ON proxies (state);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_proxies_state;
+
+ALTER TABLE proxies
+    DROP COLUMN state;
+-- +goose StatementEnd
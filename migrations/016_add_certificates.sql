@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- TLS certificates uploaded through the API, private keys are encrypted by the service
+CREATE TABLE certificates
+(
+    host          VARCHAR(255) PRIMARY KEY,
+    cert_pem      TEXT         NOT NULL,
+    encrypted_key BYTEA        NOT NULL,
+    not_after     TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by    VARCHAR(255),
+    created_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE certificates;
+-- +goose StatementEnd
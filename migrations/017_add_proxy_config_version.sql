@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Version of the proxy config, increased by every change to the proxy, its targets or its listen URLs, so that
+-- instances can tell whether the config they run is current
+ALTER TABLE proxies
+    ADD COLUMN config_version BIGINT NOT NULL DEFAULT 1;
+
+CREATE FUNCTION bump_proxy_config_version() RETURNS TRIGGER AS
+$$
+BEGIN
+    -- Changes of targets and listen URLs set the version already
+    IF NEW.config_version = OLD.config_version THEN
+        NEW.config_version = OLD.config_version + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER proxies_config_version
+    BEFORE UPDATE
+    ON proxies
+    FOR EACH ROW
+EXECUTE FUNCTION bump_proxy_config_version();
+
+CREATE FUNCTION bump_parent_proxy_config_version() RETURNS TRIGGER AS
+$$
+BEGIN
+    UPDATE proxies
+    SET config_version = config_version + 1
+    WHERE id = COALESCE(NEW.proxy_id, OLD.proxy_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER targets_config_version
+    AFTER INSERT OR UPDATE OR DELETE
+    ON targets
+    FOR EACH ROW
+EXECUTE FUNCTION bump_parent_proxy_config_version();
+
+CREATE TRIGGER proxy_listen_urls_config_version
+    AFTER INSERT OR UPDATE OR DELETE
+    ON proxy_listen_urls
+    FOR EACH ROW
+EXECUTE FUNCTION bump_parent_proxy_config_version();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER proxy_listen_urls_config_version ON proxy_listen_urls;
+DROP TRIGGER targets_config_version ON targets;
+DROP TRIGGER proxies_config_version ON proxies;
+DROP FUNCTION bump_parent_proxy_config_version();
+DROP FUNCTION bump_proxy_config_version();
+
+ALTER TABLE proxies
+    DROP COLUMN config_version;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- HyperLogLog sketch of the distinct users of a bucket, merged across buckets when queried. Buckets stored before
+-- keep the list of their users in unique_users.
+ALTER TABLE proxy_stats
+    ADD COLUMN users_sketch BYTEA;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE proxy_stats
+    DROP COLUMN users_sketch;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Deleted proxies are kept as tombstones, with their history, statistics, conversions and visits. Their listen URLs
+-- are removed, so that other proxies can use them.
+ALTER TABLE proxies
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Tombstones become archived proxies, deleting them would cascade away their history
+UPDATE proxies
+SET state = 'archived'
+WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE proxies
+    DROP COLUMN deleted_at;
+-- +goose StatementEnd
@@ -13,17 +13,92 @@ import (
 	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/segmentio/kafka-go"
 )
 
 type ProxyStats struct {
-	ProxyID      string   `json:"proxy_id"`
-	TargetID     string   `json:"target_id"`
-	Timestamp    int64    `json:"timestamp"`
-	RequestCount int      `json:"request_count"`
-	ErrorCount   int      `json:"error_count"`
-	UniqueUsers  []string `json:"unique_users"`
+	ProxyID      string                     `json:"proxy_id"`
+	TargetID     string                     `json:"target_id"`
+	Timestamp    int64                      `json:"timestamp"`
+	RequestCount int                        `json:"request_count"`
+	ErrorCount   int                        `json:"error_count"`
+	UniqueUsers  []string                   `json:"unique_users"` // sent before users sketches
+	UsersSketch  []byte                     `json:"users_sketch"` // HyperLogLog sketch of the users
+	Conversions  map[string]ConversionStats `json:"conversions"`  // key is goal ID
+}
+
+type ConversionStats struct {
+	Count   int     `json:"count"`
+	Revenue float64 `json:"revenue"`
+}
+
+// exposureMessageType marks messages carrying a batch of exposure events,
+// statistics messages have no type
+const exposureMessageType = "exposures"
+
+type MessageHeader struct {
+	Type string `json:"type"`
+}
+
+// Exposure is a visitor being sent to a target, stored in visits
+type Exposure struct {
+	ProxyID   string    `json:"proxy_id"`
+	TargetID  string    `json:"target_id"`
+	UserID    string    `json:"user_id"`
+	RID       string    `json:"rid"`
+	RRID      string    `json:"rrid"`
+	RUID      string    `json:"ruid"`
+	Timestamp time.Time `json:"timestamp"`
+	Rule      string    `json:"rule"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Referer   string    `json:"referer"`
+	Language  string    `json:"language"`
+}
+
+type ExposureBatch struct {
+	Type   string     `json:"type"`
+	Events []Exposure `json:"events"`
+}
+
+// insertVisits stores a batch of exposures with COPY in one transaction
+func insertVisits(ctx context.Context, db *sql.DB, events []Exposure) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("visits",
+		"id", "proxy_id", "target_id", "user_id", "rid", "rrid", "ruid", "rule",
+		"method", "host", "path", "ip", "user_agent", "referer", "language", "created_at",
+	))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		_, err = stmt.ExecContext(ctx,
+			uuid.New().String(), e.ProxyID, e.TargetID, e.UserID, e.RID, e.RRID, e.RUID, e.Rule,
+			e.Method, e.Host, e.Path, e.IP, e.UserAgent, e.Referer, e.Language, e.Timestamp,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Flush the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // checkKafkaConnection attempts to establish a connection to Kafka
@@ -59,6 +134,45 @@ func isTopicEmpty(r *kafka.Reader) bool {
 	return lag == 0
 }
 
+// insertStats stores the request and conversion counters of a message in one transaction
+func insertStats(ctx context.Context, db *sql.DB, stmt, conversionStmt *sql.Stmt,
+	stats *ProxyStats, timestamp time.Time, uniqueUsersJSON []byte) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.StmtContext(ctx, stmt).ExecContext(ctx,
+		stats.ProxyID,
+		stats.TargetID,
+		timestamp,
+		stats.RequestCount,
+		stats.ErrorCount,
+		uniqueUsersJSON,
+		stats.UsersSketch,
+	)
+	if err != nil {
+		return err
+	}
+
+	for goalID, conversions := range stats.Conversions {
+		_, err = tx.StmtContext(ctx, conversionStmt).ExecContext(ctx,
+			stats.ProxyID,
+			stats.TargetID,
+			goalID,
+			timestamp,
+			conversions.Count,
+			conversions.Revenue,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func main() {
 	// Context with cancellation for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -117,7 +231,22 @@ func main() {
 			timestamp, 
 			request_count, 
 			error_count, 
-			unique_users
+			unique_users,
+			users_sketch
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		log.Fatal("Error preparing statement:", err)
+	}
+
+	conversionStmt, err := db.Prepare(`
+		INSERT INTO proxy_conversions (
+			proxy_id,
+			target_id,
+			goal_id,
+			timestamp,
+			conversions,
+			revenue
 		) VALUES ($1, $2, $3, $4, $5, $6)
 	`)
 	if err != nil {
@@ -131,6 +260,7 @@ func main() {
 		defer r.Close()
 		defer db.Close()
 		defer stmt.Close()
+		defer conversionStmt.Close()
 
 		emptyTopicBackoff := 5 * time.Second
 
@@ -168,6 +298,32 @@ func main() {
 					continue
 				}
 
+				var header MessageHeader
+				if err := json.Unmarshal(m.Value, &header); err != nil {
+					log.Println("Error unmarshaling message:", err)
+					continue
+				}
+
+				if header.Type == exposureMessageType {
+					var batch ExposureBatch
+					if err := json.Unmarshal(m.Value, &batch); err != nil {
+						log.Println("Error unmarshaling exposures:", err)
+						continue
+					}
+
+					dbCtx, dbCancel := context.WithTimeout(ctx, 30*time.Second)
+					err = insertVisits(dbCtx, db, batch.Events)
+					dbCancel()
+
+					if err != nil {
+						log.Println("Error inserting visits into database:", err)
+						continue
+					}
+
+					log.Printf("Successfully stored %d exposures\n", len(batch.Events))
+					continue
+				}
+
 				// Process the message
 				var stats ProxyStats
 				if err := json.Unmarshal(m.Value, &stats); err != nil {
@@ -175,11 +331,15 @@ func main() {
 					continue
 				}
 
-				// Convert unique users to JSONB
-				uniqueUsersJSON, err := json.Marshal(stats.UniqueUsers)
-				if err != nil {
-					log.Println("Error marshaling unique users:", err)
-					continue
+				// Convert unique users of older messages to JSONB, newer
+				// messages carry a sketch of the users instead
+				var uniqueUsersJSON []byte
+				if stats.UniqueUsers != nil {
+					uniqueUsersJSON, err = json.Marshal(stats.UniqueUsers)
+					if err != nil {
+						log.Println("Error marshaling unique users:", err)
+						continue
+					}
 				}
 
 				// Convert Unix timestamp to time.Time
@@ -187,14 +347,7 @@ func main() {
 
 				// Insert into database with context
 				dbCtx, dbCancel := context.WithTimeout(ctx, 5*time.Second)
-				_, err = stmt.ExecContext(dbCtx,
-					stats.ProxyID,
-					stats.TargetID,
-					timestamp,
-					stats.RequestCount,
-					stats.ErrorCount,
-					uniqueUsersJSON,
-				)
+				err = insertStats(dbCtx, db, stmt, conversionStmt, &stats, timestamp, uniqueUsersJSON)
 				dbCancel()
 
 				if err != nil {